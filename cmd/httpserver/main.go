@@ -1,22 +1,33 @@
 package main
 
 import (
-	"crypto/sha256"
+	"context"
+	"crypto/tls"
 	"fmt"
-	"io"
 	"log"
-	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"github.com/allscorpion/build-http-from-scratch/internal/proxy"
 	"github.com/allscorpion/build-http-from-scratch/internal/request"
 	"github.com/allscorpion/build-http-from-scratch/internal/response"
 	"github.com/allscorpion/build-http-from-scratch/internal/server"
+	"github.com/allscorpion/build-http-from-scratch/internal/sse"
+	"github.com/allscorpion/build-http-from-scratch/internal/websocket"
 )
 
 const port = 42069
+const shutdownTimeout = 10 * time.Second
+
+var upgrader = &websocket.Upgrader{
+	MaxMessageSize:    1 << 20,
+	EnableCompression: true,
+}
 
 func generateHtml(statusCode response.StatusCode, statusText string, header string, body string) string {
 	return fmt.Sprintf(`<html>
@@ -40,119 +51,192 @@ func writeInternalServerError(w *response.Writer) {
 	w.WriteBody(body)
 }
 
-func main() {
-	server, err := server.Serve(port, func(w *response.Writer, req *request.Request) {
-		if after, ok := strings.CutPrefix(req.RequestLine.RequestTarget, "/httpbin/"); ok {
-			urlPath := after
-			fullUrl := fmt.Sprintf("https://httpbin.org/%v", urlPath)
-
-			resp, err := http.Get(fullUrl)
-
-			if err != nil {
-				writeInternalServerError(w)
-				return
-			}
+func handler(w *response.Writer, req *request.Request) {
+	if req.RequestLine.RequestTarget == "/video" {
+		data, err := os.ReadFile("assets/vim.mp4")
 
-			defer resp.Body.Close()
+		if err != nil {
+			fmt.Println(err)
+			writeInternalServerError(w)
+			return
+		}
 
-			w.WriteStatusLine(response.OKStatus)
-			h := response.GetDefaultHeaders(0)
-			h.Delete("content-length")
-			h.Set("transfer-encoding", "chunked")
-			h.Set("trailer", "X-Content-Sha256, X-Content-Length")
-			w.WriteHeaders(h)
+		w.WriteStatusLine(response.OKStatus)
+		h := response.GetDefaultHeaders(len(data))
+		h.Overwrite("content-type", "video/mp4")
+		w.WriteHeaders(h)
+		w.WriteBody(string(data))
 
-			buffer := make([]byte, 1024)
-			fullResponseBody := []byte{}
+		return
+	}
 
-			for {
-				bytesRead, err := resp.Body.Read(buffer)
+	if req.RequestLine.RequestTarget == "/events" {
+		events, err := sse.NewWriter(w)
 
-				fmt.Printf("%v bytes read\n", bytesRead)
+		if err != nil {
+			return
+		}
 
-				if err != nil {
-					if err == io.EOF {
-						fmt.Println("reached the end of the file")
-						break
-					}
-					fmt.Printf("an error has occured reading: %v\n", err)
-					continue
-				}
+		defer events.Close()
+		events.Heartbeat(req, 15*time.Second)
 
-				data := buffer[:bytesRead]
-				fullResponseBody = append(fullResponseBody, data...)
+		// Resume after the last event a reconnecting client saw.
+		start, _ := strconv.Atoi(sse.LastEventID(req))
 
-				bytesWritten, err := w.WriteChunkedBody(data)
+		for progress := start + 1; progress <= 10; progress++ {
+			select {
+			case <-req.Context().Done():
+				return
+			case <-time.After(time.Second):
+			}
 
-				if err != nil {
-					fmt.Printf("an error has occured writing: %v\n", err)
-					continue
-				}
+			err := events.Send(sse.Event{
+				ID:    strconv.Itoa(progress),
+				Event: "progress",
+				Data:  fmt.Sprintf("%d%%", progress*10),
+			})
 
-				fmt.Printf("%v bytes written\n", bytesWritten)
+			if err != nil {
+				return
 			}
+		}
 
-			w.WriteChunkedBodyDone()
-			fmt.Println("body has finished being written")
-			trailers := map[string]string{}
-			trailers["X-Content-Sha256"] = fmt.Sprintf("%x", sha256.Sum256(fullResponseBody))
-			trailers["X-Content-Length"] = fmt.Sprintf("%v", len(fullResponseBody))
-			// trailers.Set("X-Content-SHA256", fmt.Sprintf("%x", sha256.Sum256(fullResponseBody)))
-			// trailers.Set("X-Content-Length", fmt.Sprintf("%v", len(fullResponseBody)))
+		return
+	}
+
+	if req.RequestLine.RequestTarget == "/ws/echo" {
+		conn, err := upgrader.Upgrade(w, req)
 
-			w.WriteTrailers(trailers)
-			fmt.Println("finished writing trailers")
+		if err != nil {
+			fmt.Printf("websocket upgrade failed: %v\n", err)
 			return
 		}
 
-		if req.RequestLine.RequestTarget == "/video" {
-			data, err := os.ReadFile("assets/vim.mp4")
+		for {
+			messageType, message, err := conn.ReadMessage()
 
 			if err != nil {
-				fmt.Println(err)
-				writeInternalServerError(w)
 				return
 			}
 
-			w.WriteStatusLine(response.OKStatus)
-			h := response.GetDefaultHeaders(len(data))
-			h.Overwrite("content-type", "video/mp4")
-			w.WriteHeaders(h)
-			w.WriteBody(string(data))
+			if err := conn.WriteMessage(messageType, message); err != nil {
+				return
+			}
+		}
+	}
 
-			return
+	if req.RequestLine.RequestTarget == "/yourproblem" {
+		w.WriteStatusLine(response.BadRequestStatus)
+		body := generateHtml(response.BadRequestStatus, "Bad Request", "Bad Request", "Your request honestly kinda sucked.")
+		headers := response.GetDefaultHeaders(len(body))
+		headers.Overwrite("content-type", "text/html")
+		w.WriteHeaders(headers)
+		w.WriteBody(body)
+		return
+	}
+
+	if req.RequestLine.RequestTarget == "/myproblem" {
+		writeInternalServerError(w)
+		return
+	}
+
+	w.WriteStatusLine(response.OKStatus)
+	body := generateHtml(response.OKStatus, "OK", "Success!", "Your request was an absolute banger.")
+	headers := response.GetDefaultHeaders(len(body))
+	headers.Overwrite("content-type", "text/html")
+	w.WriteHeaders(headers)
+	w.WriteBody(body)
+}
+
+func startServer() (*server.Server, error) {
+	opts := []server.Option{
+		server.WithReadHeaderTimeout(5 * time.Second),
+		server.WithReadTimeout(30 * time.Second),
+		server.WithIdleTimeout(30 * time.Second),
+		server.WithHTTP2(),
+		server.WithStreamBody(func(req *request.Request) bool {
+			return strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/")
+		}),
+	}
+
+	if certFile, keyFile := os.Getenv("TLS_CERT_FILE"), os.Getenv("TLS_KEY_FILE"); certFile != "" && keyFile != "" {
+		certs, err := server.LoadCertificates(server.KeyPair{CertFile: certFile, KeyFile: keyFile})
+
+		if err != nil {
+			return nil, err
 		}
 
-		if req.RequestLine.RequestTarget == "/yourproblem" {
-			w.WriteStatusLine(response.BadRequestStatus)
-			body := generateHtml(response.BadRequestStatus, "Bad Request", "Bad Request", "Your request honestly kinda sucked.")
-			headers := response.GetDefaultHeaders(len(body))
-			headers.Overwrite("content-type", "text/html")
-			w.WriteHeaders(headers)
-			w.WriteBody(body)
-			return
+		opts = append(opts, server.WithTLSConfig(certs.TLSConfig()))
+
+		if caFile := os.Getenv("TLS_CLIENT_CA_FILE"); caFile != "" {
+			pool, err := server.LoadCertPool(caFile)
+
+			if err != nil {
+				return nil, err
+			}
+
+			opts = append(opts, server.WithClientCAs(pool, tls.RequireAndVerifyClientCert))
 		}
+	}
 
-		if req.RequestLine.RequestTarget == "/myproblem" {
-			writeInternalServerError(w)
+	httpbin, err := proxy.New(proxy.Config{
+		Target:      &url.URL{Scheme: "https", Host: "httpbin.org"},
+		StripPrefix: "/httpbin/",
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	root := server.Handler(func(w *response.Writer, req *request.Request) {
+		if strings.HasPrefix(req.RequestLine.RequestTarget, "/httpbin/") {
+			httpbin.Handler()(w, req)
 			return
 		}
 
-		w.WriteStatusLine(response.OKStatus)
-		body := generateHtml(response.OKStatus, "OK", "Success!", "Your request was an absolute banger.")
-		headers := response.GetDefaultHeaders(len(body))
-		headers.Overwrite("content-type", "text/html")
-		w.WriteHeaders(headers)
-		w.WriteBody(body)
+		handler(w, req)
 	})
+
+	if origins := os.Getenv("CORS_ALLOWED_ORIGINS"); origins != "" {
+		root = server.Chain(root, server.CORS(server.CORSConfig{
+			AllowedOrigins: strings.Split(origins, ","),
+			AllowedMethods: []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"},
+			AllowedHeaders: []string{"Content-Type", "Authorization"},
+			MaxAge:         10 * time.Minute,
+		}))
+	}
+
+	listeners, _, err := server.InheritedListeners()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(listeners) > 0 {
+		return server.ServeListener(listeners[0], root, opts...)
+	}
+
+	return server.Serve(port, root, opts...)
+}
+
+func main() {
+	server, err := startServer()
 	if err != nil {
 		log.Fatalf("Error starting server: %v", err)
 	}
-	defer server.Close()
-	log.Println("Server started on port", port)
+	log.Println("Server started on", server.Listener.Addr())
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 	<-sigChan
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+		server.Close()
+		return
+	}
 	log.Println("Server gracefully stopped")
 }
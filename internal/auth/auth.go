@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+)
+
+// Principal is the authenticated identity behind a request.
+type Principal struct {
+	Name string
+	// Scheme is the Authorization scheme the request used, such as "Basic".
+	Scheme string
+	// Claims carries whatever else the validator knows about the principal,
+	// such as token claims.
+	Claims map[string]any
+}
+
+type contextKey struct{}
+
+// FromRequest returns the principal the auth middleware attached, or nil
+// when the request was not authenticated.
+func FromRequest(req *request.Request) *Principal {
+	p, _ := req.Context().Value(contextKey{}).(*Principal)
+	return p
+}
+
+// WithPrincipal returns a copy of req carrying p.
+func WithPrincipal(req *request.Request, p *Principal) *request.Request {
+	return req.WithContext(context.WithValue(req.Context(), contextKey{}, p))
+}
+
+// credentials splits an Authorization header into its scheme and the rest.
+// The scheme is matched case-insensitively.
+func credentials(req *request.Request, scheme string) (string, bool) {
+	value, ok := req.Headers.Get("authorization")
+
+	if !ok {
+		return "", false
+	}
+
+	got, rest, found := strings.Cut(strings.TrimSpace(value), " ")
+
+	if !found || !strings.EqualFold(got, scheme) {
+		return "", false
+	}
+
+	return strings.TrimSpace(rest), true
+}
+
+// quote returns s as an HTTP quoted-string for a challenge parameter,
+// dropping control characters.
+func quote(s string) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f {
+			return -1
+		}
+		return r
+	}, s)
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func writeChallenge(w *response.Writer, statusCode response.StatusCode, challenge string, message string) {
+	h := response.GetDefaultHeaders(len(message))
+	h.Set("www-authenticate", challenge)
+	w.WriteStatusLine(statusCode)
+	w.WriteHeaders(h)
+	w.WriteBody(message)
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// call runs handler for a request with the given Authorization header and
+// returns the raw response.
+func call(t *testing.T, handler server.Handler, authorization string) string {
+	t.Helper()
+	raw := "GET / HTTP/1.1\r\nHost: localhost\r\n"
+	if authorization != "" {
+		raw += "Authorization: " + authorization + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	handler(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func whoami(w *response.Writer, req *request.Request) {
+	p := FromRequest(req)
+	w.WriteBody(fmt.Sprintf("%s via %s", p.Name, p.Scheme))
+}
+
+func basicHeader(username string, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+func TestBasic(t *testing.T) {
+	handler := server.Chain(whoami, Basic(`Admin "area"`, StaticUsers(map[string]string{"alice": "secret", "bob": "hunter2"})))
+
+	// Test: Valid credentials reach the handler with a principal
+	resp := call(t, handler, basicHeader("alice", "secret"))
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.True(t, strings.HasSuffix(resp, "alice via Basic"))
+
+	// Test: The scheme is case-insensitive
+	resp = call(t, handler, "basic "+base64.StdEncoding.EncodeToString([]byte("bob:hunter2")))
+	assert.True(t, strings.HasSuffix(resp, "bob via Basic"))
+
+	// Test: Missing, wrong and malformed credentials are challenged
+	for _, authorization := range []string{
+		"",
+		basicHeader("alice", "wrong"),
+		basicHeader("mallory", "secret"),
+		basicHeader("alice", "hunter2"),
+		"Basic not-base64!",
+		"Basic " + base64.StdEncoding.EncodeToString([]byte("no-colon")),
+		"Bearer token",
+	} {
+		resp = call(t, handler, authorization)
+		assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 401 Unauthorized\r\n"), authorization)
+		assert.Contains(t, resp, "www-authenticate: Basic realm=\"Admin \\\"area\\\"\", charset=\"UTF-8\"\r\n", authorization)
+	}
+}
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("correct horse"), bcrypt.MinCost)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), ".htpasswd")
+	require.NoError(t, os.WriteFile(path, []byte("# users\n\nalice:"+string(hash)+"\n"), 0o600))
+
+	// Test: bcrypt entries are checked
+	htpasswd, err := LoadHtpasswd(path)
+	require.NoError(t, err)
+	assert.True(t, htpasswd.Validate("alice", "correct horse"))
+	assert.False(t, htpasswd.Validate("alice", "wrong"))
+	assert.False(t, htpasswd.Validate("bob", "correct horse"))
+
+	handler := server.Chain(whoami, Basic("files", htpasswd.Validate))
+	assert.True(t, strings.HasSuffix(call(t, handler, basicHeader("alice", "correct horse")), "alice via Basic"))
+
+	// Test: Reload picks up new users
+	require.NoError(t, os.WriteFile(path, []byte("bob:"+string(hash)+"\n"), 0o600))
+	require.NoError(t, htpasswd.Reload())
+	assert.True(t, htpasswd.Validate("bob", "correct horse"))
+	assert.False(t, htpasswd.Validate("alice", "correct horse"))
+
+	// Test: Other hash formats and malformed lines are refused
+	require.NoError(t, os.WriteFile(path, []byte("carol:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=\n"), 0o600))
+	require.Error(t, htpasswd.Reload())
+	assert.True(t, htpasswd.Validate("bob", "correct horse"))
+	require.NoError(t, os.WriteFile(path, []byte("no separator\n"), 0o600))
+	_, err = LoadHtpasswd(path)
+	require.Error(t, err)
+}
+
+func TestBearer(t *testing.T) {
+	validator := TokenValidatorFunc(func(ctx context.Context, token string) (*Principal, error) {
+		switch token {
+		case "good":
+			return &Principal{Name: "service-a"}, nil
+		case "readonly":
+			return nil, fmt.Errorf("%w: write access required", ErrInsufficientScope)
+		default:
+			return nil, fmt.Errorf("%w: token expired\r\ninjected: header", ErrInvalidToken)
+		}
+	})
+	handler := server.Chain(whoami, Bearer("api", validator))
+
+	// Test: A valid token reaches the handler
+	resp := call(t, handler, "Bearer good")
+	assert.True(t, strings.HasSuffix(resp, "service-a via Bearer"))
+
+	// Test: No token gets a bare challenge
+	resp = call(t, handler, "")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.Contains(t, resp, "www-authenticate: Bearer realm=\"api\"\r\n")
+
+	// Test: Invalid tokens get a fixed description, not the validator's error
+	resp = call(t, handler, "Bearer expired")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 401 Unauthorized\r\n"))
+	assert.Contains(t, resp, "www-authenticate: Bearer realm=\"api\", error=\"invalid_token\", error_description=\"the token is expired, revoked or malformed\"\r\n")
+	assert.NotContains(t, resp, "injected")
+
+	// Test: Tokens without the needed scope get a 403
+	resp = call(t, handler, "Bearer readonly")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 403 Forbidden\r\n"))
+	assert.Contains(t, resp, `error="insufficient_scope", error_description="the token does not grant the scope this request needs"`)
+	assert.NotContains(t, resp, "write access required")
+}
@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// BasicValidator reports whether a username and password are valid.
+type BasicValidator func(username string, password string) bool
+
+// Basic requires HTTP Basic credentials accepted by validate. Other
+// requests get a 401 challenging the client for realm.
+func Basic(realm string, validate BasicValidator) server.Middleware {
+	challenge := "Basic realm=" + quote(realm) + `, charset="UTF-8"`
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			username, password, ok := BasicCredentials(req)
+
+			if !ok || !validate(username, password) {
+				writeChallenge(w, response.UnauthorizedStatus, challenge, "authentication required")
+				return
+			}
+
+			next(w, WithPrincipal(req, &Principal{Name: username, Scheme: "Basic"}))
+		}
+	}
+}
+
+// BasicCredentials returns the username and password from a Basic
+// Authorization header.
+func BasicCredentials(req *request.Request) (string, string, bool) {
+	encoded, ok := credentials(req, "Basic")
+
+	if !ok {
+		return "", "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+
+	if err != nil {
+		return "", "", false
+	}
+
+	username, password, found := strings.Cut(string(decoded), ":")
+
+	return username, password, found
+}
+
+// StaticUsers validates against a fixed map of usernames to passwords. The
+// comparison takes the same time whether the username or the password is
+// wrong, so timing does not reveal which users exist.
+func StaticUsers(users map[string]string) BasicValidator {
+	type entry struct{ username, password [sha256.Size]byte }
+
+	entries := make([]entry, 0, len(users))
+	for username, password := range users {
+		entries = append(entries, entry{sha256.Sum256([]byte(username)), sha256.Sum256([]byte(password))})
+	}
+
+	return func(username string, password string) bool {
+		gotUser := sha256.Sum256([]byte(username))
+		gotPass := sha256.Sum256([]byte(password))
+		match := 0
+
+		for _, e := range entries {
+			userOK := subtle.ConstantTimeCompare(gotUser[:], e.username[:])
+			passOK := subtle.ConstantTimeCompare(gotPass[:], e.password[:])
+			match |= userOK & passOK
+		}
+
+		return match == 1
+	}
+}
+
+// Htpasswd holds users from an htpasswd file with bcrypt hashes, as
+// written by "htpasswd -B".
+type Htpasswd struct {
+	mu    sync.RWMutex
+	path  string
+	users map[string][]byte
+}
+
+// dummyHash is compared against for unknown users, so they take as long to
+// reject as a wrong password.
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	h := &Htpasswd{path: path}
+
+	if err := h.Reload(); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Reload reads the file again, so users can be changed without a restart.
+// The current users are kept if the file cannot be read.
+func (h *Htpasswd) Reload() error {
+	file, err := os.Open(h.path)
+
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	users := map[string][]byte{}
+	scanner := bufio.NewScanner(file)
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		username, hash, found := strings.Cut(text, ":")
+
+		if !found || username == "" {
+			return fmt.Errorf("%s:%d: malformed entry", h.path, line)
+		}
+
+		if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+			return fmt.Errorf("%s:%d: only bcrypt hashes are supported", h.path, line)
+		}
+
+		users[username] = []byte(hash)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	h.users = users
+	h.mu.Unlock()
+
+	return nil
+}
+
+func (h *Htpasswd) Validate(username string, password string) bool {
+	h.mu.RLock()
+	hash, ok := h.users[username]
+	h.mu.RUnlock()
+
+	if !ok {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return false
+	}
+
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil
+}
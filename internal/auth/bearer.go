@@ -0,0 +1,75 @@
+package auth
+
+import (
+	"context"
+	"errors"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+)
+
+// The error_description sent for each error class. Validator errors are
+// not passed on since they may say more about the token than a client
+// should learn.
+const (
+	invalidTokenDescription      = "the token is expired, revoked or malformed"
+	insufficientScopeDescription = "the token does not grant the scope this request needs"
+)
+
+var (
+	// ErrInvalidToken is for tokens that are expired, revoked or malformed.
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrInsufficientScope is for valid tokens that may not make the request.
+	ErrInsufficientScope = errors.New("insufficient scope")
+)
+
+// TokenValidator checks a bearer token and returns who it belongs to.
+// Errors that wrap ErrInsufficientScope produce a 403; any other error is
+// treated as an invalid token.
+type TokenValidator interface {
+	ValidateToken(ctx context.Context, token string) (*Principal, error)
+}
+
+type TokenValidatorFunc func(ctx context.Context, token string) (*Principal, error)
+
+func (f TokenValidatorFunc) ValidateToken(ctx context.Context, token string) (*Principal, error) {
+	return f(ctx, token)
+}
+
+// Bearer requires a bearer token accepted by validator, answering other
+// requests with the challenges RFC 6750 describes.
+func Bearer(realm string, validator TokenValidator) server.Middleware {
+	challenge := "Bearer realm=" + quote(realm)
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			token, ok := credentials(req, "Bearer")
+
+			if !ok || token == "" {
+				writeChallenge(w, response.UnauthorizedStatus, challenge, "authentication required")
+				return
+			}
+
+			principal, err := validator.ValidateToken(req.Context(), token)
+
+			if errors.Is(err, ErrInsufficientScope) {
+				writeChallenge(w, response.ForbiddenStatus,
+					challenge+`, error="insufficient_scope", error_description=`+quote(insufficientScopeDescription), "insufficient scope")
+				return
+			}
+
+			if err != nil || principal == nil {
+				writeChallenge(w, response.UnauthorizedStatus,
+					challenge+`, error="invalid_token", error_description=`+quote(invalidTokenDescription), "invalid token")
+				return
+			}
+
+			if principal.Scheme == "" {
+				principal.Scheme = "Bearer"
+			}
+
+			next(w, WithPrincipal(req, principal))
+		}
+	}
+}
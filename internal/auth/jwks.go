@@ -0,0 +1,217 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"time"
+)
+
+// unknownKidReloadInterval limits how often tokens with unknown key IDs
+// make JWKSFile read its file again.
+const unknownKidReloadInterval = time.Minute
+
+// JWKSFile is a KeySet read from a local JSON Web Key Set file. Call Reload
+// after the file changes; a token signed with a key ID the set does not
+// know also triggers a reload, at most once a minute, so rotated keys are
+// picked up without a restart.
+type JWKSFile struct {
+	path string
+
+	mu   sync.RWMutex
+	keys []Key
+	// lastReload is when the file was last read, or when an unknown key ID
+	// last tried to read it, whether or not that worked.
+	lastReload time.Time
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func LoadJWKS(path string) (*JWKSFile, error) {
+	j := &JWKSFile{path: path}
+
+	if err := j.Reload(); err != nil {
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Reload reads the file again. The current keys are kept if it fails.
+func (j *JWKSFile) Reload() error {
+	data, err := os.ReadFile(j.path)
+
+	if err != nil {
+		return err
+	}
+
+	keys, err := ParseJWKS(data)
+
+	if err != nil {
+		return fmt.Errorf("%s: %w", j.path, err)
+	}
+
+	j.mu.Lock()
+	j.keys = keys
+	j.lastReload = time.Now()
+	j.mu.Unlock()
+
+	return nil
+}
+
+func (j *JWKSFile) Keys(kid string) []Key {
+	j.mu.RLock()
+	keys := filterKeys(j.keys, kid)
+	j.mu.RUnlock()
+
+	if len(keys) > 0 || kid == "" || !j.claimReload() {
+		return keys
+	}
+
+	if j.Reload() != nil {
+		return nil
+	}
+
+	j.mu.RLock()
+	defer j.mu.RUnlock()
+
+	return filterKeys(j.keys, kid)
+}
+
+// claimReload reports whether an unknown key ID may reload the file now.
+// The attempt is recorded before reading, so concurrent requests and
+// failing reloads still read the file at most once per interval.
+func (j *JWKSFile) claimReload() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if time.Since(j.lastReload) < unknownKidReloadInterval {
+		return false
+	}
+
+	j.lastReload = time.Now()
+
+	return true
+}
+
+// ParseJWKS reads the signing keys in a JSON Web Key Set. Keys meant for
+// encryption are skipped, as are key types this package cannot verify.
+func ParseJWKS(data []byte) ([]Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+
+	var keys []Key
+
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.publicKey()
+
+		if errors.Is(err, errUnsupportedKey) {
+			continue
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("key %d: %w", i, err)
+		}
+
+		keys = append(keys, Key{ID: k.Kid, Algorithm: k.Alg, Key: key})
+	}
+
+	return keys, nil
+}
+
+var errUnsupportedKey = errors.New("unsupported key type")
+
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "oct":
+		return decodeField("k", k.K)
+	case "RSA":
+		n, err := decodeField("n", k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeField("e", k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errUnsupportedKey
+		}
+		x, err := decodeField("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeField("y", k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid P-256 point")
+		}
+		// Parsing the uncompressed point checks that it is on the curve.
+		point := append([]byte{4}, append(x, y...)...)
+		return ecdsa.ParseUncompressedPublicKey(elliptic.P256(), point)
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, errUnsupportedKey
+		}
+		x, err := decodeField("x", k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, errUnsupportedKey
+	}
+}
+
+func decodeField(name string, value string) ([]byte, error) {
+	if value == "" {
+		return nil, fmt.Errorf("missing %q", name)
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(value)
+
+	if err != nil {
+		return nil, fmt.Errorf("invalid %q: %w", name, err)
+	}
+
+	return decoded, nil
+}
@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Key is a verification key for JWTs. Key holds a []byte secret for HS256,
+// an *rsa.PublicKey for RS256, an *ecdsa.PublicKey on P-256 for ES256 or an
+// ed25519.PublicKey for EdDSA.
+type Key struct {
+	ID string
+	// Algorithm restricts the key to one algorithm. Empty allows any the
+	// key type supports.
+	Algorithm string
+	Key       any
+}
+
+// KeySet looks up the keys that may have signed a token. An empty kid
+// asks for every key.
+type KeySet interface {
+	Keys(kid string) []Key
+}
+
+// StaticKeys is a KeySet of keys fixed at startup.
+type StaticKeys []Key
+
+func (s StaticKeys) Keys(kid string) []Key {
+	return filterKeys(s, kid)
+}
+
+func filterKeys(keys []Key, kid string) []Key {
+	if kid == "" {
+		return keys
+	}
+
+	var matched []Key
+	for _, key := range keys {
+		if key.ID == kid {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
+
+var supportedAlgorithms = []string{"HS256", "RS256", "ES256", "EdDSA"}
+
+// JWTValidator verifies compact JWS tokens and their registered claims. It
+// is a TokenValidator, so it plugs into Bearer.
+type JWTValidator struct {
+	Keys KeySet
+	// Algorithms lists the algorithms accepted. Defaults to HS256, RS256,
+	// ES256 and EdDSA; "none" is never accepted.
+	Algorithms []string
+	// Issuer and Audience are required to match when set.
+	Issuer   string
+	Audience string
+	// RequiredScopes must all appear in the space separated scope claim.
+	RequiredScopes []string
+	// ClockSkew is the leeway allowed when checking exp, nbf and iat.
+	ClockSkew time.Duration
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+	Typ string `json:"typ"`
+	// Crit lists extensions the token requires to be understood. None are
+	// supported, so any value rejects the token (RFC 7515 section 4.1.11).
+	Crit json.RawMessage `json:"crit"`
+}
+
+func (v *JWTValidator) ValidateToken(ctx context.Context, token string) (*Principal, error) {
+	claims, err := v.Verify(token)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(v.RequiredScopes) > 0 {
+		scope, _ := claims["scope"].(string)
+		granted := strings.Fields(scope)
+		for _, required := range v.RequiredScopes {
+			if !slices.Contains(granted, required) {
+				return nil, fmt.Errorf("%w: %s scope required", ErrInsufficientScope, required)
+			}
+		}
+	}
+
+	subject, _ := claims["sub"].(string)
+
+	return &Principal{Name: subject, Scheme: "Bearer", Claims: claims}, nil
+}
+
+// Verify checks the token's signature and claims and returns the claims.
+// Errors wrap ErrInvalidToken.
+func (v *JWTValidator) Verify(token string) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a compact JWS", ErrInvalidToken)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: malformed header", ErrInvalidToken)
+	}
+
+	if header.Crit != nil {
+		return nil, fmt.Errorf("%w: unsupported critical header extensions", ErrInvalidToken)
+	}
+
+	algorithms := v.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = supportedAlgorithms
+	}
+
+	if !slices.Contains(algorithms, header.Alg) || !slices.Contains(supportedAlgorithms, header.Alg) {
+		return nil, fmt.Errorf("%w: algorithm %q not allowed", ErrInvalidToken, header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed signature", ErrInvalidToken)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	verified := false
+
+	for _, key := range v.Keys.Keys(header.Kid) {
+		if key.Algorithm != "" && key.Algorithm != header.Alg {
+			continue
+		}
+		if verifySignature(header.Alg, key.Key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+
+	if !verified {
+		return nil, fmt.Errorf("%w: signature not valid", ErrInvalidToken)
+	}
+
+	var claims map[string]any
+	if err := decodeSegment(parts[1], &claims); err != nil || claims == nil {
+		return nil, fmt.Errorf("%w: malformed claims", ErrInvalidToken)
+	}
+
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (v *JWTValidator) checkClaims(claims map[string]any) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+
+	exp, ok, err := timeClaim(claims, "exp")
+	switch {
+	case err != nil:
+		return err
+	case !ok:
+		return fmt.Errorf("%w: missing exp", ErrInvalidToken)
+	case !now.Before(exp.Add(v.ClockSkew)):
+		return fmt.Errorf("%w: token expired", ErrInvalidToken)
+	}
+
+	nbf, ok, err := timeClaim(claims, "nbf")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.ClockSkew).Before(nbf) {
+		return fmt.Errorf("%w: token not valid yet", ErrInvalidToken)
+	}
+
+	iat, ok, err := timeClaim(claims, "iat")
+	if err != nil {
+		return err
+	}
+	if ok && now.Add(v.ClockSkew).Before(iat) {
+		return fmt.Errorf("%w: token issued in the future", ErrInvalidToken)
+	}
+
+	if v.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != v.Issuer {
+			return fmt.Errorf("%w: unexpected issuer", ErrInvalidToken)
+		}
+	}
+
+	if v.Audience != "" && !hasAudience(claims["aud"], v.Audience) {
+		return fmt.Errorf("%w: unexpected audience", ErrInvalidToken)
+	}
+
+	return nil
+}
+
+// timeClaim reads a NumericDate claim.
+func timeClaim(claims map[string]any, name string) (time.Time, bool, error) {
+	value, ok := claims[name]
+
+	if !ok {
+		return time.Time{}, false, nil
+	}
+
+	number, ok := value.(json.Number)
+
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a number", ErrInvalidToken, name)
+	}
+
+	seconds, err := number.Float64()
+
+	if err != nil || math.Abs(seconds) > 1<<53 {
+		return time.Time{}, false, fmt.Errorf("%w: %s is not a valid date", ErrInvalidToken, name)
+	}
+
+	whole, fraction := math.Modf(seconds)
+
+	return time.Unix(int64(whole), int64(fraction*1e9)), true, nil
+}
+
+func hasAudience(aud any, want string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == want
+	case []any:
+		for _, item := range aud {
+			if s, ok := item.(string); ok && s == want {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	return decoder.Decode(v)
+}
+
+func verifySignature(alg string, key any, signed []byte, signature []byte) bool {
+	digest := sha256.Sum256(signed)
+
+	switch alg {
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok || len(secret) == 0 {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(signature, mac.Sum(nil))
+	case "RS256":
+		publicKey, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, digest[:], signature) == nil
+	case "ES256":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || publicKey.Curve.Params().Name != "P-256" || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(publicKey, digest[:], r, s)
+	case "EdDSA":
+		publicKey, ok := key.(ed25519.PublicKey)
+		return ok && len(publicKey) == ed25519.PublicKeySize && ed25519.Verify(publicKey, signed, signature)
+	default:
+		return false
+	}
+}
@@ -0,0 +1,273 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var b64 = base64.RawURLEncoding
+
+// signJWT builds a compact JWS with the given header fields and claims.
+func signJWT(t *testing.T, alg string, kid string, key any, claims map[string]any) string {
+	t.Helper()
+	return signJWTHeader(t, map[string]any{"alg": alg, "kid": kid, "typ": "JWT"}, key, claims)
+}
+
+// signJWTHeader is signJWT with the whole header given, signing with its alg.
+func signJWTHeader(t *testing.T, fields map[string]any, key any, claims map[string]any) string {
+	t.Helper()
+	alg := fields["alg"].(string)
+	header, err := json.Marshal(fields)
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+	signed := b64.EncodeToString(header) + "." + b64.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, key.([]byte))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key.(*rsa.PrivateKey), crypto.SHA256, digest[:])
+		require.NoError(t, err)
+	case "ES256":
+		r, s, err := ecdsa.Sign(rand.Reader, key.(*ecdsa.PrivateKey), digest[:])
+		require.NoError(t, err)
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case "EdDSA":
+		signature = ed25519.Sign(key.(ed25519.PrivateKey), []byte(signed))
+	}
+	return signed + "." + b64.EncodeToString(signature)
+}
+
+type testKeys struct {
+	secret []byte
+	rsa    *rsa.PrivateKey
+	ec     *ecdsa.PrivateKey
+	ed     ed25519.PrivateKey
+}
+
+func newTestKeys(t *testing.T) testKeys {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	return testKeys{secret: []byte("0123456789abcdef0123456789abcdef"), rsa: rsaKey, ec: ecKey, ed: edKey}
+}
+
+// jwks renders the public halves of keys as a JSON Web Key Set.
+func (k testKeys) jwks(t *testing.T) []byte {
+	ecPoint, err := k.ec.PublicKey.Bytes()
+	require.NoError(t, err)
+	set := map[string]any{"keys": []map[string]string{
+		{"kty": "oct", "kid": "hs", "alg": "HS256", "k": b64.EncodeToString(k.secret)},
+		{"kty": "RSA", "kid": "rs", "use": "sig", "n": b64.EncodeToString(k.rsa.N.Bytes()), "e": b64.EncodeToString(big.NewInt(int64(k.rsa.E)).Bytes())},
+		{"kty": "EC", "kid": "es", "crv": "P-256", "x": b64.EncodeToString(ecPoint[1:33]), "y": b64.EncodeToString(ecPoint[33:])},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64.EncodeToString(k.ed.Public().(ed25519.PublicKey))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": "AQAB", "e": "AQAB"},
+		{"kty": "EC", "kid": "p384", "crv": "P-384", "x": "AA", "y": "AA"},
+	}}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	return data
+}
+
+func TestJWT(t *testing.T) {
+	keys := newTestKeys(t)
+	parsed, err := ParseJWKS(keys.jwks(t))
+	require.NoError(t, err)
+	require.Len(t, parsed, 4)
+
+	now := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	validator := &JWTValidator{
+		Keys:      StaticKeys(parsed),
+		Issuer:    "https://gateway.example.com",
+		Audience:  "orders-api",
+		ClockSkew: 30 * time.Second,
+		Now:       func() time.Time { return now },
+	}
+	claims := func(extra map[string]any) map[string]any {
+		c := map[string]any{
+			"sub": "user-42",
+			"iss": "https://gateway.example.com",
+			"aud": []string{"billing-api", "orders-api"},
+			"exp": now.Add(time.Minute).Unix(),
+			"iat": now.Unix(),
+		}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	// Test: Every supported algorithm verifies
+	for alg, signer := range map[string]struct {
+		kid string
+		key any
+	}{
+		"HS256": {"hs", keys.secret},
+		"RS256": {"rs", keys.rsa},
+		"ES256": {"es", keys.ec},
+		"EdDSA": {"ed", keys.ed},
+	} {
+		principal, err := validator.ValidateToken(context.Background(), signJWT(t, alg, signer.kid, signer.key, claims(nil)))
+		require.NoError(t, err, alg)
+		assert.Equal(t, "user-42", principal.Name)
+		assert.Equal(t, "Bearer", principal.Scheme)
+		assert.Equal(t, "https://gateway.example.com", principal.Claims["iss"])
+
+		// Test: Tokens without a kid are checked against every key
+		_, err = validator.Verify(signJWT(t, alg, "", signer.key, claims(nil)))
+		require.NoError(t, err, alg)
+	}
+
+	// Test: Claims outside their window, within and beyond the clock skew
+	invalid := map[string]map[string]any{
+		"expired":        {"exp": now.Add(-time.Minute).Unix()},
+		"missing exp":    {"exp": nil},
+		"not yet valid":  {"nbf": now.Add(time.Minute).Unix()},
+		"future iat":     {"iat": now.Add(time.Minute).Unix()},
+		"wrong issuer":   {"iss": "https://evil.test"},
+		"wrong audience": {"aud": "other-api"},
+		"no audience":    {"aud": nil},
+		"string exp":     {"exp": "tomorrow"},
+	}
+	for name, extra := range invalid {
+		_, err := validator.Verify(signJWT(t, "ES256", "es", keys.ec, claims(extra)))
+		require.ErrorIs(t, err, ErrInvalidToken, name)
+	}
+	_, err = validator.Verify(signJWT(t, "ES256", "es", keys.ec, claims(map[string]any{
+		"exp": now.Add(-10 * time.Second).Unix(),
+		"nbf": now.Add(10 * time.Second).Unix(),
+		"aud": "orders-api",
+	})))
+	require.NoError(t, err)
+
+	// Test: Tampered payloads, wrong keys and algorithm tricks are rejected
+	token := signJWT(t, "RS256", "rs", keys.rsa, claims(nil))
+	parts := strings.Split(token, ".")
+	forged, _ := json.Marshal(claims(map[string]any{"sub": "admin"}))
+	_, err = validator.Verify(parts[0] + "." + b64.EncodeToString(forged) + "." + parts[2])
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	_, err = validator.Verify(signJWT(t, "RS256", "rs", otherKey, claims(nil)))
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = validator.Verify(signJWT(t, "HS256", "es", keys.secret, claims(nil)))
+	require.ErrorIs(t, err, ErrInvalidToken, "key pinned to another algorithm")
+
+	unsigned := b64.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+	_, err = validator.Verify(unsigned)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	restricted := *validator
+	restricted.Algorithms = []string{"EdDSA"}
+	_, err = restricted.Verify(token)
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	_, err = validator.Verify(signJWTHeader(t, map[string]any{"alg": "HS256", "kid": "hs", "crit": []string{"exp"}, "exp": 0}, keys.secret, claims(nil)))
+	require.ErrorIs(t, err, ErrInvalidToken, "unknown critical extension")
+
+	for _, malformed := range []string{"", "a.b", "a.b.c.d", "!!.!!.!!"} {
+		_, err = validator.Verify(malformed)
+		require.ErrorIs(t, err, ErrInvalidToken, malformed)
+	}
+
+	// Test: Missing scopes are reported as insufficient scope
+	scoped := *validator
+	scoped.RequiredScopes = []string{"orders:write"}
+	_, err = scoped.ValidateToken(context.Background(), signJWT(t, "EdDSA", "ed", keys.ed, claims(map[string]any{"scope": "orders:read"})))
+	require.ErrorIs(t, err, ErrInsufficientScope)
+	_, err = scoped.ValidateToken(context.Background(), signJWT(t, "EdDSA", "ed", keys.ed, claims(map[string]any{"scope": "orders:read orders:write"})))
+	require.NoError(t, err)
+
+	// Test: Plugs into the Bearer middleware
+	handler := server.Chain(whoami, Bearer("api", validator))
+	resp := call(t, handler, "Bearer "+signJWT(t, "HS256", "hs", keys.secret, claims(nil)))
+	assert.True(t, strings.HasSuffix(resp, "user-42 via Bearer"))
+	resp = call(t, handler, "Bearer "+signJWT(t, "HS256", "hs", keys.secret, claims(map[string]any{"exp": now.Add(-time.Hour).Unix()})))
+	assert.Contains(t, resp, `error="invalid_token"`)
+	assert.NotContains(t, resp, "token expired")
+}
+
+func TestJWKSFile(t *testing.T) {
+	keys := newTestKeys(t)
+	rotated := newTestKeys(t)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, keys.jwks(t), 0o600))
+
+	jwks, err := LoadJWKS(path)
+	require.NoError(t, err)
+	validator := &JWTValidator{Keys: jwks}
+	claims := map[string]any{"sub": "svc", "exp": time.Now().Add(time.Minute).Unix()}
+
+	// Test: Keys from the file verify tokens
+	_, err = validator.Verify(signJWT(t, "EdDSA", "ed", keys.ed, claims))
+	require.NoError(t, err)
+
+	// Test: Reload swaps in a rotated key set
+	require.NoError(t, os.WriteFile(path, []byte(strings.ReplaceAll(string(rotated.jwks(t)), `"ed"`, `"ed-2"`)), 0o600))
+	require.NoError(t, jwks.Reload())
+	_, err = validator.Verify(signJWT(t, "EdDSA", "ed-2", rotated.ed, claims))
+	require.NoError(t, err)
+	_, err = validator.Verify(signJWT(t, "EdDSA", "ed", keys.ed, claims))
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// Test: An unknown kid reloads the file once the reload interval passed
+	require.NoError(t, os.WriteFile(path, []byte(strings.ReplaceAll(string(keys.jwks(t)), `"ed"`, `"ed-3"`)), 0o600))
+	_, err = validator.Verify(signJWT(t, "EdDSA", "ed-3", keys.ed, claims))
+	require.ErrorIs(t, err, ErrInvalidToken)
+	jwks.lastReload = time.Now().Add(-2 * unknownKidReloadInterval)
+	_, err = validator.Verify(signJWT(t, "EdDSA", "ed-3", keys.ed, claims))
+	require.NoError(t, err)
+
+	// Test: A broken file keeps the current keys
+	require.NoError(t, os.WriteFile(path, []byte("{not json"), 0o600))
+	require.Error(t, jwks.Reload())
+	_, err = validator.Verify(signJWT(t, "EdDSA", "ed-3", keys.ed, claims))
+	require.NoError(t, err)
+
+	// Test: Failed reloads for unknown kids count against the interval too
+	jwks.lastReload = time.Now().Add(-2 * unknownKidReloadInterval)
+	_, err = validator.Verify(signJWT(t, "EdDSA", "ed-4", keys.ed, claims))
+	require.ErrorIs(t, err, ErrInvalidToken)
+	require.NoError(t, os.WriteFile(path, []byte(strings.ReplaceAll(string(keys.jwks(t)), `"ed"`, `"ed-4"`)), 0o600))
+	_, err = validator.Verify(signJWT(t, "EdDSA", "ed-4", keys.ed, claims))
+	require.ErrorIs(t, err, ErrInvalidToken)
+
+	// Test: Invalid keys are reported
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"EC","crv":"P-256","x":"` + b64.EncodeToString(make([]byte, 32)) + `","y":"` + b64.EncodeToString(make([]byte, 32)) + `"}]}`))
+	require.Error(t, err, "point not on the curve")
+	_, err = ParseJWKS([]byte(`{"keys":[{"kty":"RSA","n":"AQAB"}]}`))
+	require.Error(t, err)
+}
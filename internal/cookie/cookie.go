@@ -0,0 +1,252 @@
+package cookie
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+)
+
+var ErrNoCookie = errors.New("cookie not present")
+
+// TimeFormat is the IMF-fixdate format Expires is written in.
+const TimeFormat = "Mon, 02 Jan 2006 15:04:05 GMT"
+
+type SameSite int
+
+const (
+	// SameSiteDefault leaves the attribute out so the browser default applies.
+	SameSiteDefault SameSite = iota
+	SameSiteLax
+	SameSiteStrict
+	SameSiteNone
+)
+
+func (s SameSite) String() string {
+	switch s {
+	case SameSiteLax:
+		return "Lax"
+	case SameSiteStrict:
+		return "Strict"
+	case SameSiteNone:
+		return "None"
+	default:
+		return ""
+	}
+}
+
+// Cookie is a cookie read from a request or sent with Set-Cookie. Requests
+// only carry Name and Value.
+type Cookie struct {
+	Name  string
+	Value string
+
+	Path    string
+	Domain  string
+	Expires time.Time
+	// MaxAge is in seconds. Zero leaves it out and a negative value deletes
+	// the cookie by sending Max-Age=0.
+	MaxAge      int
+	Secure      bool
+	HttpOnly    bool
+	SameSite    SameSite
+	Partitioned bool
+}
+
+// Parse reads the cookies in a Cookie header value. Pairs that do not
+// follow RFC 6265 are skipped rather than failing the whole header.
+func Parse(value string) []*Cookie {
+	var cookies []*Cookie
+
+	for _, pair := range strings.Split(value, ";") {
+		pair = strings.TrimSpace(pair)
+		name, val, found := strings.Cut(pair, "=")
+
+		if !found || !isToken(name) {
+			continue
+		}
+
+		val, ok := parseValue(val)
+		if !ok {
+			continue
+		}
+
+		cookies = append(cookies, &Cookie{Name: name, Value: val})
+	}
+
+	return cookies
+}
+
+// FromRequest returns every cookie the request carries.
+func FromRequest(req *request.Request) []*Cookie {
+	value, ok := req.Headers.Get("cookie")
+
+	if !ok {
+		return nil
+	}
+
+	return Parse(value)
+}
+
+// Get returns the first cookie called name, or ErrNoCookie.
+func Get(req *request.Request, name string) (*Cookie, error) {
+	for _, c := range FromRequest(req) {
+		if c.Name == name {
+			return c, nil
+		}
+	}
+
+	return nil, ErrNoCookie
+}
+
+// Set validates c and adds it to h as its own Set-Cookie line. Pass
+// Writer.Header() to add it to whatever headers the handler writes.
+func Set(h headers.Headers, c *Cookie) error {
+	value, err := c.Format()
+
+	if err != nil {
+		return err
+	}
+
+	h.Set("set-cookie", value)
+
+	return nil
+}
+
+// Format returns c as a Set-Cookie value, or an error if it would not be a
+// valid RFC 6265 cookie or one browsers would refuse.
+func (c *Cookie) Format() (string, error) {
+	if err := c.Valid(); err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString(c.Name + "=" + c.Value)
+
+	if c.Path != "" {
+		b.WriteString("; Path=" + c.Path)
+	}
+	if c.Domain != "" {
+		b.WriteString("; Domain=" + strings.TrimPrefix(c.Domain, "."))
+	}
+	if !c.Expires.IsZero() {
+		b.WriteString("; Expires=" + c.Expires.UTC().Format(TimeFormat))
+	}
+	if c.MaxAge > 0 {
+		b.WriteString("; Max-Age=" + strconv.Itoa(c.MaxAge))
+	} else if c.MaxAge < 0 {
+		b.WriteString("; Max-Age=0")
+	}
+	if c.Secure {
+		b.WriteString("; Secure")
+	}
+	if c.HttpOnly {
+		b.WriteString("; HttpOnly")
+	}
+	if c.SameSite != SameSiteDefault {
+		b.WriteString("; SameSite=" + c.SameSite.String())
+	}
+	if c.Partitioned {
+		b.WriteString("; Partitioned")
+	}
+
+	return b.String(), nil
+}
+
+func (c *Cookie) Valid() error {
+	if !isToken(c.Name) {
+		return fmt.Errorf("invalid cookie name %q", c.Name)
+	}
+
+	if _, ok := parseValue(c.Value); !ok {
+		return fmt.Errorf("invalid value for cookie %s", c.Name)
+	}
+
+	if strings.ContainsFunc(c.Path, func(r rune) bool { return r < 0x20 || r == 0x7f || r == ';' }) {
+		return fmt.Errorf("invalid path for cookie %s", c.Name)
+	}
+
+	if c.Domain != "" && !isDomain(strings.TrimPrefix(c.Domain, ".")) {
+		return fmt.Errorf("invalid domain for cookie %s", c.Name)
+	}
+
+	if !c.Expires.IsZero() && c.Expires.UTC().Year() < 1601 {
+		return fmt.Errorf("expires before 1601 for cookie %s", c.Name)
+	}
+
+	if c.SameSite < SameSiteDefault || c.SameSite > SameSiteNone {
+		return fmt.Errorf("invalid SameSite for cookie %s", c.Name)
+	}
+
+	if (c.SameSite == SameSiteNone || c.Partitioned) && !c.Secure {
+		return fmt.Errorf("cookie %s must be Secure to use SameSite=None or Partitioned", c.Name)
+	}
+
+	if strings.HasPrefix(c.Name, "__Secure-") && !c.Secure {
+		return fmt.Errorf("cookie %s must be Secure", c.Name)
+	}
+
+	if strings.HasPrefix(c.Name, "__Host-") && (!c.Secure || c.Domain != "" || c.Path != "/") {
+		return fmt.Errorf("cookie %s must be Secure with Path=/ and no Domain", c.Name)
+	}
+
+	return nil
+}
+
+// parseValue checks a cookie-value, which may be wrapped in double quotes,
+// and returns it without the quotes.
+func parseValue(value string) (string, bool) {
+	if len(value) > 1 && value[0] == '"' && value[len(value)-1] == '"' {
+		value = value[1 : len(value)-1]
+	}
+
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c < 0x21 || c > 0x7e || c == '"' || c == ',' || c == ';' || c == '\\' {
+			return "", false
+		}
+	}
+
+	return value, true
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, r := range s {
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			continue
+		}
+		if strings.ContainsRune("!#$%&'*+-.^_`|~", r) {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
+
+func isDomain(s string) bool {
+	if s == "" || len(s) > 253 {
+		return false
+	}
+
+	for _, label := range strings.Split(s, ".") {
+		if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, r := range label {
+			if !((r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '-') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
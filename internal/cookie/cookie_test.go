@@ -0,0 +1,96 @@
+package cookie
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	// Test: Several pairs, quoted values and stray whitespace
+	cookies := Parse(`session=abc123;  theme="dark" ; empty=`)
+	require.Len(t, cookies, 3)
+	assert.Equal(t, &Cookie{Name: "session", Value: "abc123"}, cookies[0])
+	assert.Equal(t, &Cookie{Name: "theme", Value: "dark"}, cookies[1])
+	assert.Equal(t, &Cookie{Name: "empty", Value: ""}, cookies[2])
+
+	// Test: Invalid pairs are skipped
+	cookies = Parse(`bad name=1; novalue; ok=2; comma=a,b; "quoted"=3`)
+	require.Len(t, cookies, 1)
+	assert.Equal(t, "ok", cookies[0].Name)
+
+	// Test: Repeated Cookie headers are joined so every pair is read
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nCookie: a=1\r\nCookie: b=2\r\n\r\n"))
+	require.NoError(t, err)
+	assert.Len(t, FromRequest(req), 2)
+	c, err := Get(req, "b")
+	require.NoError(t, err)
+	assert.Equal(t, "2", c.Value)
+	_, err = Get(req, "missing")
+	require.ErrorIs(t, err, ErrNoCookie)
+}
+
+func TestFormat(t *testing.T) {
+	// Test: Every attribute
+	c := &Cookie{
+		Name:        "__Host-session",
+		Value:       "abc123",
+		Path:        "/",
+		Expires:     time.Date(2030, 1, 2, 3, 4, 5, 0, time.FixedZone("X", 3600)),
+		MaxAge:      3600,
+		Secure:      true,
+		HttpOnly:    true,
+		SameSite:    SameSiteNone,
+		Partitioned: true,
+	}
+	value, err := c.Format()
+	require.NoError(t, err)
+	assert.Equal(t, "__Host-session=abc123; Path=/; Expires=Wed, 02 Jan 2030 02:04:05 GMT; Max-Age=3600; Secure; HttpOnly; SameSite=None; Partitioned", value)
+
+	// Test: Domain drops a leading dot and negative MaxAge deletes
+	value, err = (&Cookie{Name: "id", Value: "1", Domain: ".example.com", MaxAge: -1, SameSite: SameSiteLax}).Format()
+	require.NoError(t, err)
+	assert.Equal(t, "id=1; Domain=example.com; Max-Age=0; SameSite=Lax", value)
+
+	// Test: Invalid cookies are refused
+	invalid := []*Cookie{
+		{Name: "", Value: "x"},
+		{Name: "a b", Value: "x"},
+		{Name: "a", Value: "has space"},
+		{Name: "a", Value: "semi;colon"},
+		{Name: "a", Value: "x", Path: "/;evil"},
+		{Name: "a", Value: "x", Domain: "bad_domain.com"},
+		{Name: "a", Value: "x", Expires: time.Date(1500, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{Name: "a", Value: "x", SameSite: SameSiteNone},
+		{Name: "a", Value: "x", Partitioned: true},
+		{Name: "__Secure-a", Value: "x"},
+		{Name: "__Host-a", Value: "x", Secure: true, Path: "/", Domain: "example.com"},
+	}
+	for _, c := range invalid {
+		_, err := c.Format()
+		assert.Error(t, err, c.Name)
+	}
+}
+
+func TestSet(t *testing.T) {
+	// Test: Each cookie is written as its own Set-Cookie line
+	h := headers.NewHeaders()
+	require.NoError(t, Set(h, &Cookie{Name: "a", Value: "1", Expires: time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC)}))
+	require.NoError(t, Set(h, &Cookie{Name: "b", Value: "2"}))
+	require.Error(t, Set(h, &Cookie{Name: "c", Value: "bad value"}))
+	assert.Equal(t, []string{"a=1; Expires=Tue, 01 Jan 2030 00:00:00 GMT", "b=2"}, h.Values("set-cookie"))
+
+	var buf strings.Builder
+	w := response.NewWriter(&buf)
+	require.NoError(t, Set(w.Header(), &Cookie{Name: "a", Value: "1"}))
+	require.NoError(t, Set(w.Header(), &Cookie{Name: "b", Value: "2"}))
+	w.WriteBody("ok")
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "\r\nset-cookie: a=1\r\nset-cookie: b=2\r\n")
+}
@@ -18,7 +18,7 @@ func (h Headers) Set(key string, value string) {
 	currentVal, exists := h.Get(parsedKey)
 
 	if exists {
-		currentVal = fmt.Sprintf("%v, %v", currentVal, value)
+		currentVal = fmt.Sprintf("%v%v%v", currentVal, separator(parsedKey), value)
 	} else {
 		currentVal = value
 	}
@@ -26,6 +26,36 @@ func (h Headers) Set(key string, value string) {
 	h[parsedKey] = currentVal
 }
 
+// Values returns each value set for key. Only set-cookie keeps more than
+// one, since its values cannot be combined into a single line.
+func (h Headers) Values(key string) []string {
+	value, ok := h.Get(key)
+
+	if !ok {
+		return nil
+	}
+
+	if strings.ToLower(key) == "set-cookie" {
+		return strings.Split(value, "\n")
+	}
+
+	return []string{value}
+}
+
+// separator is how repeated values of key are combined. Cookie pairs are
+// joined with semicolons, and Set-Cookie values are kept on separate lines
+// because their Expires dates contain commas.
+func separator(key string) string {
+	switch key {
+	case "cookie":
+		return "; "
+	case "set-cookie":
+		return "\n"
+	default:
+		return ", "
+	}
+}
+
 func (h Headers) Overwrite(key string, value string) {
 	h[strings.ToLower(key)] = value
 }
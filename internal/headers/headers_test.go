@@ -79,4 +79,20 @@ func TestHeaders(t *testing.T) {
 	require.Error(t, err)
 	assert.Equal(t, 0, n)
 	assert.False(t, done)
+
+	// Test: Repeated Cookie headers are joined with semicolons
+	headers = NewHeaders()
+	headers.Set("Cookie", "a=1")
+	headers.Set("Cookie", "b=2")
+	v, _ = headers.Get("cookie")
+	assert.Equal(t, "a=1; b=2", v)
+
+	// Test: Repeated Set-Cookie values stay separate
+	headers = NewHeaders()
+	headers.Set("Set-Cookie", "a=1; Expires=Tue, 01 Jan 2030 00:00:00 GMT")
+	headers.Set("Set-Cookie", "b=2")
+	assert.Equal(t, []string{"a=1; Expires=Tue, 01 Jan 2030 00:00:00 GMT", "b=2"}, headers.Values("set-cookie"))
+	headers.Set("Vary", "Origin")
+	assert.Equal(t, []string{"Origin"}, headers.Values("vary"))
+	assert.Nil(t, headers.Values("missing"))
 }
@@ -0,0 +1,202 @@
+package http2
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+type FrameType uint8
+
+const (
+	FrameData         FrameType = 0x0
+	FrameHeaders      FrameType = 0x1
+	FramePriority     FrameType = 0x2
+	FrameRSTStream    FrameType = 0x3
+	FrameSettings     FrameType = 0x4
+	FramePushPromise  FrameType = 0x5
+	FramePing         FrameType = 0x6
+	FrameGoAway       FrameType = 0x7
+	FrameWindowUpdate FrameType = 0x8
+	FrameContinuation FrameType = 0x9
+)
+
+const (
+	FlagEndStream  uint8 = 0x1
+	FlagAck        uint8 = 0x1
+	FlagEndHeaders uint8 = 0x4
+	FlagPadded     uint8 = 0x8
+	FlagPriority   uint8 = 0x20
+)
+
+type ErrorCode uint32
+
+const (
+	ErrCodeNo                 ErrorCode = 0x0
+	ErrCodeProtocol           ErrorCode = 0x1
+	ErrCodeInternal           ErrorCode = 0x2
+	ErrCodeFlowControl        ErrorCode = 0x3
+	ErrCodeSettingsTimeout    ErrorCode = 0x4
+	ErrCodeStreamClosed       ErrorCode = 0x5
+	ErrCodeFrameSize          ErrorCode = 0x6
+	ErrCodeRefusedStream      ErrorCode = 0x7
+	ErrCodeCancel             ErrorCode = 0x8
+	ErrCodeCompression        ErrorCode = 0x9
+	ErrCodeConnect            ErrorCode = 0xa
+	ErrCodeEnhanceYourCalm    ErrorCode = 0xb
+	ErrCodeInadequateSecurity ErrorCode = 0xc
+	ErrCodeHTTP11Required     ErrorCode = 0xd
+)
+
+type SettingID uint16
+
+const (
+	SettingHeaderTableSize      SettingID = 0x1
+	SettingEnablePush           SettingID = 0x2
+	SettingMaxConcurrentStreams SettingID = 0x3
+	SettingInitialWindowSize    SettingID = 0x4
+	SettingMaxFrameSize         SettingID = 0x5
+	SettingMaxHeaderListSize    SettingID = 0x6
+)
+
+type Setting struct {
+	ID    SettingID
+	Value uint32
+}
+
+const (
+	frameHeaderLen = 9
+
+	defaultHeaderTableSize   = 4096
+	defaultInitialWindowSize = 65535
+	defaultMaxFrameSize      = 16384
+	maxAllowedFrameSize      = 1<<24 - 1
+	maxWindowSize            = 1<<31 - 1
+)
+
+// ClientPreface is what every HTTP/2 client sends before its first frame.
+const ClientPreface = "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+
+type Frame struct {
+	Type     FrameType
+	Flags    uint8
+	StreamID uint32
+	Payload  []byte
+}
+
+func (f *Frame) Has(flag uint8) bool {
+	return f.Flags&flag != 0
+}
+
+// connError is a connection error: the whole connection is torn down with a
+// GOAWAY carrying Code.
+type connError struct {
+	Code   ErrorCode
+	Reason string
+}
+
+func (e connError) Error() string {
+	return fmt.Sprintf("http2: connection error %d: %s", e.Code, e.Reason)
+}
+
+// streamError only resets the one stream with RST_STREAM.
+type streamError struct {
+	StreamID uint32
+	Code     ErrorCode
+	Reason   string
+}
+
+func (e streamError) Error() string {
+	return fmt.Sprintf("http2: stream %d error %d: %s", e.StreamID, e.Code, e.Reason)
+}
+
+// ReadFrame reads one frame, rejecting payloads larger than maxFrameSize.
+func ReadFrame(r io.Reader, maxFrameSize uint32) (*Frame, error) {
+	header := make([]byte, frameHeaderLen)
+
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	length := uint32(header[0])<<16 | uint32(header[1])<<8 | uint32(header[2])
+
+	if length > maxFrameSize {
+		return nil, connError{ErrCodeFrameSize, fmt.Sprintf("frame of %d bytes exceeds %d", length, maxFrameSize)}
+	}
+
+	frame := &Frame{
+		Type:     FrameType(header[3]),
+		Flags:    header[4],
+		StreamID: binary.BigEndian.Uint32(header[5:]) & 0x7fffffff,
+		Payload:  make([]byte, length),
+	}
+
+	if _, err := io.ReadFull(r, frame.Payload); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+func WriteFrame(w io.Writer, frameType FrameType, flags uint8, streamID uint32, payload []byte) error {
+	header := [frameHeaderLen]byte{
+		byte(len(payload) >> 16), byte(len(payload) >> 8), byte(len(payload)),
+		byte(frameType), flags,
+	}
+	binary.BigEndian.PutUint32(header[5:], streamID&0x7fffffff)
+
+	if _, err := w.Write(header[:]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(payload)
+
+	return err
+}
+
+// stripPadding removes the pad length byte and trailing padding from DATA
+// and HEADERS payloads.
+func stripPadding(frame *Frame) ([]byte, error) {
+	payload := frame.Payload
+	if !frame.Has(FlagPadded) {
+		return payload, nil
+	}
+
+	if len(payload) == 0 {
+		return nil, connError{ErrCodeProtocol, "padded frame without pad length"}
+	}
+
+	padLength := int(payload[0])
+	payload = payload[1:]
+
+	if padLength > len(payload) {
+		return nil, connError{ErrCodeProtocol, "padding longer than payload"}
+	}
+
+	return payload[:len(payload)-padLength], nil
+}
+
+func parseSettings(payload []byte) ([]Setting, error) {
+	if len(payload)%6 != 0 {
+		return nil, connError{ErrCodeFrameSize, "settings payload not a multiple of 6"}
+	}
+
+	settings := make([]Setting, 0, len(payload)/6)
+	for i := 0; i < len(payload); i += 6 {
+		settings = append(settings, Setting{
+			ID:    SettingID(binary.BigEndian.Uint16(payload[i:])),
+			Value: binary.BigEndian.Uint32(payload[i+2:]),
+		})
+	}
+
+	return settings, nil
+}
+
+func encodeSettings(settings []Setting) []byte {
+	payload := make([]byte, 0, len(settings)*6)
+	for _, setting := range settings {
+		payload = binary.BigEndian.AppendUint16(payload, uint16(setting.ID))
+		payload = binary.BigEndian.AppendUint32(payload, setting.Value)
+	}
+	return payload
+}
@@ -0,0 +1,436 @@
+package http2
+
+import (
+	"errors"
+	"fmt"
+)
+
+// HeaderField is a single name/value pair carried in a header block.
+// Sensitive fields are never added to a compression table.
+type HeaderField struct {
+	Name      string
+	Value     string
+	Sensitive bool
+}
+
+func (f HeaderField) size() int {
+	return len(f.Name) + len(f.Value) + 32
+}
+
+var ErrHpackDecode = errors.New("hpack: invalid header block")
+
+// staticTable is the HPACK static table from RFC 7541 Appendix A. Index 1
+// is the first entry.
+var staticTable = []HeaderField{
+	{Name: ":authority"},
+	{Name: ":method", Value: "GET"},
+	{Name: ":method", Value: "POST"},
+	{Name: ":path", Value: "/"},
+	{Name: ":path", Value: "/index.html"},
+	{Name: ":scheme", Value: "http"},
+	{Name: ":scheme", Value: "https"},
+	{Name: ":status", Value: "200"},
+	{Name: ":status", Value: "204"},
+	{Name: ":status", Value: "206"},
+	{Name: ":status", Value: "304"},
+	{Name: ":status", Value: "400"},
+	{Name: ":status", Value: "404"},
+	{Name: ":status", Value: "500"},
+	{Name: "accept-charset"},
+	{Name: "accept-encoding", Value: "gzip, deflate"},
+	{Name: "accept-language"},
+	{Name: "accept-ranges"},
+	{Name: "accept"},
+	{Name: "access-control-allow-origin"},
+	{Name: "age"},
+	{Name: "allow"},
+	{Name: "authorization"},
+	{Name: "cache-control"},
+	{Name: "content-disposition"},
+	{Name: "content-encoding"},
+	{Name: "content-language"},
+	{Name: "content-length"},
+	{Name: "content-location"},
+	{Name: "content-range"},
+	{Name: "content-type"},
+	{Name: "cookie"},
+	{Name: "date"},
+	{Name: "etag"},
+	{Name: "expect"},
+	{Name: "expires"},
+	{Name: "from"},
+	{Name: "host"},
+	{Name: "if-match"},
+	{Name: "if-modified-since"},
+	{Name: "if-none-match"},
+	{Name: "if-range"},
+	{Name: "if-unmodified-since"},
+	{Name: "last-modified"},
+	{Name: "link"},
+	{Name: "location"},
+	{Name: "max-forwards"},
+	{Name: "proxy-authenticate"},
+	{Name: "proxy-authorization"},
+	{Name: "range"},
+	{Name: "referer"},
+	{Name: "refresh"},
+	{Name: "retry-after"},
+	{Name: "server"},
+	{Name: "set-cookie"},
+	{Name: "strict-transport-security"},
+	{Name: "transfer-encoding"},
+	{Name: "user-agent"},
+	{Name: "vary"},
+	{Name: "via"},
+	{Name: "www-authenticate"},
+}
+
+// dynamicTable is the FIFO table both sides of a connection keep in sync.
+// Newest entries come first, matching HPACK's indexing.
+type dynamicTable struct {
+	entries []HeaderField
+	size    int
+	maxSize int
+}
+
+func (t *dynamicTable) add(f HeaderField) {
+	t.entries = append([]HeaderField{f}, t.entries...)
+	t.size += f.size()
+	t.evict()
+}
+
+func (t *dynamicTable) setMaxSize(n int) {
+	t.maxSize = n
+	t.evict()
+}
+
+func (t *dynamicTable) evict() {
+	for t.size > t.maxSize && len(t.entries) > 0 {
+		last := t.entries[len(t.entries)-1]
+		t.entries = t.entries[:len(t.entries)-1]
+		t.size -= last.size()
+	}
+}
+
+func (t *dynamicTable) at(index int) (HeaderField, bool) {
+	if index < 1 {
+		return HeaderField{}, false
+	}
+	if index <= len(staticTable) {
+		return staticTable[index-1], true
+	}
+	index -= len(staticTable) + 1
+	if index >= len(t.entries) {
+		return HeaderField{}, false
+	}
+	return t.entries[index], true
+}
+
+// search returns the index of an entry matching both name and value, or
+// failing that one matching just the name. Zero means no match.
+func (t *dynamicTable) search(f HeaderField) (index int, nameOnly bool) {
+	nameIndex := 0
+	for i, entry := range staticTable {
+		if entry.Name != f.Name {
+			continue
+		}
+		if entry.Value == f.Value {
+			return i + 1, false
+		}
+		if nameIndex == 0 {
+			nameIndex = i + 1
+		}
+	}
+	for i, entry := range t.entries {
+		if entry.Name != f.Name {
+			continue
+		}
+		if entry.Value == f.Value {
+			return len(staticTable) + i + 1, false
+		}
+		if nameIndex == 0 {
+			nameIndex = len(staticTable) + i + 1
+		}
+	}
+	return nameIndex, true
+}
+
+// Decoder turns header blocks back into fields. One Decoder must see every
+// header block on a connection in order since they share a dynamic table.
+type Decoder struct {
+	table dynamicTable
+	// maxTableSize is the limit we advertised in SETTINGS_HEADER_TABLE_SIZE.
+	// Encoders may lower the table size but never raise it past this.
+	maxTableSize int
+	// maxListSize bounds the decoded header list, counted as
+	// SETTINGS_MAX_HEADER_LIST_SIZE counts it. Indexed fields cost a byte or
+	// two on the wire but can decode to whole table entries, so the limit
+	// has to apply to the decoded size rather than the block.
+	maxListSize int
+}
+
+// NewDecoder returns a Decoder whose header lists may decode to at most
+// maxListSize bytes. Zero means no limit.
+func NewDecoder(maxTableSize int, maxListSize int) *Decoder {
+	return &Decoder{
+		table:        dynamicTable{maxSize: maxTableSize},
+		maxTableSize: maxTableSize,
+		maxListSize:  maxListSize,
+	}
+}
+
+func (d *Decoder) Decode(block []byte) ([]HeaderField, error) {
+	fields := []HeaderField{}
+	sawField := false
+	size := 0
+
+	// emit adds a field to the list, checking the limit before the field is
+	// kept.
+	emit := func(field HeaderField) error {
+		size += field.size()
+		if d.maxListSize > 0 && size > d.maxListSize {
+			return fmt.Errorf("%w: header list larger than %d bytes", ErrHpackDecode, d.maxListSize)
+		}
+		fields = append(fields, field)
+		return nil
+	}
+
+	for len(block) > 0 {
+		b := block[0]
+		switch {
+		case b&0x80 != 0:
+			index, rest, err := readInt(block, 7)
+			if err != nil {
+				return nil, err
+			}
+			field, ok := d.table.at(int(index))
+			if !ok {
+				return nil, fmt.Errorf("%w: index %d out of range", ErrHpackDecode, index)
+			}
+			if err := emit(HeaderField{Name: field.Name, Value: field.Value}); err != nil {
+				return nil, err
+			}
+			block = rest
+			sawField = true
+		case b&0xc0 == 0x40:
+			field, rest, err := d.readLiteral(block, 6)
+			if err != nil {
+				return nil, err
+			}
+			d.table.add(field)
+			if err := emit(field); err != nil {
+				return nil, err
+			}
+			block = rest
+			sawField = true
+		case b&0xe0 == 0x20:
+			if sawField {
+				return nil, fmt.Errorf("%w: table size update after a header field", ErrHpackDecode)
+			}
+			size, rest, err := readInt(block, 5)
+			if err != nil {
+				return nil, err
+			}
+			if int(size) > d.maxTableSize {
+				return nil, fmt.Errorf("%w: table size %d exceeds limit", ErrHpackDecode, size)
+			}
+			d.table.setMaxSize(int(size))
+			block = rest
+		default:
+			field, rest, err := d.readLiteral(block, 4)
+			if err != nil {
+				return nil, err
+			}
+			field.Sensitive = b&0xf0 == 0x10
+			if err := emit(field); err != nil {
+				return nil, err
+			}
+			block = rest
+			sawField = true
+		}
+	}
+
+	return fields, nil
+}
+
+func (d *Decoder) readLiteral(block []byte, prefix uint8) (HeaderField, []byte, error) {
+	index, rest, err := readInt(block, prefix)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	var field HeaderField
+	if index > 0 {
+		indexed, ok := d.table.at(int(index))
+		if !ok {
+			return HeaderField{}, nil, fmt.Errorf("%w: index %d out of range", ErrHpackDecode, index)
+		}
+		field.Name = indexed.Name
+	} else {
+		field.Name, rest, err = d.readString(rest)
+		if err != nil {
+			return HeaderField{}, nil, err
+		}
+	}
+
+	field.Value, rest, err = d.readString(rest)
+	if err != nil {
+		return HeaderField{}, nil, err
+	}
+
+	return field, rest, nil
+}
+
+func (d *Decoder) readString(block []byte) (string, []byte, error) {
+	if len(block) == 0 {
+		return "", nil, fmt.Errorf("%w: truncated string", ErrHpackDecode)
+	}
+
+	huffman := block[0]&0x80 != 0
+	length, rest, err := readInt(block, 7)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if uint64(len(rest)) < length {
+		return "", nil, fmt.Errorf("%w: truncated string", ErrHpackDecode)
+	}
+
+	if d.maxListSize > 0 && length > uint64(d.maxListSize) {
+		return "", nil, fmt.Errorf("%w: string longer than %d bytes", ErrHpackDecode, d.maxListSize)
+	}
+
+	data, rest := rest[:length], rest[length:]
+
+	if !huffman {
+		return string(data), rest, nil
+	}
+
+	decoded, err := huffmanDecode(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return string(decoded), rest, nil
+}
+
+// Encoder produces header blocks. Like the Decoder it must see every block
+// sent on a connection, in the order they are written.
+type Encoder struct {
+	table dynamicTable
+	// pendingSizeUpdate is set when the peer changed its table size and the
+	// next block has to announce the new size.
+	pendingSizeUpdate bool
+}
+
+func NewEncoder() *Encoder {
+	return &Encoder{table: dynamicTable{maxSize: defaultHeaderTableSize}}
+}
+
+// SetMaxTableSize applies the peer's SETTINGS_HEADER_TABLE_SIZE.
+func (e *Encoder) SetMaxTableSize(n int) {
+	if n == e.table.maxSize {
+		return
+	}
+	e.table.setMaxSize(n)
+	e.pendingSizeUpdate = true
+}
+
+func (e *Encoder) Encode(fields []HeaderField) []byte {
+	block := []byte{}
+
+	if e.pendingSizeUpdate {
+		block = appendInt(block, 0x20, 5, uint64(e.table.maxSize))
+		e.pendingSizeUpdate = false
+	}
+
+	for _, field := range fields {
+		index, nameOnly := e.table.search(field)
+
+		if index > 0 && !nameOnly && !field.Sensitive {
+			block = appendInt(block, 0x80, 7, uint64(index))
+			continue
+		}
+
+		var first byte
+		var prefix uint8
+		switch {
+		case field.Sensitive:
+			first, prefix = 0x10, 4
+		case field.size() > e.table.maxSize:
+			first, prefix = 0x00, 4
+		default:
+			first, prefix = 0x40, 6
+			e.table.add(HeaderField{Name: field.Name, Value: field.Value})
+		}
+
+		block = appendInt(block, first, prefix, uint64(index))
+		if index == 0 {
+			block = appendString(block, field.Name)
+		}
+		block = appendString(block, field.Value)
+	}
+
+	return block
+}
+
+func readInt(block []byte, prefix uint8) (uint64, []byte, error) {
+	if len(block) == 0 {
+		return 0, nil, fmt.Errorf("%w: truncated integer", ErrHpackDecode)
+	}
+
+	mask := uint64(1)<<prefix - 1
+	value := uint64(block[0]) & mask
+	block = block[1:]
+
+	if value < mask {
+		return value, block, nil
+	}
+
+	var shift uint
+	for {
+		if len(block) == 0 {
+			return 0, nil, fmt.Errorf("%w: truncated integer", ErrHpackDecode)
+		}
+		if shift > 56 {
+			return 0, nil, fmt.Errorf("%w: integer overflow", ErrHpackDecode)
+		}
+		b := block[0]
+		block = block[1:]
+		value += uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return value, block, nil
+		}
+	}
+}
+
+func appendInt(dst []byte, first byte, prefix uint8, value uint64) []byte {
+	mask := uint64(1)<<prefix - 1
+
+	if value < mask {
+		return append(dst, first|byte(value))
+	}
+
+	dst = append(dst, first|byte(mask))
+	value -= mask
+
+	for value >= 0x80 {
+		dst = append(dst, byte(value&0x7f)|0x80)
+		value >>= 7
+	}
+
+	return append(dst, byte(value))
+}
+
+// appendString writes s Huffman encoded when that is shorter.
+func appendString(dst []byte, s string) []byte {
+	if huffmanLength(s) < len(s) {
+		encoded := huffmanEncode(nil, s)
+		dst = appendInt(dst, 0x80, 7, uint64(len(encoded)))
+		return append(dst, encoded...)
+	}
+
+	dst = appendInt(dst, 0x00, 7, uint64(len(s)))
+	return append(dst, s...)
+}
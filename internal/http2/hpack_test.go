@@ -0,0 +1,182 @@
+package http2
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(strings.ReplaceAll(s, " ", ""))
+	require.NoError(t, err)
+	return b
+}
+
+// rfcRequests are the three requests of RFC 7541 Appendix C.3 and C.4.
+var rfcRequests = [][]HeaderField{
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+	},
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "http"},
+		{Name: ":path", Value: "/"},
+		{Name: ":authority", Value: "www.example.com"},
+		{Name: "cache-control", Value: "no-cache"},
+	},
+	{
+		{Name: ":method", Value: "GET"},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":path", Value: "/index.html"},
+		{Name: ":authority", Value: "www.example.com"},
+		{Name: "custom-key", Value: "custom-value"},
+	},
+}
+
+func TestHpack(t *testing.T) {
+	// Test: Decodes the RFC 7541 C.3 requests without Huffman coding
+	decoder := NewDecoder(defaultHeaderTableSize, 0)
+	for i, block := range []string{
+		"8286 8441 0f77 7777 2e65 7861 6d70 6c65 2e63 6f6d",
+		"8286 84be 5808 6e6f 2d63 6163 6865",
+		"8287 85bf 400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65",
+	} {
+		fields, err := decoder.Decode(mustHex(t, block))
+		require.NoError(t, err)
+		assert.Equal(t, rfcRequests[i], fields)
+	}
+	assert.Equal(t, 164, decoder.table.size)
+
+	// Test: Encodes the RFC 7541 C.4 requests byte for byte with Huffman coding
+	huffmanBlocks := []string{
+		"8286 8441 8cf1 e3c2 e5f2 3a6b a0ab 90f4 ff",
+		"8286 84be 5886 a8eb 1064 9cbf",
+		"8287 85bf 4088 25a8 49e9 5ba9 7d7f 8925 a849 e95b b8e8 b4bf",
+	}
+	encoder := NewEncoder()
+	for i, fields := range rfcRequests {
+		assert.Equal(t, mustHex(t, huffmanBlocks[i]), encoder.Encode(fields))
+	}
+
+	// Test: Decodes the RFC 7541 C.4 requests
+	decoder = NewDecoder(defaultHeaderTableSize, 0)
+	for i, block := range huffmanBlocks {
+		fields, err := decoder.Decode(mustHex(t, block))
+		require.NoError(t, err)
+		assert.Equal(t, rfcRequests[i], fields)
+	}
+
+	// Test: Sensitive fields are never indexed
+	encoder = NewEncoder()
+	decoder = NewDecoder(defaultHeaderTableSize, 0)
+	sensitive := []HeaderField{{Name: "authorization", Value: "secret", Sensitive: true}}
+	block := encoder.Encode(sensitive)
+	assert.Equal(t, byte(0x10), block[0]&0xf0)
+	fields, err := decoder.Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, sensitive, fields)
+	assert.Empty(t, encoder.table.entries)
+	assert.Empty(t, decoder.table.entries)
+
+	// Test: Table size updates shrink the table and evict entries
+	encoder = NewEncoder()
+	decoder = NewDecoder(defaultHeaderTableSize, 0)
+	encoder.Encode(rfcRequests[2])
+	encoder.SetMaxTableSize(0)
+	block = encoder.Encode(rfcRequests[2])
+	assert.Equal(t, byte(0x20), block[0])
+	fields, err = decoder.Decode(block)
+	require.NoError(t, err)
+	assert.Equal(t, rfcRequests[2], fields)
+	assert.Empty(t, decoder.table.entries)
+
+	// Test: Table size update larger than advertised
+	decoder = NewDecoder(defaultHeaderTableSize, 0)
+	_, err = decoder.Decode(appendInt(nil, 0x20, 5, defaultHeaderTableSize+1))
+	require.ErrorIs(t, err, ErrHpackDecode)
+
+	// Test: Index out of range
+	_, err = decoder.Decode([]byte{0xff, 0x00})
+	require.ErrorIs(t, err, ErrHpackDecode)
+
+	// Test: Truncated string
+	_, err = decoder.Decode(mustHex(t, "400a 6375"))
+	require.ErrorIs(t, err, ErrHpackDecode)
+
+	// Test: String longer than the limit
+	decoder = NewDecoder(defaultHeaderTableSize, 4)
+	_, err = decoder.Decode(mustHex(t, "400a 6375 7374 6f6d 2d6b 6579 0c63 7573 746f 6d2d 7661 6c75 65"))
+	require.ErrorIs(t, err, ErrHpackDecode)
+
+	// Test: Indexed references count toward the decoded header list size
+	amplified := append([]byte{0x40, 0x01, 'x', 0x64}, bytes.Repeat([]byte{'v'}, 100)...)
+	amplified = append(amplified, bytes.Repeat([]byte{0xbe}, 20)...)
+	decoder = NewDecoder(defaultHeaderTableSize, 1000)
+	_, err = decoder.Decode(amplified)
+	require.ErrorIs(t, err, ErrHpackDecode)
+	decoder = NewDecoder(defaultHeaderTableSize, 0)
+	fields, err = decoder.Decode(amplified)
+	require.NoError(t, err)
+	assert.Len(t, fields, 21)
+
+	// Test: Invalid Huffman padding
+	_, err = huffmanDecode([]byte{0x00})
+	require.Error(t, err)
+
+	// Test: Huffman round trip of every byte value
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	decoded, err := huffmanDecode(huffmanEncode(nil, string(all)))
+	require.NoError(t, err)
+	assert.Equal(t, all, decoded)
+}
+
+func TestFrames(t *testing.T) {
+	// Test: Frames round trip
+	var buf bytes.Buffer
+	require.NoError(t, WriteFrame(&buf, FrameHeaders, FlagEndHeaders|FlagEndStream, 3, []byte("block")))
+	frame, err := ReadFrame(&buf, defaultMaxFrameSize)
+	require.NoError(t, err)
+	assert.Equal(t, &Frame{Type: FrameHeaders, Flags: FlagEndHeaders | FlagEndStream, StreamID: 3, Payload: []byte("block")}, frame)
+	assert.True(t, frame.Has(FlagEndStream))
+	assert.False(t, frame.Has(FlagPadded))
+
+	// Test: Frames over the maximum size
+	buf.Reset()
+	require.NoError(t, WriteFrame(&buf, FrameData, 0, 1, make([]byte, defaultMaxFrameSize+1)))
+	_, err = ReadFrame(&buf, defaultMaxFrameSize)
+	var ce connError
+	require.ErrorAs(t, err, &ce)
+	assert.Equal(t, ErrCodeFrameSize, ce.Code)
+
+	// Test: Padding is stripped
+	padded := &Frame{Type: FrameData, Flags: FlagPadded, StreamID: 1, Payload: []byte{2, 'h', 'i', 0, 0}}
+	payload, err := stripPadding(padded)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hi"), payload)
+
+	// Test: Padding longer than the payload
+	padded.Payload = []byte{5, 'h', 'i'}
+	_, err = stripPadding(padded)
+	require.Error(t, err)
+
+	// Test: Settings round trip
+	settings := []Setting{{ID: SettingMaxFrameSize, Value: 1 << 20}, {ID: SettingEnablePush, Value: 0}}
+	parsed, err := parseSettings(encodeSettings(settings))
+	require.NoError(t, err)
+	assert.Equal(t, settings, parsed)
+
+	// Test: Settings payload with a partial entry
+	_, err = parseSettings([]byte{0, 1, 0})
+	require.Error(t, err)
+}
@@ -0,0 +1,117 @@
+package http2
+
+import (
+	"fmt"
+	"sync"
+)
+
+// huffmanEOS is the end-of-string symbol. It only ever appears as padding
+// and decoding it is an error.
+const (
+	huffmanEOS     = 256
+	huffmanEOSCode = 0x3fffffff
+	huffmanEOSLen  = 30
+)
+
+type huffmanNode struct {
+	children [2]*huffmanNode
+	symbol   int
+	leaf     bool
+}
+
+var (
+	huffmanRoot     *huffmanNode
+	huffmanRootOnce sync.Once
+)
+
+func buildHuffmanTree() {
+	huffmanRoot = &huffmanNode{}
+	insert := func(symbol int, code uint32, length uint8) {
+		node := huffmanRoot
+		for i := int(length) - 1; i >= 0; i-- {
+			bit := (code >> uint(i)) & 1
+			if node.children[bit] == nil {
+				node.children[bit] = &huffmanNode{}
+			}
+			node = node.children[bit]
+		}
+		node.symbol = symbol
+		node.leaf = true
+	}
+
+	for symbol, code := range huffmanCodes {
+		insert(symbol, code, huffmanCodeLens[symbol])
+	}
+	insert(huffmanEOS, huffmanEOSCode, huffmanEOSLen)
+}
+
+func huffmanDecode(data []byte) ([]byte, error) {
+	huffmanRootOnce.Do(buildHuffmanTree)
+
+	out := make([]byte, 0, len(data)*8/5)
+	node := huffmanRoot
+	// pendingBits counts the bits read since the last complete symbol and
+	// pendingOnes whether all of them were 1s, which is the only valid
+	// padding.
+	pendingBits := 0
+	pendingOnes := true
+
+	for _, b := range data {
+		for i := 7; i >= 0; i-- {
+			bit := (b >> uint(i)) & 1
+			node = node.children[bit]
+			if node == nil {
+				return nil, fmt.Errorf("%w: invalid huffman code", ErrHpackDecode)
+			}
+			pendingBits++
+			pendingOnes = pendingOnes && bit == 1
+
+			if !node.leaf {
+				continue
+			}
+			if node.symbol == huffmanEOS {
+				return nil, fmt.Errorf("%w: huffman string contains EOS", ErrHpackDecode)
+			}
+			out = append(out, byte(node.symbol))
+			node = huffmanRoot
+			pendingBits = 0
+			pendingOnes = true
+		}
+	}
+
+	if pendingBits > 7 || !pendingOnes {
+		return nil, fmt.Errorf("%w: invalid huffman padding", ErrHpackDecode)
+	}
+
+	return out, nil
+}
+
+func huffmanLength(s string) int {
+	bits := 0
+	for i := 0; i < len(s); i++ {
+		bits += int(huffmanCodeLens[s[i]])
+	}
+	return (bits + 7) / 8
+}
+
+func huffmanEncode(dst []byte, s string) []byte {
+	var acc uint64
+	var accBits uint
+
+	for i := 0; i < len(s); i++ {
+		length := uint(huffmanCodeLens[s[i]])
+		acc = acc<<length | uint64(huffmanCodes[s[i]])
+		accBits += length
+		for accBits >= 8 {
+			accBits -= 8
+			dst = append(dst, byte(acc>>accBits))
+		}
+	}
+
+	if accBits > 0 {
+		padding := 8 - accBits
+		dst = append(dst, byte(acc<<padding)|byte(1<<padding-1))
+	}
+
+	return dst
+}
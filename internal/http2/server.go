@@ -0,0 +1,945 @@
+package http2
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+)
+
+// NextProto is the ALPN protocol identifier for HTTP/2 over TLS.
+const NextProto = "h2"
+
+const (
+	defaultMaxConcurrentStreams = 100
+	// defaultMaxHeaderBlockSize bounds an encoded header block, HEADERS plus
+	// CONTINUATION frames, when MaxHeaderBytes is not set.
+	defaultMaxHeaderBlockSize = 1 << 20
+	// defaultMaxHeaderListSize bounds a decoded header list when
+	// MaxHeaderBytes is not set.
+	defaultMaxHeaderListSize = 1 << 20
+	// defaultMaxBodyBytes bounds each buffered request body when
+	// MaxBodyBytes is not set. Window updates are sent as data arrives, so
+	// this is what keeps a client from making the server buffer without end.
+	defaultMaxBodyBytes = 10 << 20
+)
+
+var errStreamClosed = errors.New("http2: stream closed")
+
+type Handler func(w *response.Writer, req *request.Request)
+
+type Options struct {
+	// BaseContext is the parent of every stream's request context.
+	BaseContext context.Context
+	// Shutdown, when closed, makes the connection send GOAWAY, finish the
+	// streams it already accepted and then close.
+	Shutdown <-chan struct{}
+	// MaxConcurrentStreams limits streams open at once. Defaults to 100.
+	MaxConcurrentStreams uint32
+	// IdleTimeout closes the connection after it has had no open streams
+	// for this long. Zero means never.
+	IdleTimeout time.Duration
+	// MaxHeaderBytes limits the decoded header list of a request and is
+	// advertised as SETTINGS_MAX_HEADER_LIST_SIZE. A larger list is a
+	// connection error. Defaults to 1 MiB.
+	MaxHeaderBytes int
+	// MaxBodyBytes limits a request body, which is buffered in full before
+	// the handler runs. Larger requests get a 413. Defaults to 10 MiB.
+	MaxBodyBytes int
+	// TLS is exposed on every request when the connection is encrypted.
+	TLS *tls.ConnectionState
+	// Upgrade is an HTTP/1.1 request that asked to switch to h2c. It is
+	// served as stream 1 once the switch is made, using UpgradeSettings
+	// (the decoded HTTP2-Settings header) as the client's initial settings.
+	Upgrade         *request.Request
+	UpgradeSettings []byte
+}
+
+// ServeConn speaks HTTP/2 on conn until the client goes away, the
+// connection fails or a shutdown completes. Frames are read from reader,
+// which lets callers hand over bytes they already buffered while sniffing
+// the protocol; it may be conn itself.
+func ServeConn(conn net.Conn, reader io.Reader, handler Handler, opts Options) error {
+	if opts.BaseContext == nil {
+		opts.BaseContext = context.Background()
+	}
+	if opts.MaxConcurrentStreams == 0 {
+		opts.MaxConcurrentStreams = defaultMaxConcurrentStreams
+	}
+	if opts.MaxBodyBytes <= 0 {
+		opts.MaxBodyBytes = defaultMaxBodyBytes
+	}
+
+	ctx, cancel := context.WithCancel(opts.BaseContext)
+	defer cancel()
+
+	sc := &serverConn{
+		conn:              conn,
+		reader:            bufio.NewReader(reader),
+		bw:                bufio.NewWriter(conn),
+		handler:           handler,
+		opts:              opts,
+		ctx:               ctx,
+		decoder:           NewDecoder(defaultHeaderTableSize, maxHeaderListSize(opts)),
+		encoder:           NewEncoder(),
+		streams:           map[uint32]*stream{},
+		sendWindow:        defaultInitialWindowSize,
+		peerInitialWindow: defaultInitialWindowSize,
+		peerMaxFrameSize:  defaultMaxFrameSize,
+	}
+	sc.cond = sync.NewCond(&sc.mu)
+
+	return sc.serve()
+}
+
+type serverConn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	handler Handler
+	opts    Options
+	ctx     context.Context
+
+	// Only touched by the goroutine reading frames.
+	decoder        *Decoder
+	sawSettings    bool
+	headerStream   uint32
+	headerBlock    []byte
+	headerEndFlags uint8
+
+	// writeMu serialises frames on the wire. The encoder lives under it too
+	// because header blocks must hit the wire in the order they were
+	// compressed.
+	writeMu sync.Mutex
+	bw      *bufio.Writer
+	encoder *Encoder
+
+	mu                sync.Mutex
+	cond              *sync.Cond
+	streams           map[uint32]*stream
+	lastStreamID      uint32
+	sendWindow        int64
+	peerInitialWindow int64
+	peerMaxFrameSize  uint32
+	goingAway         bool
+	closed            bool
+	idleTimer         *time.Timer
+
+	handlers sync.WaitGroup
+}
+
+func (sc *serverConn) serve() error {
+	defer sc.handlers.Wait()
+	defer sc.shutdownStreams()
+
+	err := sc.writeFrame(FrameSettings, 0, 0, encodeSettings([]Setting{
+		{ID: SettingMaxConcurrentStreams, Value: sc.opts.MaxConcurrentStreams},
+		{ID: SettingInitialWindowSize, Value: defaultInitialWindowSize},
+		{ID: SettingMaxFrameSize, Value: defaultMaxFrameSize},
+		{ID: SettingMaxHeaderListSize, Value: uint32(maxHeaderListSize(sc.opts))},
+	}))
+
+	if err != nil {
+		return err
+	}
+
+	if sc.opts.Upgrade != nil {
+		if err := sc.startUpgradedStream(); err != nil {
+			sc.goAway(ErrCodeProtocol)
+			return err
+		}
+	}
+
+	if err := sc.readPreface(); err != nil {
+		return err
+	}
+
+	go sc.watchShutdown()
+	sc.resetIdleTimer()
+
+	for {
+		frame, err := ReadFrame(sc.reader, defaultMaxFrameSize)
+
+		if err == nil {
+			err = sc.processFrame(frame)
+		}
+
+		var se streamError
+		if errors.As(err, &se) {
+			sc.resetStream(se.StreamID, se.Code)
+			continue
+		}
+
+		var ce connError
+		if errors.As(err, &ce) {
+			sc.goAway(ce.Code)
+			return ce
+		}
+
+		if err != nil {
+			if errors.Is(err, io.EOF) || sc.isClosed() {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+func (sc *serverConn) readPreface() error {
+	preface := make([]byte, len(ClientPreface))
+
+	if _, err := io.ReadFull(sc.reader, preface); err != nil {
+		return err
+	}
+
+	if string(preface) != ClientPreface {
+		sc.goAway(ErrCodeProtocol)
+		return fmt.Errorf("http2: invalid client preface %q", preface)
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processFrame(frame *Frame) error {
+	if sc.headerBlock != nil && (frame.Type != FrameContinuation || frame.StreamID != sc.headerStream) {
+		return connError{ErrCodeProtocol, "expected CONTINUATION"}
+	}
+
+	if !sc.sawSettings {
+		if frame.Type != FrameSettings || frame.Has(FlagAck) {
+			return connError{ErrCodeProtocol, "first frame must be SETTINGS"}
+		}
+		sc.sawSettings = true
+	}
+
+	switch frame.Type {
+	case FrameSettings:
+		return sc.processSettings(frame)
+	case FrameHeaders:
+		return sc.processHeaders(frame)
+	case FrameContinuation:
+		return sc.processContinuation(frame)
+	case FrameData:
+		return sc.processData(frame)
+	case FrameWindowUpdate:
+		return sc.processWindowUpdate(frame)
+	case FramePing:
+		return sc.processPing(frame)
+	case FrameRSTStream:
+		return sc.processRSTStream(frame)
+	case FrameGoAway:
+		return sc.processGoAway(frame)
+	case FramePriority:
+		if frame.StreamID == 0 {
+			return connError{ErrCodeProtocol, "PRIORITY on stream 0"}
+		}
+		if len(frame.Payload) != 5 {
+			return streamError{frame.StreamID, ErrCodeFrameSize, "PRIORITY payload must be 5 bytes"}
+		}
+		return nil
+	case FramePushPromise:
+		return connError{ErrCodeProtocol, "clients cannot push"}
+	default:
+		return nil
+	}
+}
+
+func (sc *serverConn) processSettings(frame *Frame) error {
+	if frame.StreamID != 0 {
+		return connError{ErrCodeProtocol, "SETTINGS on a stream"}
+	}
+
+	if frame.Has(FlagAck) {
+		if len(frame.Payload) != 0 {
+			return connError{ErrCodeFrameSize, "SETTINGS ack with payload"}
+		}
+		return nil
+	}
+
+	settings, err := parseSettings(frame.Payload)
+
+	if err != nil {
+		return err
+	}
+
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+
+	return sc.writeFrame(FrameSettings, FlagAck, 0, nil)
+}
+
+func (sc *serverConn) applySettings(settings []Setting) error {
+	for _, setting := range settings {
+		switch setting.ID {
+		case SettingHeaderTableSize:
+			sc.writeMu.Lock()
+			sc.encoder.SetMaxTableSize(int(min(setting.Value, defaultHeaderTableSize)))
+			sc.writeMu.Unlock()
+		case SettingEnablePush:
+			if setting.Value > 1 {
+				return connError{ErrCodeProtocol, "ENABLE_PUSH must be 0 or 1"}
+			}
+		case SettingInitialWindowSize:
+			if setting.Value > maxWindowSize {
+				return connError{ErrCodeFlowControl, "INITIAL_WINDOW_SIZE too large"}
+			}
+			sc.mu.Lock()
+			delta := int64(setting.Value) - sc.peerInitialWindow
+			sc.peerInitialWindow = int64(setting.Value)
+			for _, st := range sc.streams {
+				st.sendWindow += delta
+			}
+			sc.cond.Broadcast()
+			sc.mu.Unlock()
+		case SettingMaxFrameSize:
+			if setting.Value < defaultMaxFrameSize || setting.Value > maxAllowedFrameSize {
+				return connError{ErrCodeProtocol, "MAX_FRAME_SIZE out of range"}
+			}
+			sc.mu.Lock()
+			sc.peerMaxFrameSize = setting.Value
+			sc.mu.Unlock()
+		}
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processHeaders(frame *Frame) error {
+	if frame.StreamID == 0 || frame.StreamID%2 == 0 {
+		return connError{ErrCodeProtocol, "HEADERS on an invalid stream id"}
+	}
+
+	payload, err := stripPadding(frame)
+
+	if err != nil {
+		return err
+	}
+
+	if frame.Has(FlagPriority) {
+		if len(payload) < 5 {
+			return connError{ErrCodeFrameSize, "HEADERS priority fields truncated"}
+		}
+		payload = payload[5:]
+	}
+
+	sc.headerStream = frame.StreamID
+	sc.headerBlock = append([]byte{}, payload...)
+	sc.headerEndFlags = frame.Flags & FlagEndStream
+
+	if frame.Has(FlagEndHeaders) {
+		return sc.endHeaderBlock()
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processContinuation(frame *Frame) error {
+	if sc.headerBlock == nil {
+		return connError{ErrCodeProtocol, "unexpected CONTINUATION"}
+	}
+
+	sc.headerBlock = append(sc.headerBlock, frame.Payload...)
+
+	limit := defaultMaxHeaderBlockSize
+	if sc.opts.MaxHeaderBytes > 0 {
+		limit = 4*sc.opts.MaxHeaderBytes + defaultMaxFrameSize
+	}
+
+	if len(sc.headerBlock) > limit {
+		return connError{ErrCodeEnhanceYourCalm, "header block too large"}
+	}
+
+	if frame.Has(FlagEndHeaders) {
+		return sc.endHeaderBlock()
+	}
+
+	return nil
+}
+
+// endHeaderBlock decodes a complete header block. Decoding has to happen
+// even for streams that end up refused so the HPACK tables stay in sync.
+func (sc *serverConn) endHeaderBlock() error {
+	id, block, endStream := sc.headerStream, sc.headerBlock, sc.headerEndFlags&FlagEndStream != 0
+	sc.headerBlock = nil
+
+	fields, err := sc.decoder.Decode(block)
+
+	if err != nil {
+		return connError{ErrCodeCompression, err.Error()}
+	}
+
+	sc.mu.Lock()
+	st, exists := sc.streams[id]
+	lastStreamID := sc.lastStreamID
+	goingAway := sc.goingAway
+	active := len(sc.streams)
+	sc.mu.Unlock()
+
+	if exists {
+		// A second header block on an open stream carries trailers.
+		if st.remoteClosed {
+			return streamError{id, ErrCodeStreamClosed, "HEADERS after END_STREAM"}
+		}
+		if !endStream {
+			return streamError{id, ErrCodeProtocol, "trailers without END_STREAM"}
+		}
+		return sc.endRequestBody(st)
+	}
+
+	if id <= lastStreamID {
+		return connError{ErrCodeStreamClosed, "HEADERS on a closed stream"}
+	}
+
+	sc.mu.Lock()
+	sc.lastStreamID = id
+	sc.mu.Unlock()
+
+	if goingAway {
+		return nil
+	}
+
+	if uint32(active) >= sc.opts.MaxConcurrentStreams {
+		return streamError{id, ErrCodeRefusedStream, "too many concurrent streams"}
+	}
+
+	req, err := sc.newRequest(fields)
+	st = sc.openStream(id, req)
+
+	if err != nil {
+		sc.closeStream(st)
+		return streamError{id, ErrCodeProtocol, err.Error()}
+	}
+
+	if err := st.checkContentLength(); err != nil {
+		sc.respondError(st, response.ContentTooLargeStatus, err.Error())
+		return nil
+	}
+
+	if endStream {
+		return sc.endRequestBody(st)
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processData(frame *Frame) error {
+	if frame.StreamID == 0 {
+		return connError{ErrCodeProtocol, "DATA on stream 0"}
+	}
+
+	// Everything received counts against flow control, padding included,
+	// so hand it straight back. Bodies are buffered in full before the
+	// handler runs, bounded by MaxBodyBytes.
+	if len(frame.Payload) > 0 {
+		sc.sendWindowUpdate(0, uint32(len(frame.Payload)))
+	}
+
+	sc.mu.Lock()
+	st, exists := sc.streams[frame.StreamID]
+	lastStreamID := sc.lastStreamID
+	sc.mu.Unlock()
+
+	if !exists {
+		if frame.StreamID > lastStreamID {
+			return connError{ErrCodeProtocol, "DATA on an idle stream"}
+		}
+		return streamError{frame.StreamID, ErrCodeStreamClosed, "DATA on a closed stream"}
+	}
+
+	if st.remoteClosed {
+		if st.discardBody {
+			return nil
+		}
+		return streamError{frame.StreamID, ErrCodeStreamClosed, "DATA after END_STREAM"}
+	}
+
+	payload, err := stripPadding(frame)
+
+	if err != nil {
+		return err
+	}
+
+	if len(frame.Payload) > 0 && !frame.Has(FlagEndStream) {
+		sc.sendWindowUpdate(st.id, uint32(len(frame.Payload)))
+	}
+
+	st.body = append(st.body, payload...)
+
+	if len(st.body) > sc.opts.MaxBodyBytes {
+		sc.respondError(st, response.ContentTooLargeStatus, request.ErrBodyTooLarge.Error())
+		return nil
+	}
+
+	if frame.Has(FlagEndStream) {
+		return sc.endRequestBody(st)
+	}
+
+	return nil
+}
+
+func (sc *serverConn) processWindowUpdate(frame *Frame) error {
+	if len(frame.Payload) != 4 {
+		return connError{ErrCodeFrameSize, "WINDOW_UPDATE payload must be 4 bytes"}
+	}
+
+	increment := int64(binary.BigEndian.Uint32(frame.Payload) & 0x7fffffff)
+
+	if increment == 0 {
+		if frame.StreamID == 0 {
+			return connError{ErrCodeProtocol, "WINDOW_UPDATE of 0"}
+		}
+		return streamError{frame.StreamID, ErrCodeProtocol, "WINDOW_UPDATE of 0"}
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	defer sc.cond.Broadcast()
+
+	if frame.StreamID == 0 {
+		if sc.sendWindow+increment > maxWindowSize {
+			return connError{ErrCodeFlowControl, "connection window overflow"}
+		}
+		sc.sendWindow += increment
+		return nil
+	}
+
+	st, exists := sc.streams[frame.StreamID]
+
+	if !exists {
+		return nil
+	}
+
+	if st.sendWindow+increment > maxWindowSize {
+		return streamError{frame.StreamID, ErrCodeFlowControl, "stream window overflow"}
+	}
+
+	st.sendWindow += increment
+
+	return nil
+}
+
+func (sc *serverConn) processPing(frame *Frame) error {
+	if frame.StreamID != 0 {
+		return connError{ErrCodeProtocol, "PING on a stream"}
+	}
+
+	if len(frame.Payload) != 8 {
+		return connError{ErrCodeFrameSize, "PING payload must be 8 bytes"}
+	}
+
+	if frame.Has(FlagAck) {
+		return nil
+	}
+
+	return sc.writeFrame(FramePing, FlagAck, 0, frame.Payload)
+}
+
+func (sc *serverConn) processRSTStream(frame *Frame) error {
+	if frame.StreamID == 0 {
+		return connError{ErrCodeProtocol, "RST_STREAM on stream 0"}
+	}
+
+	if len(frame.Payload) != 4 {
+		return connError{ErrCodeFrameSize, "RST_STREAM payload must be 4 bytes"}
+	}
+
+	sc.mu.Lock()
+	st, exists := sc.streams[frame.StreamID]
+	lastStreamID := sc.lastStreamID
+	sc.mu.Unlock()
+
+	if !exists {
+		if frame.StreamID > lastStreamID {
+			return connError{ErrCodeProtocol, "RST_STREAM on an idle stream"}
+		}
+		return nil
+	}
+
+	sc.closeStream(st)
+
+	return nil
+}
+
+func (sc *serverConn) processGoAway(frame *Frame) error {
+	if frame.StreamID != 0 {
+		return connError{ErrCodeProtocol, "GOAWAY on a stream"}
+	}
+
+	sc.mu.Lock()
+	sc.goingAway = true
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	if idle {
+		sc.conn.Close()
+	}
+
+	return nil
+}
+
+// maxHeaderListSize is the decoded header list limit for a connection.
+func maxHeaderListSize(opts Options) int {
+	if opts.MaxHeaderBytes > 0 {
+		return opts.MaxHeaderBytes
+	}
+	return defaultMaxHeaderListSize
+}
+
+// newRequest validates the decoded fields of a request header block and
+// turns them into a Request.
+func (sc *serverConn) newRequest(fields []HeaderField) (*request.Request, error) {
+	req := &request.Request{
+		RequestLine: request.RequestLine{HttpVersion: "2"},
+		Headers:     headers.NewHeaders(),
+		Body:        []byte{},
+		TLS:         sc.opts.TLS,
+		RemoteAddr:  sc.conn.RemoteAddr().String(),
+	}
+
+	var scheme, authority string
+	cookies := []string{}
+	// Repeated fields are joined once at the end; merging them one at a
+	// time copies the growing value for every field.
+	values := map[string][]string{}
+	regularSeen := false
+
+	for _, field := range fields {
+		if strings.HasPrefix(field.Name, ":") {
+			if regularSeen {
+				return nil, errors.New("pseudo-header after regular header")
+			}
+			var target *string
+			switch field.Name {
+			case ":method":
+				target = &req.RequestLine.Method
+			case ":path":
+				target = &req.RequestLine.RequestTarget
+			case ":scheme":
+				target = &scheme
+			case ":authority":
+				target = &authority
+			default:
+				return nil, fmt.Errorf("unknown pseudo-header %s", field.Name)
+			}
+			if *target != "" {
+				return nil, fmt.Errorf("duplicate pseudo-header %s", field.Name)
+			}
+			*target = field.Value
+			continue
+		}
+
+		regularSeen = true
+
+		if field.Name != strings.ToLower(field.Name) {
+			return nil, fmt.Errorf("uppercase header name %s", field.Name)
+		}
+
+		switch field.Name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade":
+			return nil, fmt.Errorf("connection-specific header %s", field.Name)
+		case "te":
+			if field.Value != "trailers" {
+				return nil, errors.New("te header other than trailers")
+			}
+		case "cookie":
+			cookies = append(cookies, field.Value)
+			continue
+		}
+
+		values[field.Name] = append(values[field.Name], field.Value)
+	}
+
+	for name, v := range values {
+		req.Headers.Set(name, strings.Join(v, ", "))
+	}
+
+	if req.RequestLine.Method == "" || req.RequestLine.RequestTarget == "" || scheme == "" {
+		return nil, errors.New("missing required pseudo-header")
+	}
+
+	if len(cookies) > 0 {
+		req.Headers.Overwrite("cookie", strings.Join(cookies, "; "))
+	}
+
+	if _, ok := req.Headers.Get("host"); !ok && authority != "" {
+		req.Headers.Set("host", authority)
+	}
+
+	return req, nil
+}
+
+func (sc *serverConn) startUpgradedStream() error {
+	settings, err := parseSettings(sc.opts.UpgradeSettings)
+
+	if err != nil {
+		return err
+	}
+
+	if err := sc.applySettings(settings); err != nil {
+		return err
+	}
+
+	req := sc.opts.Upgrade
+	req.TLS = sc.opts.TLS
+
+	sc.mu.Lock()
+	sc.lastStreamID = 1
+	sc.mu.Unlock()
+
+	st := sc.openStream(1, req)
+	st.body = req.Body
+
+	return sc.endRequestBody(st)
+}
+
+func (sc *serverConn) openStream(id uint32, req *request.Request) *stream {
+	ctx, cancel := context.WithCancel(sc.ctx)
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	st := &stream{
+		id:         id,
+		sc:         sc,
+		req:        req,
+		ctx:        ctx,
+		cancel:     cancel,
+		sendWindow: sc.peerInitialWindow,
+	}
+	sc.streams[id] = st
+
+	if sc.idleTimer != nil {
+		sc.idleTimer.Stop()
+	}
+
+	return st
+}
+
+// closeStream forgets a stream, cancels its context and wakes any writer
+// blocked on flow control. Once the last stream of a connection that is
+// going away closes, so does the connection.
+func (sc *serverConn) closeStream(st *stream) {
+	sc.mu.Lock()
+	if _, exists := sc.streams[st.id]; !exists {
+		sc.mu.Unlock()
+		return
+	}
+	delete(sc.streams, st.id)
+	st.closed = true
+	idle := len(sc.streams) == 0
+	goingAway := sc.goingAway
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	st.cancel()
+
+	if idle && goingAway {
+		sc.conn.Close()
+	} else if idle {
+		sc.resetIdleTimer()
+	}
+}
+
+func (sc *serverConn) endRequestBody(st *stream) error {
+	st.remoteClosed = true
+	st.req.Body = st.body
+	if st.req.Body == nil {
+		st.req.Body = []byte{}
+	}
+
+	if err := st.checkContentLength(); err != nil {
+		return streamError{st.id, ErrCodeProtocol, err.Error()}
+	}
+
+	if declared, ok := st.req.Headers.Get("content-length"); ok {
+		if n, _ := strconv.Atoi(declared); n != len(st.req.Body) {
+			return streamError{st.id, ErrCodeProtocol, "body length does not match content-length"}
+		}
+	}
+
+	sc.handlers.Add(1)
+	go st.run()
+
+	return nil
+}
+
+// respondError answers a request without running the handler. Any body
+// the client is still sending is discarded.
+func (sc *serverConn) respondError(st *stream, statusCode response.StatusCode, message string) {
+	st.remoteClosed = true
+	st.discardBody = true
+
+	sc.handlers.Add(1)
+	go func() {
+		defer sc.handlers.Done()
+		defer st.finish()
+		w := response.NewTransportWriter(st)
+		w.WriteStatusLine(statusCode)
+		w.WriteHeaders(response.GetDefaultHeaders(len(message)))
+		w.WriteBody(message)
+	}()
+}
+
+func (sc *serverConn) resetStream(id uint32, code ErrorCode) {
+	sc.writeFrame(FrameRSTStream, 0, id, binary.BigEndian.AppendUint32(nil, uint32(code)))
+
+	sc.mu.Lock()
+	st, exists := sc.streams[id]
+	sc.mu.Unlock()
+
+	if exists {
+		sc.closeStream(st)
+	}
+}
+
+func (sc *serverConn) sendWindowUpdate(id uint32, increment uint32) {
+	sc.writeFrame(FrameWindowUpdate, 0, id, binary.BigEndian.AppendUint32(nil, increment))
+}
+
+// goAway tells the client no new streams will be accepted past the last one
+// seen. With an error code the connection is finished, otherwise existing
+// streams are allowed to complete.
+func (sc *serverConn) goAway(code ErrorCode) {
+	sc.mu.Lock()
+	alreadyGoingAway := sc.goingAway
+	sc.goingAway = true
+	lastStreamID := sc.lastStreamID
+	idle := len(sc.streams) == 0
+	sc.mu.Unlock()
+
+	if !alreadyGoingAway || code != ErrCodeNo {
+		payload := binary.BigEndian.AppendUint32(nil, lastStreamID)
+		payload = binary.BigEndian.AppendUint32(payload, uint32(code))
+		sc.writeFrame(FrameGoAway, 0, 0, payload)
+	}
+
+	if code != ErrCodeNo || idle {
+		sc.conn.Close()
+	}
+}
+
+func (sc *serverConn) watchShutdown() {
+	select {
+	case <-sc.opts.Shutdown:
+		sc.goAway(ErrCodeNo)
+	case <-sc.ctx.Done():
+	}
+}
+
+func (sc *serverConn) resetIdleTimer() {
+	if sc.opts.IdleTimeout <= 0 {
+		return
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.idleTimer == nil {
+		sc.idleTimer = time.AfterFunc(sc.opts.IdleTimeout, func() {
+			sc.mu.Lock()
+			idle := len(sc.streams) == 0
+			sc.mu.Unlock()
+			if idle {
+				sc.goAway(ErrCodeNo)
+			}
+		})
+		return
+	}
+
+	sc.idleTimer.Reset(sc.opts.IdleTimeout)
+}
+
+// shutdownStreams runs once the read loop has stopped. Every stream is
+// cancelled and blocked writers are released so handlers can return.
+func (sc *serverConn) shutdownStreams() {
+	sc.conn.Close()
+
+	sc.mu.Lock()
+	sc.closed = true
+	streams := make([]*stream, 0, len(sc.streams))
+	for _, st := range sc.streams {
+		streams = append(streams, st)
+	}
+	if sc.idleTimer != nil {
+		sc.idleTimer.Stop()
+	}
+	sc.cond.Broadcast()
+	sc.mu.Unlock()
+
+	for _, st := range streams {
+		st.cancel()
+	}
+}
+
+func (sc *serverConn) isClosed() bool {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.closed || sc.goingAway
+}
+
+func (sc *serverConn) writeFrame(frameType FrameType, flags uint8, streamID uint32, payload []byte) error {
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	if err := WriteFrame(sc.bw, frameType, flags, streamID, payload); err != nil {
+		return err
+	}
+
+	return sc.bw.Flush()
+}
+
+// writeHeaderBlock compresses fields and writes them as a HEADERS frame
+// followed by as many CONTINUATION frames as the peer's frame size needs.
+func (sc *serverConn) writeHeaderBlock(streamID uint32, fields []HeaderField, endStream bool) error {
+	sc.mu.Lock()
+	maxFrameSize := int(sc.peerMaxFrameSize)
+	sc.mu.Unlock()
+
+	sc.writeMu.Lock()
+	defer sc.writeMu.Unlock()
+
+	block := sc.encoder.Encode(fields)
+	frameType := FrameHeaders
+	flags := uint8(0)
+
+	if endStream {
+		flags |= FlagEndStream
+	}
+
+	for {
+		chunk := block
+		if len(chunk) > maxFrameSize {
+			chunk = chunk[:maxFrameSize]
+		}
+		block = block[len(chunk):]
+
+		if len(block) == 0 {
+			flags |= FlagEndHeaders
+		}
+
+		if err := WriteFrame(sc.bw, frameType, flags, streamID, chunk); err != nil {
+			return err
+		}
+
+		if len(block) == 0 {
+			break
+		}
+
+		frameType = FrameContinuation
+		flags = 0
+	}
+
+	return sc.bw.Flush()
+}
@@ -0,0 +1,206 @@
+package http2
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+)
+
+// stream is one request/response exchange. It implements response.Transport
+// so handlers write to it through an ordinary response.Writer.
+type stream struct {
+	id     uint32
+	sc     *serverConn
+	req    *request.Request
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Only touched by the goroutine reading frames.
+	body         []byte
+	remoteClosed bool
+	discardBody  bool
+
+	// Only touched by the handler goroutine.
+	headersSent bool
+	ended       bool
+	// noBody is set for responses that cannot carry a body: answers to HEAD,
+	// 204 and 304. Body bytes written to them are dropped.
+	noBody bool
+
+	// Guarded by sc.mu.
+	sendWindow int64
+	closed     bool
+}
+
+func (st *stream) run() {
+	defer st.sc.handlers.Done()
+	defer st.finish()
+
+	w := response.NewTransportWriter(st)
+	st.sc.handler(w, st.req.WithContext(st.ctx))
+}
+
+// finish ends the stream once the handler returns, sending whatever the
+// handler left unsent.
+func (st *stream) finish() {
+	if !st.ended && !st.isClosed() {
+		if !st.headersSent {
+			st.WriteHeaders(response.OKStatus, headers.NewHeaders())
+		}
+		if !st.ended {
+			st.ended = true
+			st.sc.writeFrame(FrameData, FlagEndStream, st.id, nil)
+		}
+	}
+
+	st.sc.closeStream(st)
+}
+
+func (st *stream) isClosed() bool {
+	st.sc.mu.Lock()
+	defer st.sc.mu.Unlock()
+	return st.closed
+}
+
+// checkContentLength rejects declared bodies over MaxBodyBytes before any
+// data arrives.
+func (st *stream) checkContentLength() error {
+	declared, ok := st.req.Headers.Get("content-length")
+
+	if !ok {
+		return nil
+	}
+
+	n, err := strconv.Atoi(declared)
+
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid content-length %q", declared)
+	}
+
+	if n > st.sc.opts.MaxBodyBytes {
+		return request.ErrBodyTooLarge
+	}
+
+	return nil
+}
+
+func (st *stream) WriteHeaders(statusCode response.StatusCode, h headers.Headers) error {
+	if st.headersSent {
+		return errors.New("http2: headers already written")
+	}
+
+	if statusCode == 0 {
+		statusCode = response.OKStatus
+	}
+
+	fields := []HeaderField{{Name: ":status", Value: strconv.Itoa(int(statusCode))}}
+	fields = append(fields, responseFields(h)...)
+
+	st.headersSent = true
+	st.noBody = st.req.RequestLine.Method == "HEAD" ||
+		statusCode == response.NoContentStatus || statusCode == response.NotModifiedStatus
+
+	return st.sc.writeHeaderBlock(st.id, fields, false)
+}
+
+func (st *stream) WriteData(p []byte) (int, error) {
+	if st.ended {
+		return 0, errStreamClosed
+	}
+
+	if !st.headersSent {
+		if err := st.WriteHeaders(response.OKStatus, headers.NewHeaders()); err != nil {
+			return 0, err
+		}
+	}
+
+	if st.noBody {
+		return len(p), nil
+	}
+
+	sc := st.sc
+	written := 0
+
+	for len(p) > 0 {
+		sc.mu.Lock()
+		for !st.closed && !sc.closed && (sc.sendWindow <= 0 || st.sendWindow <= 0) {
+			sc.cond.Wait()
+		}
+
+		if st.closed || sc.closed {
+			sc.mu.Unlock()
+			return written, errStreamClosed
+		}
+
+		n := int64(len(p))
+		n = min(n, int64(sc.peerMaxFrameSize), sc.sendWindow, st.sendWindow)
+		sc.sendWindow -= n
+		st.sendWindow -= n
+		sc.mu.Unlock()
+
+		if err := sc.writeFrame(FrameData, 0, st.id, p[:n]); err != nil {
+			return written, err
+		}
+
+		written += int(n)
+		p = p[n:]
+	}
+
+	return written, nil
+}
+
+func (st *stream) WriteTrailers(h headers.Headers) error {
+	if st.ended || st.isClosed() {
+		return errStreamClosed
+	}
+
+	if !st.headersSent {
+		if err := st.WriteHeaders(response.OKStatus, headers.NewHeaders()); err != nil {
+			return err
+		}
+	}
+
+	st.ended = true
+
+	return st.sc.writeHeaderBlock(st.id, responseFields(h), true)
+}
+
+// Abort resets the stream so the client knows the response is incomplete.
+func (st *stream) Abort() {
+	if st.ended {
+		return
+	}
+
+	st.ended = true
+	st.sc.resetStream(st.id, ErrCodeInternal)
+}
+
+// responseFields converts response headers, dropping the ones HTTP/2 forbids.
+func responseFields(h headers.Headers) []HeaderField {
+	fields := make([]HeaderField, 0, len(h))
+
+	for key := range h {
+		name := strings.ToLower(key)
+
+		switch name {
+		case "connection", "keep-alive", "proxy-connection", "transfer-encoding", "upgrade", "trailer":
+			continue
+		}
+
+		for _, value := range h.Values(key) {
+			fields = append(fields, HeaderField{
+				Name:      name,
+				Value:     value,
+				Sensitive: name == "authorization" || name == "set-cookie",
+			})
+		}
+	}
+
+	return fields
+}
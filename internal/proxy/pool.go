@@ -0,0 +1,281 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+)
+
+// DefaultEjectDuration is how long a backend sits out after MaxFailures
+// consecutive failures when PoolConfig.EjectDuration is not set.
+const DefaultEjectDuration = 30 * time.Second
+
+// Upstream describes one backend of a Pool.
+type Upstream struct {
+	URL *url.URL
+	// Weight is the backend's share of traffic relative to the others, for
+	// the Weighted and ConsistentHash strategies. Defaults to one.
+	Weight int
+}
+
+// HealthCheck configures active health checking. Each backend is sent a
+// GET for Path every Interval; any status below 400 counts as healthy.
+type HealthCheck struct {
+	Path     string
+	Interval time.Duration
+	// Timeout defaults to Interval.
+	Timeout time.Duration
+}
+
+type PoolConfig struct {
+	Upstreams []Upstream
+	// Strategy defaults to RoundRobin.
+	Strategy Strategy
+	// HealthCheck is disabled when its Path is empty.
+	HealthCheck HealthCheck
+	// MaxFailures is how many requests in a row may fail, by not connecting
+	// or with a 502, 503 or 504, before a backend is ejected for
+	// EjectDuration. Zero disables passive ejection.
+	MaxFailures   int
+	EjectDuration time.Duration
+	// Transport, used for health checks, defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+	// Logger defaults to log.Default().
+	Logger *log.Logger
+}
+
+// Backend is one member of a Pool, as seen by a Strategy.
+type Backend struct {
+	url    *url.URL
+	weight int
+	active atomic.Int64
+
+	mu           sync.Mutex
+	unhealthy    bool
+	failures     int
+	ejectedUntil time.Time
+}
+
+func (b *Backend) URL() *url.URL {
+	return b.url
+}
+
+func (b *Backend) Weight() int {
+	return b.weight
+}
+
+// Active reports how many requests the backend is serving.
+func (b *Backend) Active() int {
+	return int(b.active.Load())
+}
+
+// Available reports whether the backend is passing health checks and not
+// ejected.
+func (b *Backend) Available() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.available(time.Now())
+}
+
+func (b *Backend) available(now time.Time) bool {
+	return !b.unhealthy && !now.Before(b.ejectedUntil)
+}
+
+// Pool spreads requests over a set of backends, leaving out the ones that
+// fail their health checks or have been ejected for failing requests.
+type Pool struct {
+	config   PoolConfig
+	backends []*Backend
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewPool builds a pool and starts its health checks. Call Close to stop
+// them.
+func NewPool(config PoolConfig) (*Pool, error) {
+	if len(config.Upstreams) == 0 {
+		return nil, errors.New("proxy: a pool needs at least one upstream")
+	}
+
+	if config.Strategy == nil {
+		config.Strategy = RoundRobin()
+	}
+
+	if config.EjectDuration <= 0 {
+		config.EjectDuration = DefaultEjectDuration
+	}
+
+	if config.Transport == nil {
+		config.Transport = http.DefaultTransport
+	}
+
+	if config.Logger == nil {
+		config.Logger = log.Default()
+	}
+
+	if config.HealthCheck.Path != "" && config.HealthCheck.Interval <= 0 {
+		return nil, errors.New("proxy: health checks need an interval")
+	}
+
+	pool := &Pool{config: config, stop: make(chan struct{})}
+
+	for _, upstream := range config.Upstreams {
+		if err := checkTarget(upstream.URL); err != nil {
+			return nil, err
+		}
+
+		weight := upstream.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+
+		pool.backends = append(pool.backends, &Backend{url: upstream.URL, weight: weight})
+	}
+
+	if config.HealthCheck.Path != "" {
+		for _, backend := range pool.backends {
+			pool.wg.Add(1)
+			go pool.healthCheck(backend)
+		}
+	}
+
+	return pool, nil
+}
+
+func checkTarget(target *url.URL) error {
+	if target == nil || target.Host == "" {
+		return errors.New("proxy: target must be an absolute URL")
+	}
+
+	if target.Scheme != "http" && target.Scheme != "https" {
+		return fmt.Errorf("proxy: unsupported target scheme %q", target.Scheme)
+	}
+
+	return nil
+}
+
+// Close stops the health checks.
+func (p *Pool) Close() {
+	select {
+	case <-p.stop:
+	default:
+		close(p.stop)
+	}
+	p.wg.Wait()
+}
+
+func (p *Pool) Backends() []*Backend {
+	return slices.Clone(p.backends)
+}
+
+// next picks a backend for req from the available ones that have not been
+// tried yet, or returns nil if there are none.
+func (p *Pool) next(req *request.Request, tried []*Backend) *Backend {
+	now := time.Now()
+	candidates := make([]*Backend, 0, len(p.backends))
+
+	for _, backend := range p.backends {
+		if slices.Contains(tried, backend) {
+			continue
+		}
+
+		backend.mu.Lock()
+		available := backend.available(now)
+		backend.mu.Unlock()
+
+		if available {
+			candidates = append(candidates, backend)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	return p.config.Strategy.Pick(candidates, req)
+}
+
+// observe records the outcome of a request for passive ejection.
+func (p *Pool) observe(backend *Backend, ok bool) {
+	backend.mu.Lock()
+	defer backend.mu.Unlock()
+
+	if ok {
+		backend.failures = 0
+		return
+	}
+
+	backend.failures++
+
+	if p.config.MaxFailures > 0 && backend.failures >= p.config.MaxFailures {
+		backend.failures = 0
+		backend.ejectedUntil = time.Now().Add(p.config.EjectDuration)
+		p.config.Logger.Printf("proxy: ejected %s for %v after %d failures\n", backend.url, p.config.EjectDuration, p.config.MaxFailures)
+	}
+}
+
+func (p *Pool) healthCheck(backend *Backend) {
+	defer p.wg.Done()
+
+	ticker := time.NewTicker(p.config.HealthCheck.Interval)
+	defer ticker.Stop()
+
+	for {
+		p.check(backend)
+
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pool) check(backend *Backend) {
+	timeout := p.config.HealthCheck.Timeout
+	if timeout <= 0 {
+		timeout = p.config.HealthCheck.Interval
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	target := *backend.url
+	target.Path = strings.TrimSuffix(target.Path, "/") + "/" + strings.TrimPrefix(p.config.HealthCheck.Path, "/")
+	target.RawPath = ""
+
+	healthy := false
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target.String(), nil)
+
+	if err == nil {
+		resp, err := p.config.Transport.RoundTrip(req)
+		if err == nil {
+			resp.Body.Close()
+			healthy = resp.StatusCode < 400
+		}
+	}
+
+	backend.mu.Lock()
+	changed := backend.unhealthy == healthy
+	backend.unhealthy = !healthy
+	backend.mu.Unlock()
+
+	if changed {
+		state := "healthy"
+		if !healthy {
+			state = "unhealthy"
+		}
+		p.config.Logger.Printf("proxy: %s is %s\n", backend.url, state)
+	}
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testBackends(weights ...int) []*Backend {
+	backends := make([]*Backend, len(weights))
+	for i, weight := range weights {
+		backends[i] = &Backend{url: &url.URL{Scheme: "http", Host: fmt.Sprintf("backend-%d", i)}, weight: weight}
+	}
+	return backends
+}
+
+func pickNames(s Strategy, backends []*Backend, n int) string {
+	var names []string
+	for range n {
+		names = append(names, strings.TrimPrefix(s.Pick(backends, nil).url.Host, "backend-"))
+	}
+	return strings.Join(names, "")
+}
+
+func TestStrategies(t *testing.T) {
+	backends := testBackends(1, 1, 1)
+
+	// Test: Round robin takes turns
+	assert.Equal(t, "012012", pickNames(RoundRobin(), backends, 6))
+
+	// Test: Least connections picks the least loaded backend
+	backends[0].active.Store(3)
+	backends[1].active.Store(1)
+	backends[2].active.Store(2)
+	assert.Equal(t, "111", pickNames(LeastConnections(), backends, 3))
+
+	// Test: Least connections weighs load against capacity
+	weightedBackends := testBackends(1, 4)
+	weightedBackends[0].active.Store(1)
+	weightedBackends[1].active.Store(3)
+	assert.Equal(t, "1", pickNames(LeastConnections(), weightedBackends, 1))
+
+	// Test: Weighted spreads requests by weight, interleaved
+	assert.Equal(t, "10102101", pickNames(Weighted(), testBackends(3, 4, 1), 8))
+
+	// Test: Weighted forgets backends that are no longer candidates
+	spread := Weighted()
+	three := testBackends(3, 4, 1)
+	pickNames(spread, three, 5)
+	pickNames(spread, three[1:], 1)
+	assert.NotContains(t, spread.(*weighted).current, three[0])
+	assert.Len(t, spread.(*weighted).current, 2)
+
+	// Test: Consistent hashing keeps a key on one backend
+	byUser := ConsistentHash(func(req *request.Request) string {
+		user, _ := req.Headers.Get("x-user")
+		return user
+	})
+	reqFor := func(user string) *request.Request {
+		req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\nX-User: " + user + "\r\n\r\n"))
+		require.NoError(t, err)
+		return req
+	}
+	many := testBackends(1, 1, 1, 1)
+	owners := map[string]*Backend{}
+	for i := range 200 {
+		user := fmt.Sprint("user-", i)
+		owners[user] = byUser.Pick(many, reqFor(user))
+		assert.Same(t, owners[user], byUser.Pick(many, reqFor(user)))
+	}
+
+	// Test: Only the keys of a removed backend move
+	remaining := many[1:]
+	for user, owner := range owners {
+		if owner != many[0] {
+			assert.Same(t, owner, byUser.Pick(remaining, reqFor(user)), user)
+		}
+	}
+
+	// Test: Keys are shared evenly between backends of equal weight
+	similar := make([]*Backend, 3)
+	for i := range similar {
+		similar[i] = &Backend{url: &url.URL{Scheme: "http", Host: fmt.Sprintf("10.0.0.%d:8080", i+1)}, weight: 1}
+	}
+	counts := map[*Backend]int{}
+	for i := range 30000 {
+		counts[byUser.Pick(similar, reqFor(fmt.Sprint("user", i)))]++
+	}
+	for _, b := range similar {
+		assert.InDelta(t, 10000, counts[b], 500, b.url.Host)
+	}
+}
+
+// countingUpstream answers with its name and counts the requests it gets.
+func countingUpstream(t *testing.T, name string, healthy *atomic.Bool) (*httptest.Server, *atomic.Int32) {
+	var count atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" {
+			if healthy != nil && !healthy.Load() {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+			return
+		}
+		count.Add(1)
+		io.WriteString(w, name)
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, &count
+}
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	u, err := url.Parse(raw)
+	require.NoError(t, err)
+	return u
+}
+
+func TestPoolRetriesAndEjection(t *testing.T) {
+	a, aCount := countingUpstream(t, "a", nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead := "http://" + listener.Addr().String()
+	listener.Close()
+
+	pool, err := NewPool(PoolConfig{
+		Upstreams:     []Upstream{{URL: mustParse(t, dead)}, {URL: mustParse(t, a.URL)}},
+		MaxFailures:   2,
+		EjectDuration: time.Hour,
+		Logger:        log.New(io.Discard, "", 0),
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+	addr := startProxy(t, "", Config{Pool: pool, Retries: 1, Logger: log.New(io.Discard, "", 0)})
+
+	// Test: Idempotent requests are retried on another backend
+	for range 2 {
+		resp := send(t, addr, "GET /one HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		assert.True(t, strings.HasSuffix(resp, "\r\n\r\na"), resp)
+	}
+
+	// Test: A backend is ejected after consecutive failures
+	assert.False(t, pool.Backends()[0].Available())
+	assert.True(t, pool.Backends()[1].Available())
+	for range 3 {
+		assert.True(t, strings.HasSuffix(send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"), "\r\n\r\na"))
+	}
+	assert.Equal(t, int32(5), aCount.Load())
+	assert.Equal(t, 0, pool.Backends()[1].Active())
+}
+
+func TestPoolNoRetryForPost(t *testing.T) {
+	a, aCount := countingUpstream(t, "a", nil)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	dead := "http://" + listener.Addr().String()
+	listener.Close()
+
+	pool, err := NewPool(PoolConfig{Upstreams: []Upstream{{URL: mustParse(t, dead)}, {URL: mustParse(t, a.URL)}}})
+	require.NoError(t, err)
+	defer pool.Close()
+	addr := startProxy(t, "", Config{Pool: pool, Retries: 1, Logger: log.New(io.Discard, "", 0)})
+
+	// Test: A POST that could not be delivered is not sent again
+	resp := send(t, addr, "POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 2\r\n\r\nhi")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n"))
+	assert.Equal(t, int32(0), aCount.Load())
+}
+
+func TestPoolHealthChecks(t *testing.T) {
+	var bHealthy atomic.Bool
+	a, _ := countingUpstream(t, "a", nil)
+	b, _ := countingUpstream(t, "b", &bHealthy)
+
+	pool, err := NewPool(PoolConfig{
+		Upstreams:   []Upstream{{URL: mustParse(t, a.URL)}, {URL: mustParse(t, b.URL)}},
+		HealthCheck: HealthCheck{Path: "/health", Interval: 10 * time.Millisecond},
+		Logger:      log.New(io.Discard, "", 0),
+	})
+	require.NoError(t, err)
+	defer pool.Close()
+	addr := startProxy(t, "", Config{Pool: pool})
+
+	// Test: A backend failing its health check gets no traffic
+	assert.Eventually(t, func() bool { return !pool.Backends()[1].Available() }, time.Second, 5*time.Millisecond)
+	for range 4 {
+		assert.True(t, strings.HasSuffix(send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"), "\r\n\r\na"))
+	}
+
+	// Test: It returns once it passes again
+	bHealthy.Store(true)
+	assert.Eventually(t, func() bool { return pool.Backends()[1].Available() }, time.Second, 5*time.Millisecond)
+	seen := map[string]bool{}
+	for range 4 {
+		resp := send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+		seen[resp[len(resp)-1:]] = true
+	}
+	assert.Equal(t, map[string]bool{"a": true, "b": true}, seen)
+
+	// Test: With every backend down the proxy answers 503
+	a.Close()
+	bHealthy.Store(false)
+	assert.Eventually(t, func() bool {
+		return !pool.Backends()[0].Available() && !pool.Backends()[1].Available()
+	}, time.Second, 5*time.Millisecond)
+	resp := send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"))
+}
@@ -0,0 +1,439 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+)
+
+// hopHeaders describe a single connection rather than the message, so a
+// proxy must not pass them on. Expect is included because it is for this
+// server to answer, not the upstream.
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Connection",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Expect",
+}
+
+const copyBufferSize = 32 * 1024
+
+type Config struct {
+	// Target is the upstream requests are sent to. Its path, if any, is
+	// put in front of the request path.
+	Target *url.URL
+	// Pool spreads requests over several upstreams instead of Target.
+	Pool *Pool
+	// Retries is how many other backends in Pool an idempotent request is
+	// tried on when a backend cannot be reached. Zero disables retries.
+	Retries int
+	// StripPrefix is removed from the request path before it is forwarded,
+	// so a proxy mounted at "/api/" can serve the root of its upstream. It
+	// only matches whole path segments.
+	StripPrefix string
+	// PreserveHost forwards the client's Host header instead of the
+	// target's.
+	PreserveHost bool
+	// TrustForwarded keeps the Forwarded and X-Forwarded-* headers the
+	// client sent and appends to them, for a proxy behind another trusted
+	// proxy. By default they are replaced, so clients cannot spoof them.
+	TrustForwarded bool
+	// Transport defaults to http.DefaultTransport.
+	Transport http.RoundTripper
+	// Logger defaults to log.Default().
+	Logger *log.Logger
+}
+
+// Proxy forwards requests to an upstream server, or one picked from a
+// Pool. Request bodies the server streams (see server.WithStreamBody) are
+// passed upstream as they arrive; others have already been buffered by the
+// server and are sent from memory. The response is streamed back as it
+// arrives, with the upstream status, headers and trailers.
+type Proxy struct {
+	config Config
+}
+
+func New(config Config) (*Proxy, error) {
+	if config.Pool == nil {
+		if err := checkTarget(config.Target); err != nil {
+			return nil, err
+		}
+
+		pool, err := NewPool(PoolConfig{Upstreams: []Upstream{{URL: config.Target}}})
+
+		if err != nil {
+			return nil, err
+		}
+
+		config.Pool = pool
+	}
+
+	if config.Transport == nil {
+		config.Transport = http.DefaultTransport
+	}
+
+	if config.Logger == nil {
+		config.Logger = log.Default()
+	}
+
+	return &Proxy{config: config}, nil
+}
+
+func (p *Proxy) Handler() server.Handler {
+	return p.serve
+}
+
+func (p *Proxy) serve(w *response.Writer, req *request.Request) {
+	target, err := url.ParseRequestURI(req.RequestLine.RequestTarget)
+
+	if err != nil || !strings.HasPrefix(target.EscapedPath(), "/") {
+		writeError(w, response.BadRequestStatus, fmt.Sprintf("cannot proxy request target %q", req.RequestLine.RequestTarget))
+		return
+	}
+
+	// A streamed body is gone once it has been sent, so it cannot be sent
+	// to another backend.
+	attempts := 1
+	if idempotent(req.RequestLine.Method) && !req.BodyStreamed() {
+		attempts += p.config.Retries
+	}
+
+	var tried []*Backend
+	var lastErr error
+
+	for len(tried) < attempts {
+		backend := p.config.Pool.next(req, tried)
+
+		if backend == nil {
+			break
+		}
+
+		tried = append(tried, backend)
+		out, err := p.outgoing(req, backend.url, target)
+
+		if err != nil {
+			writeError(w, response.BadRequestStatus, err.Error())
+			return
+		}
+
+		backend.active.Add(1)
+		resp, err := p.config.Transport.RoundTrip(out)
+
+		if err != nil {
+			backend.active.Add(-1)
+			p.config.Pool.observe(backend, false)
+			p.config.Logger.Printf("proxy: %s %s: %v\n", out.Method, out.URL, err)
+			lastErr = err
+			if req.Context().Err() != nil {
+				break
+			}
+			continue
+		}
+
+		p.config.Pool.observe(backend, !gatewayFailure(resp.StatusCode))
+		p.copyResponse(w, resp)
+		resp.Body.Close()
+		backend.active.Add(-1)
+		return
+	}
+
+	switch {
+	case lastErr == nil:
+		writeError(w, response.ServiceUnavailableStatus, "no upstream server is available")
+	case errors.Is(lastErr, context.DeadlineExceeded):
+		writeError(w, response.GatewayTimeoutStatus, "the upstream server timed out")
+	default:
+		writeError(w, response.BadGatewayStatus, "the upstream server is unavailable")
+	}
+}
+
+// outgoing builds the request for base that forwards req, whose request
+// target has been parsed as target.
+func (p *Proxy) outgoing(req *request.Request, base *url.URL, target *url.URL) (*http.Request, error) {
+	path := stripPrefix(target.EscapedPath(), p.config.StripPrefix)
+
+	outURL := *base
+	joined, err := url.Parse(strings.TrimSuffix(outURL.EscapedPath(), "/") + path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	outURL.Path, outURL.RawPath = joined.Path, joined.RawPath
+	outURL.RawQuery = joinQuery(outURL.RawQuery, target.RawQuery)
+
+	contentLength := int64(len(req.Body))
+	if req.BodyStreamed() {
+		declared, _ := req.Headers.Get("content-length")
+		contentLength, _ = strconv.ParseInt(declared, 10, 64)
+	}
+
+	var body io.Reader = http.NoBody
+	if contentLength > 0 {
+		body = req.BodyReader()
+	}
+
+	out, err := http.NewRequestWithContext(req.Context(), req.RequestLine.Method, outURL.String(), body)
+
+	if err != nil {
+		return nil, err
+	}
+
+	out.ContentLength = contentLength
+
+	host, _ := req.Headers.Get("host")
+
+	for key, value := range req.Headers {
+		out.Header[http.CanonicalHeaderKey(key)] = []string{value}
+	}
+
+	te := out.Header.Get("Te")
+	removeHopHeaders(out.Header)
+	out.Header.Del("Host")
+	out.Header.Del("Content-Length")
+
+	if slices.Contains(splitList(te), "trailers") {
+		out.Header.Set("Te", "trailers")
+	}
+
+	if p.config.PreserveHost && host != "" {
+		out.Host = host
+	}
+
+	p.setForwarded(out.Header, req, host)
+
+	return out, nil
+}
+
+// setForwarded records the client, the host it asked for and the scheme
+// it used, both in RFC 7239 Forwarded and in the X-Forwarded-* headers
+// most applications still read.
+func (p *Proxy) setForwarded(h http.Header, req *request.Request, host string) {
+	if !p.config.TrustForwarded {
+		h.Del("Forwarded")
+		h.Del("X-Forwarded-For")
+		h.Del("X-Forwarded-Host")
+		h.Del("X-Forwarded-Proto")
+	}
+
+	clientIP, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		clientIP = req.RemoteAddr
+	}
+
+	proto := "http"
+	if req.TLS != nil {
+		proto = "https"
+	}
+
+	if clientIP != "" {
+		h.Set("X-Forwarded-For", appendList(h.Get("X-Forwarded-For"), clientIP))
+	}
+
+	if h.Get("X-Forwarded-Host") == "" && host != "" {
+		h.Set("X-Forwarded-Host", host)
+	}
+
+	if h.Get("X-Forwarded-Proto") == "" {
+		h.Set("X-Forwarded-Proto", proto)
+	}
+
+	element := "for=" + forwardedNode(clientIP)
+	if host != "" {
+		element += ";host=" + forwardedValue(host)
+	}
+	element += ";proto=" + proto
+
+	h.Set("Forwarded", appendList(h.Get("Forwarded"), element))
+}
+
+// copyResponse passes the upstream response on, flushing as the body
+// arrives so streamed responses such as server-sent events are not held
+// back. A body that fails part way is aborted rather than ended cleanly.
+func (p *Proxy) copyResponse(w *response.Writer, resp *http.Response) {
+	removeHopHeaders(resp.Header)
+
+	h := headers.NewHeaders()
+	for key, values := range resp.Header {
+		for _, value := range values {
+			h.Set(key, value)
+		}
+	}
+
+	if len(resp.Trailer) > 0 {
+		names := make([]string, 0, len(resp.Trailer))
+		for name := range resp.Trailer {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		h.Delete("content-length")
+		h.Set("trailer", strings.Join(names, ", "))
+	}
+
+	w.WriteStatusLine(response.StatusCode(resp.StatusCode))
+
+	if err := w.WriteHeaders(h); err != nil {
+		return
+	}
+
+	buf := make([]byte, copyBufferSize)
+
+	for {
+		n, err := resp.Body.Read(buf)
+
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+
+			if err := w.Flush(); err != nil {
+				return
+			}
+		}
+
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			p.config.Logger.Printf("proxy: reading the response from %s: %v\n", resp.Request.URL, err)
+			w.Abort()
+			return
+		}
+	}
+
+	if len(resp.Trailer) == 0 {
+		return
+	}
+
+	trailers := headers.NewHeaders()
+	for key, values := range resp.Trailer {
+		for _, value := range values {
+			trailers.Set(key, value)
+		}
+	}
+
+	w.WriteTrailers(trailers)
+}
+
+// idempotent reports whether a request may be sent again without changing
+// its effect, which makes it safe to retry on another backend.
+func idempotent(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	default:
+		return false
+	}
+}
+
+// gatewayFailure reports whether status says the upstream itself is in
+// trouble, as opposed to the request being bad.
+func gatewayFailure(status int) bool {
+	return status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// stripPrefix removes prefix from path when it matches whole path segments,
+// so "/api/" strips "/api" and "/api/x" but leaves "/apiary/x" alone.
+func stripPrefix(path string, prefix string) string {
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix == "" {
+		return path
+	}
+
+	rest, ok := strings.CutPrefix(path, prefix)
+	if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+		return path
+	}
+
+	if rest == "" {
+		return "/"
+	}
+
+	return rest
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, name := range splitList(h.Get("Connection")) {
+		h.Del(name)
+	}
+
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+func writeError(w *response.Writer, status response.StatusCode, message string) {
+	w.WriteStatusLine(status)
+	w.WriteHeaders(response.GetDefaultHeaders(len(message)))
+	w.WriteBody(message)
+}
+
+// splitList splits a comma separated header value into lower case items.
+func splitList(value string) []string {
+	var items []string
+
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, strings.ToLower(item))
+		}
+	}
+
+	return items
+}
+
+func appendList(list string, item string) string {
+	if list == "" {
+		return item
+	}
+	return list + ", " + item
+}
+
+func joinQuery(a string, b string) string {
+	if a == "" || b == "" {
+		return a + b
+	}
+	return a + "&" + b
+}
+
+// forwardedNode formats a client address for Forwarded, where IPv6
+// addresses are bracketed and quoted.
+func forwardedNode(ip string) string {
+	if ip == "" {
+		return "unknown"
+	}
+
+	if strings.Contains(ip, ":") {
+		return `"[` + ip + `]"`
+	}
+
+	return ip
+}
+
+// forwardedValue quotes values that are not a plain token, such as a host
+// with a port.
+func forwardedValue(value string) string {
+	if strings.ContainsAny(value, `:[]" ;,`) {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value) + `"`
+	}
+	return value
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startProxy serves a Proxy for upstream with the given config and returns
+// the address to send raw requests to.
+func startProxy(t *testing.T, upstream string, config Config) string {
+	if upstream != "" {
+		target, err := url.Parse(upstream)
+		require.NoError(t, err)
+		config.Target = target
+	}
+	p, err := New(config)
+	require.NoError(t, err)
+
+	s, err := server.Serve(0, p.Handler())
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	_, port, err := net.SplitHostPort(s.Listener.Addr().String())
+	require.NoError(t, err)
+	return net.JoinHostPort("127.0.0.1", port)
+}
+
+func send(t *testing.T, addr string, raw string) string {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(resp)
+}
+
+func TestForwarding(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	bodies := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- r
+		bodies <- string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Add("Set-Cookie", "a=1")
+		w.Header().Add("Set-Cookie", "b=2")
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "secret")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, `{"created":true}`)
+	}))
+	defer upstream.Close()
+	addr := startProxy(t, upstream.URL+"/base", Config{StripPrefix: "/api/"})
+
+	resp := send(t, addr, "POST /api/items?sort=asc HTTP/1.1\r\nHost: example.com\r\n"+
+		"Content-Length: 5\r\nContent-Type: text/plain\r\nConnection: X-Hop\r\nX-Hop: drop\r\n"+
+		"Proxy-Authorization: Basic Zm9v\r\nX-Forwarded-For: 6.6.6.6\r\nX-Custom: kept\r\n\r\nhello")
+
+	// Test: Method, path, query, headers and body reach the upstream
+	r := <-received
+	assert.Equal(t, "POST", r.Method)
+	assert.Equal(t, "/base/items", r.URL.Path)
+	assert.Equal(t, "sort=asc", r.URL.RawQuery)
+	assert.Equal(t, "hello", <-bodies)
+	assert.Equal(t, "kept", r.Header.Get("X-Custom"))
+	assert.Equal(t, "text/plain", r.Header.Get("Content-Type"))
+	assert.Equal(t, strings.TrimPrefix(upstream.URL, "http://"), r.Host)
+
+	// Test: Hop-by-hop headers are not forwarded
+	assert.Empty(t, r.Header.Get("X-Hop"))
+	assert.Empty(t, r.Header.Get("Proxy-Authorization"))
+
+	// Test: Forwarding headers describe the client, replacing spoofed ones
+	assert.Equal(t, "127.0.0.1", r.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "example.com", r.Header.Get("X-Forwarded-Host"))
+	assert.Equal(t, "http", r.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, "for=127.0.0.1;host=example.com;proto=http", r.Header.Get("Forwarded"))
+
+	// Test: The upstream status and headers come back, minus hop-by-hop ones
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 201 "))
+	assert.Contains(t, resp, "content-type: application/json\r\n")
+	assert.Contains(t, resp, "set-cookie: a=1\r\n")
+	assert.Contains(t, resp, "set-cookie: b=2\r\n")
+	assert.Contains(t, resp, "content-length: 16\r\n")
+	assert.NotContains(t, resp, "x-upstream-hop")
+	assert.NotContains(t, resp, "keep-alive")
+	assert.True(t, strings.HasSuffix(resp, `{"created":true}`))
+}
+
+func TestStripPrefix(t *testing.T) {
+	// Test: The prefix is removed on a path segment boundary
+	assert.Equal(t, "/items", stripPrefix("/api/items", "/api/"))
+	assert.Equal(t, "/items", stripPrefix("/api/items", "/api"))
+	assert.Equal(t, "/", stripPrefix("/api", "/api/"))
+	assert.Equal(t, "/", stripPrefix("/api/", "/api/"))
+
+	// Test: Paths that only share leading characters are left alone
+	assert.Equal(t, "/apiary/x", stripPrefix("/apiary/x", "/api/"))
+	assert.Equal(t, "/other", stripPrefix("/other", "/api/"))
+}
+
+func TestTrustForwarded(t *testing.T) {
+	received := make(chan *http.Request, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r
+	}))
+	defer upstream.Close()
+	addr := startProxy(t, upstream.URL, Config{TrustForwarded: true, PreserveHost: true})
+
+	send(t, addr, "GET / HTTP/1.1\r\nHost: example.com:8443\r\nX-Forwarded-For: 203.0.113.9\r\n"+
+		"X-Forwarded-Proto: https\r\nForwarded: for=203.0.113.9;proto=https\r\n\r\n")
+
+	// Test: Trusted forwarding headers are appended to
+	r := <-received
+	assert.Equal(t, "203.0.113.9, 127.0.0.1", r.Header.Get("X-Forwarded-For"))
+	assert.Equal(t, "https", r.Header.Get("X-Forwarded-Proto"))
+	assert.Equal(t, `for=203.0.113.9;proto=https, for=127.0.0.1;host="example.com:8443";proto=http`, r.Header.Get("Forwarded"))
+
+	// Test: The client's Host is preserved
+	assert.Equal(t, "example.com:8443", r.Host)
+}
+
+func TestStreamingAndTrailers(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Checksum")
+		w.WriteHeader(http.StatusOK)
+		io.WriteString(w, "part one, ")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "part two")
+		w.Header().Set("X-Checksum", "abc123")
+	}))
+	defer upstream.Close()
+	addr := startProxy(t, upstream.URL, Config{})
+
+	// Test: Trailers are passed through on a chunked body
+	resp := send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\nTE: trailers\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "transfer-encoding: chunked\r\n")
+	assert.Contains(t, resp, "trailer: X-Checksum\r\n")
+	assert.Contains(t, resp, "part one, ")
+	assert.Contains(t, resp, "part two")
+	assert.True(t, strings.HasSuffix(resp, "0\r\nx-checksum: abc123\r\n\r\n"))
+
+	// Test: HTTP/1.0 clients get the body without chunking
+	resp = send(t, addr, "GET / HTTP/1.0\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\npart one, part two"))
+}
+
+func TestStreamedRequestBody(t *testing.T) {
+	firstHalf := make(chan string, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		buf := make([]byte, 5)
+		_, err := io.ReadFull(r.Body, buf)
+		firstHalf <- string(buf)
+		if err != nil {
+			return
+		}
+		rest, _ := io.ReadAll(r.Body)
+		io.WriteString(w, string(buf)+string(rest))
+	}))
+	defer upstream.Close()
+	target, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+	p, err := New(Config{Target: target})
+	require.NoError(t, err)
+	s, err := server.Serve(0, p.Handler(), server.WithStreamBody(func(*request.Request) bool { return true }))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = io.WriteString(conn, "POST /upload HTTP/1.1\r\nHost: example.com\r\nContent-Length: 10\r\n\r\nhello")
+	require.NoError(t, err)
+
+	// Test: The upstream gets the start of the body while the client is still sending it
+	select {
+	case got := <-firstHalf:
+		assert.Equal(t, "hello", got)
+	case <-time.After(2 * time.Second):
+		t.Fatal("the body was not streamed to the upstream")
+	}
+
+	// Test: The rest of the body follows and the response comes back
+	_, err = io.WriteString(conn, "world")
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "HTTP/1.1 200")
+	assert.True(t, strings.HasSuffix(string(resp), "helloworld"))
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	// Test: An unreachable upstream gives a 502
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	closed := "http://" + listener.Addr().String()
+	listener.Close()
+	addr := startProxy(t, closed, Config{})
+	resp := send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 502 Bad Gateway\r\n"))
+
+	// Test: A body that fails mid-stream is not ended cleanly
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 10_000))
+		w.(http.Flusher).Flush()
+		panic(http.ErrAbortHandler)
+	}))
+	defer upstream.Close()
+	addr = startProxy(t, upstream.URL, Config{})
+	resp = send(t, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, resp, "\r\n0\r\n\r\n")
+
+	// Test: Request targets that are not paths are refused
+	resp = send(t, addr, "OPTIONS example.com:443 HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 400 Bad Request\r\n"))
+}
+
+func TestNew(t *testing.T) {
+	// Test: The target must be an absolute http or https URL
+	_, err := New(Config{})
+	assert.Error(t, err)
+	_, err = New(Config{Target: &url.URL{Scheme: "ftp", Host: "example.com"}})
+	assert.Error(t, err)
+}
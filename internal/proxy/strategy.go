@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"hash/fnv"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+)
+
+// Strategy chooses which backend serves a request. Pick is given the
+// backends that are currently available, at least one of them.
+type Strategy interface {
+	Pick(backends []*Backend, req *request.Request) *Backend
+}
+
+type roundRobin struct {
+	next atomic.Uint64
+}
+
+// RoundRobin sends requests to each backend in turn.
+func RoundRobin() Strategy {
+	return &roundRobin{}
+}
+
+func (r *roundRobin) Pick(backends []*Backend, req *request.Request) *Backend {
+	n := r.next.Add(1) - 1
+	return backends[n%uint64(len(backends))]
+}
+
+type leastConnections struct {
+	next atomic.Uint64
+}
+
+// LeastConnections sends requests to the backend with the fewest in
+// flight relative to its weight, taking turns between equally loaded ones.
+func LeastConnections() Strategy {
+	return &leastConnections{}
+}
+
+func (l *leastConnections) Pick(backends []*Backend, req *request.Request) *Backend {
+	start := int(l.next.Add(1) % uint64(len(backends)))
+	var best *Backend
+
+	for i := range backends {
+		b := backends[(start+i)%len(backends)]
+		if best == nil || b.Active()*best.weight < best.Active()*b.weight {
+			best = b
+		}
+	}
+
+	return best
+}
+
+type weighted struct {
+	mu      sync.Mutex
+	current map[*Backend]int
+}
+
+// Weighted spreads requests in proportion to the backends' weights,
+// interleaving them rather than sending each backend its share in a row.
+func Weighted() Strategy {
+	return &weighted{current: map[*Backend]int{}}
+}
+
+// Pick uses the smooth weighted round robin from nginx: every backend gains
+// its weight, the one with the most is picked and pays back the total.
+// Backends that are not among the candidates are forgotten, so one that
+// comes back starts afresh and removed ones are not kept forever.
+func (w *weighted) Pick(backends []*Backend, req *request.Request) *Backend {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for b := range w.current {
+		if !slices.Contains(backends, b) {
+			delete(w.current, b)
+		}
+	}
+
+	var best *Backend
+	total := 0
+
+	for _, b := range backends {
+		w.current[b] += b.weight
+		total += b.weight
+		if best == nil || w.current[b] > w.current[best] {
+			best = b
+		}
+	}
+
+	w.current[best] -= total
+
+	return best
+}
+
+type consistentHash struct {
+	key      func(req *request.Request) string
+	fallback Strategy
+}
+
+// ConsistentHash sends every request with the same key to the same
+// backend, such as all requests for one user or one cache key. When a
+// backend leaves or joins, only the keys it owned move. Requests with an
+// empty key are spread round robin.
+func ConsistentHash(key func(req *request.Request) string) Strategy {
+	return &consistentHash{key: key, fallback: RoundRobin()}
+}
+
+// Pick uses weighted rendezvous hashing: each backend scores the key and
+// the highest score wins.
+func (c *consistentHash) Pick(backends []*Backend, req *request.Request) *Backend {
+	key := c.key(req)
+
+	if key == "" {
+		return c.fallback.Pick(backends, req)
+	}
+
+	var best *Backend
+	bestScore := math.Inf(-1)
+
+	for _, b := range backends {
+		h := fnv.New64a()
+		h.Write([]byte(b.url.String()))
+		h.Write([]byte{0})
+		h.Write([]byte(key))
+
+		// Map the hash into (0, 1) and weight it so a backend's share of
+		// keys follows its weight.
+		unit := (float64(mix64(h.Sum64())>>11) + 0.5) / (1 << 53)
+		score := -float64(b.weight) / math.Log(unit)
+
+		if score > bestScore {
+			best, bestScore = b, score
+		}
+	}
+
+	return best
+}
+
+// mix64 is the splitmix64 finalizer. FNV-1a leaves the high bits of similar
+// inputs, such as backends that differ in one digit, correlated, which skews
+// how keys are shared unless they are mixed first.
+func mix64(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x
+}
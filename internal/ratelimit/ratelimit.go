@@ -0,0 +1,234 @@
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/auth"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+)
+
+// DefaultMaxKeys bounds how many clients a Limiter tracks when
+// Config.MaxKeys is not set.
+const DefaultMaxKeys = 10_000
+
+// KeyFunc picks the bucket a request counts against. Requests for which it
+// returns false are not limited.
+type KeyFunc func(req *request.Request) (string, bool)
+
+// ByIP keys requests by client IP. IPv6 clients are grouped by their /64,
+// since one client usually holds the whole prefix.
+func ByIP(req *request.Request) (string, bool) {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+
+	if err != nil {
+		host = req.RemoteAddr
+	}
+
+	ip := net.ParseIP(host)
+
+	if ip == nil {
+		return host, host != ""
+	}
+
+	if ip.To4() == nil {
+		return ip.Mask(net.CIDRMask(64, 128)).String() + "/64", true
+	}
+
+	return ip.String(), true
+}
+
+// ByHeader keys requests by the value of a header, such as an API key.
+// Requests without it are not limited.
+func ByHeader(name string) KeyFunc {
+	return func(req *request.Request) (string, bool) {
+		value, ok := req.Headers.Get(name)
+		return value, ok && value != ""
+	}
+}
+
+// ByPrincipal keys requests by the authenticated principal, so it must run
+// after the auth middleware. Anonymous requests fall back to their IP.
+func ByPrincipal(req *request.Request) (string, bool) {
+	if p := auth.FromRequest(req); p != nil && p.Name != "" {
+		return "principal:" + p.Name, true
+	}
+
+	key, ok := ByIP(req)
+
+	return "ip:" + key, ok
+}
+
+type Config struct {
+	// Rate is how many requests per second each key may make on average.
+	Rate float64
+	// Burst is how many requests a key may make at once. Defaults to one.
+	Burst int
+	// Key defaults to ByIP.
+	Key KeyFunc
+	// MaxKeys bounds memory use. The least recently seen key is forgotten
+	// once more are tracked, which at worst hands it a fresh bucket.
+	MaxKeys int
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+// Limiter holds one token bucket per key.
+type Limiter struct {
+	config Config
+
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// recent orders keys from most to least recently seen.
+	recent *list.List
+}
+
+type bucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// Decision is the outcome of taking a token.
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until a token is available again.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+func New(config Config) *Limiter {
+	if config.Burst <= 0 {
+		config.Burst = 1
+	}
+
+	if config.Key == nil {
+		config.Key = ByIP
+	}
+
+	if config.MaxKeys <= 0 {
+		config.MaxKeys = DefaultMaxKeys
+	}
+
+	if config.Now == nil {
+		config.Now = time.Now
+	}
+
+	return &Limiter{config: config, buckets: map[string]*list.Element{}, recent: list.New()}
+}
+
+// Allow takes a token from key's bucket if one is available.
+func (l *Limiter) Allow(key string) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.config.Now()
+	burst := float64(l.config.Burst)
+
+	element, ok := l.buckets[key]
+	if ok {
+		l.recent.MoveToFront(element)
+	} else {
+		element = l.recent.PushFront(&bucket{key: key, tokens: burst, last: now})
+		l.buckets[key] = element
+		if l.recent.Len() > l.config.MaxKeys {
+			oldest := l.recent.Back()
+			l.recent.Remove(oldest)
+			delete(l.buckets, oldest.Value.(*bucket).key)
+		}
+	}
+
+	b := element.Value.(*bucket)
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst, b.tokens+elapsed*l.config.Rate)
+	}
+	b.last = now
+
+	decision := Decision{Limit: l.config.Burst}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = l.timeFor(1 - b.tokens)
+	}
+
+	decision.Remaining = int(b.tokens)
+	decision.Reset = l.timeFor(burst - b.tokens)
+
+	return decision
+}
+
+// timeFor is how long refilling the given number of tokens takes.
+func (l *Limiter) timeFor(tokens float64) time.Duration {
+	if l.config.Rate <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Duration(tokens / l.config.Rate * float64(time.Second))
+}
+
+// Len reports how many keys are tracked.
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.recent.Len()
+}
+
+// Middleware answers requests over the limit with 429 Too Many Requests
+// and a Retry-After header. Every limited response carries RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset.
+func (l *Limiter) Middleware() server.Middleware {
+	policy := strconv.Itoa(l.config.Burst)
+	if l.config.Rate > 0 {
+		window := math.Max(1, math.Round(float64(l.config.Burst)/l.config.Rate))
+		policy += ";w=" + strconv.Itoa(int(window))
+	}
+
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			key, ok := l.config.Key(req)
+
+			if !ok {
+				next(w, req)
+				return
+			}
+
+			decision := l.Allow(key)
+			h := w.Header()
+			h.Set("ratelimit-policy", policy)
+			h.Set("ratelimit-limit", strconv.Itoa(decision.Limit))
+			h.Set("ratelimit-remaining", strconv.Itoa(decision.Remaining))
+			h.Set("ratelimit-reset", strconv.Itoa(seconds(decision.Reset)))
+
+			if !decision.Allowed {
+				body := "too many requests"
+				rejected := response.GetDefaultHeaders(len(body))
+				rejected.Set("retry-after", strconv.Itoa(seconds(decision.RetryAfter)))
+				w.WriteStatusLine(response.TooManyRequestsStatus)
+				w.WriteHeaders(rejected)
+				w.WriteBody(body)
+				return
+			}
+
+			next(w, req)
+		}
+	}
+}
+
+// seconds rounds d up to whole seconds, as Retry-After and RateLimit-Reset
+// only carry seconds.
+func seconds(d time.Duration) int {
+	if d >= time.Duration(math.MaxInt32)*time.Second {
+		return math.MaxInt32
+	}
+	return int(math.Ceil(d.Seconds()))
+}
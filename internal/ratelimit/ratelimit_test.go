@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/auth"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.now }
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func call(t *testing.T, handler server.Handler, remoteAddr string, extraHeaders string) string {
+	t.Helper()
+	req, err := request.RequestFromReader(strings.NewReader("GET / HTTP/1.1\r\nHost: localhost\r\n" + extraHeaders + "\r\n"))
+	require.NoError(t, err)
+	req.RemoteAddr = remoteAddr
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	handler(w, req)
+	require.NoError(t, w.Finish())
+	return buf.String()
+}
+
+func ok(w *response.Writer, req *request.Request) {
+	w.WriteBody("ok")
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	limiter := New(Config{Rate: 2, Burst: 3, Now: clock.Now})
+
+	// Test: A full bucket allows a burst
+	for i := range 3 {
+		d := limiter.Allow("a")
+		assert.True(t, d.Allowed)
+		assert.Equal(t, 2-i, d.Remaining)
+	}
+
+	// Test: An empty bucket refuses with the time until the next token
+	d := limiter.Allow("a")
+	assert.False(t, d.Allowed)
+	assert.Equal(t, 500*time.Millisecond, d.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, d.Reset)
+
+	// Test: Tokens refill at the configured rate, up to the burst
+	clock.Advance(500 * time.Millisecond)
+	assert.True(t, limiter.Allow("a").Allowed)
+	assert.False(t, limiter.Allow("a").Allowed)
+	clock.Advance(time.Hour)
+	assert.Equal(t, 2, limiter.Allow("a").Remaining)
+
+	// Test: Keys have their own buckets
+	assert.True(t, limiter.Allow("b").Allowed)
+}
+
+func TestMaxKeys(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	limiter := New(Config{Rate: 1, Burst: 1, MaxKeys: 3, Now: clock.Now})
+
+	// Test: Memory stays bounded and the least recently seen key goes first
+	for i := range 3 {
+		limiter.Allow(fmt.Sprint(i))
+	}
+	limiter.Allow("0")
+	limiter.Allow("3")
+	assert.Equal(t, 3, limiter.Len())
+	assert.False(t, limiter.Allow("0").Allowed, "recently seen key kept its bucket")
+	assert.True(t, limiter.Allow("1").Allowed, "evicted key starts over")
+}
+
+func TestMiddleware(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+	handler := server.Chain(ok, New(Config{Rate: 0.5, Burst: 2, Now: clock.Now}).Middleware())
+
+	// Test: Allowed responses carry RateLimit headers
+	resp := call(t, handler, "203.0.113.7:5000", "")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "ratelimit-limit: 2\r\n")
+	assert.Contains(t, resp, "ratelimit-remaining: 1\r\n")
+	assert.Contains(t, resp, "ratelimit-reset: 2\r\n")
+	assert.Contains(t, resp, "ratelimit-policy: 2;w=4\r\n")
+
+	// Test: Over the limit gets 429 with Retry-After, whatever the source port
+	call(t, handler, "203.0.113.7:5001", "")
+	resp = call(t, handler, "203.0.113.7:5002", "")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 429 Too Many Requests\r\n"))
+	assert.Contains(t, resp, "retry-after: 2\r\n")
+	assert.Contains(t, resp, "ratelimit-remaining: 0\r\n")
+
+	// Test: Other clients are unaffected
+	resp = call(t, handler, "198.51.100.1:5000", "")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+
+	// Test: IPv6 clients share a bucket per /64
+	call(t, handler, "[2001:db8::1]:5000", "")
+	call(t, handler, "[2001:db8::2]:5000", "")
+	resp = call(t, handler, "[2001:db8::ffff]:5000", "")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 429"))
+	resp = call(t, handler, "[2001:db8:0:1::1]:5000", "")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200"))
+}
+
+func TestKeys(t *testing.T) {
+	clock := &fakeClock{now: time.Unix(1_000_000, 0)}
+
+	// Test: Keyed by header, requests without it pass untouched
+	handler := server.Chain(ok, New(Config{Rate: 1, Burst: 1, Key: ByHeader("X-Api-Key"), Now: clock.Now}).Middleware())
+	assert.Contains(t, call(t, handler, "192.0.2.1:1", "X-Api-Key: one\r\n"), "200 OK")
+	assert.Contains(t, call(t, handler, "192.0.2.2:1", "X-Api-Key: one\r\n"), "429")
+	assert.Contains(t, call(t, handler, "192.0.2.1:1", "X-Api-Key: two\r\n"), "200 OK")
+	resp := call(t, handler, "192.0.2.1:1", "")
+	assert.Contains(t, resp, "200 OK")
+	assert.NotContains(t, resp, "ratelimit")
+
+	// Test: Keyed by principal after authentication
+	limiter := New(Config{Rate: 1, Burst: 1, Key: ByPrincipal, Now: clock.Now})
+	handler = server.Chain(ok, auth.Basic("api", auth.StaticUsers(map[string]string{"alice": "pw", "bob": "pw"})), limiter.Middleware())
+	basic := func(user string) string {
+		return "Authorization: Basic " + map[string]string{"alice": "YWxpY2U6cHc=", "bob": "Ym9iOnB3"}[user] + "\r\n"
+	}
+	assert.Contains(t, call(t, handler, "192.0.2.1:1", basic("alice")), "200 OK")
+	assert.Contains(t, call(t, handler, "192.0.2.9:1", basic("alice")), "429")
+	assert.Contains(t, call(t, handler, "192.0.2.1:1", basic("bob")), "200 OK")
+}
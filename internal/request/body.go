@@ -0,0 +1,56 @@
+package request
+
+import "io"
+
+// bodyReader streams a body of a known length from the connection, starting
+// with the bytes that were already read along with the headers. It is not
+// safe for concurrent use.
+type bodyReader struct {
+	prefix    []byte
+	reader    io.Reader
+	remaining int
+	onEOF     func()
+	done      bool
+}
+
+func (b *bodyReader) Read(p []byte) (int, error) {
+	if b.remaining == 0 {
+		b.finish()
+		return 0, io.EOF
+	}
+
+	if len(p) > b.remaining {
+		p = p[:b.remaining]
+	}
+
+	var n int
+	var err error
+	if len(b.prefix) > 0 {
+		n = copy(p, b.prefix)
+		b.prefix = b.prefix[n:]
+	} else {
+		n, err = b.reader.Read(p)
+	}
+	b.remaining -= n
+
+	if b.remaining == 0 {
+		b.finish()
+		return n, nil
+	}
+
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return n, err
+}
+
+func (b *bodyReader) finish() {
+	if b.done {
+		return
+	}
+	b.done = true
+	if b.onEOF != nil {
+		b.onEOF()
+	}
+}
@@ -2,6 +2,9 @@ package request
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"strconv"
@@ -24,7 +27,83 @@ type Request struct {
 	RequestLine RequestLine
 	Headers     headers.Headers
 	Body        []byte
+	// RemoteAddr is the network address of the client, set by the server.
+	RemoteAddr string
+	// TLS describes the negotiated TLS connection the request arrived on.
+	// It is nil for plaintext connections.
+	TLS         *tls.ConnectionState
 	state       RequestState
+	ctx         context.Context
+	options     Options
+	headerBytes int
+	buffered    []byte
+	body        *bodyReader
+}
+
+// Options customises how RequestFromReaderWithOptions reads a request.
+type Options struct {
+	// HeadersRead, if set, is called once the request line and headers have
+	// been parsed and before any of the body is read.
+	HeadersRead func()
+	// MaxHeaderBytes limits the combined size of the request line and
+	// headers. Zero means no limit.
+	MaxHeaderBytes int
+	// MaxBodyBytes limits the declared content-length. Zero means no limit.
+	MaxBodyBytes int
+	// StreamBody, if set, is called once the headers have been parsed for
+	// requests that declare a body. When it returns true the body is left in
+	// the reader and read through BodyReader instead of into Body.
+	StreamBody func(r *Request) bool
+	// BodyRead, if set, is called once a streamed body has been read in
+	// full.
+	BodyRead func()
+}
+
+var (
+	ErrHeadersTooLarge = errors.New("request headers too large")
+	ErrBodyTooLarge    = errors.New("request body too large")
+)
+
+// Context returns the request's context. It is never nil; requests that were
+// not given one use context.Background.
+func (r *Request) Context() context.Context {
+	if r.ctx != nil {
+		return r.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of r with its context replaced by ctx, so
+// middleware can derive a context and pass the new request down the chain.
+func (r *Request) WithContext(ctx context.Context) *Request {
+	if ctx == nil {
+		panic("nil context")
+	}
+	r2 := *r
+	r2.ctx = ctx
+	return &r2
+}
+
+// Buffered returns bytes the reader delivered past the end of the request,
+// such as the first bytes of a protocol the client switched to.
+func (r *Request) Buffered() []byte {
+	return r.buffered
+}
+
+// BodyReader returns the request body as a stream. A streamed body is read
+// from the connection as it arrives and can only be read once; otherwise
+// the reader is over Body.
+func (r *Request) BodyReader() io.Reader {
+	if r.body != nil {
+		return r.body
+	}
+	return bytes.NewReader(r.Body)
+}
+
+// BodyStreamed reports whether the body is left to be read through
+// BodyReader rather than held in Body.
+func (r *Request) BodyStreamed() bool {
+	return r.body != nil
 }
 
 func (r *Request) parse(data []byte) (int, error) {
@@ -54,14 +133,29 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 		}
 		r.RequestLine = *requestLine
 		r.state = requestStateParsingHeaders
+		r.headerBytes += n
 		return n, nil
 	case requestStateParsingHeaders:
 		n, done, err := r.Headers.Parse(data)
 		if err != nil {
 			return 0, err
 		}
+		r.headerBytes += n
+		if r.options.MaxHeaderBytes > 0 && r.headerBytes > r.options.MaxHeaderBytes {
+			return 0, ErrHeadersTooLarge
+		}
 		if done {
+			if err := r.checkBodyLimit(); err != nil {
+				return 0, err
+			}
 			r.state = requestStateParsingBody
+			if r.options.HeadersRead != nil {
+				r.options.HeadersRead()
+			}
+			if contentLength, ok := r.streamedLength(); ok {
+				r.body = &bodyReader{remaining: contentLength, onEOF: r.options.BodyRead}
+				r.state = requestStateDone
+			}
 		}
 		return n, nil
 	case requestStateParsingBody:
@@ -72,25 +166,21 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 			return 0, nil
 		}
 
-		contentLengthNum, err := strconv.Atoi(contentLength)
+		contentLengthNum, err := parseContentLength(contentLength)
 
 		if err != nil {
 			return 0, err
 		}
 
-		r.Body = append(r.Body, data...)
-
-		bodyLength := len(r.Body)
+		remaining := contentLengthNum - len(r.Body)
+		consumed := min(len(data), remaining)
+		r.Body = append(r.Body, data[:consumed]...)
 
-		if bodyLength > contentLengthNum {
-			return 0, fmt.Errorf("the body is larger than the content-length. Received %v, expected %v", bodyLength, contentLengthNum)
-		}
-
-		if bodyLength == contentLengthNum {
+		if len(r.Body) == contentLengthNum {
 			r.state = requestStateDone
 		}
 
-		return len(data), nil
+		return consumed, nil
 
 	case requestStateDone:
 		return 0, fmt.Errorf("error: trying to read data in a done state")
@@ -99,6 +189,85 @@ func (r *Request) parseSingle(data []byte) (int, error) {
 	}
 }
 
+// checkBodyLimit validates the declared content-length before any of the
+// body is read.
+func (r *Request) checkBodyLimit() error {
+	contentLength, exists := r.Headers.Get("content-length")
+
+	if !exists {
+		return nil
+	}
+
+	contentLengthNum, err := parseContentLength(contentLength)
+
+	if err != nil {
+		return err
+	}
+
+	if r.options.MaxBodyBytes > 0 && contentLengthNum > r.options.MaxBodyBytes {
+		return ErrBodyTooLarge
+	}
+
+	return nil
+}
+
+// parseContentLength accepts only a plain decimal number, so values such as
+// "-1" or "+5" cannot make the body length negative or ambiguous.
+func parseContentLength(value string) (int, error) {
+	if value == "" || strings.TrimLeft(value, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid content-length: %q", value)
+	}
+
+	n, err := strconv.Atoi(value)
+
+	if err != nil {
+		return 0, fmt.Errorf("invalid content-length: %q", value)
+	}
+
+	return n, nil
+}
+
+// streamedLength returns the declared length of a body that should be left
+// for BodyReader. Only requests that declare a body and keep speaking HTTP
+// are streamed.
+func (r *Request) streamedLength() (int, bool) {
+	if r.options.StreamBody == nil || r.switchesProtocols() {
+		return 0, false
+	}
+
+	contentLength, exists := r.Headers.Get("content-length")
+
+	if !exists {
+		return 0, false
+	}
+
+	contentLengthNum, err := parseContentLength(contentLength)
+
+	if err != nil || contentLengthNum == 0 {
+		return 0, false
+	}
+
+	return contentLengthNum, r.options.StreamBody(r)
+}
+
+// switchesProtocols reports whether bytes after the request belong to
+// another protocol, as with an Upgrade or a CONNECT tunnel, rather than to
+// an oversized body.
+func (r *Request) switchesProtocols() bool {
+	_, upgrade := r.Headers.Get("upgrade")
+	return upgrade || r.RequestLine.Method == "CONNECT"
+}
+
+// headerLimitExceeded reports whether unparsed bytes still waiting for the
+// end of a request line or header would push the header section over
+// MaxHeaderBytes.
+func (r *Request) headerLimitExceeded(pending int) bool {
+	if r.options.MaxHeaderBytes <= 0 || r.state >= requestStateParsingBody {
+		return false
+	}
+	return r.headerBytes+pending > r.options.MaxHeaderBytes
+}
+
 type RequestLine struct {
 	HttpVersion   string
 	RequestTarget string
@@ -108,6 +277,10 @@ type RequestLine struct {
 const bufferSize = 8
 
 func RequestFromReader(reader io.Reader) (*Request, error) {
+	return RequestFromReaderWithOptions(reader, Options{})
+}
+
+func RequestFromReaderWithOptions(reader io.Reader, options Options) (*Request, error) {
 	readToIndex := 0
 	buffer := make([]byte, bufferSize)
 	request := &Request{
@@ -115,6 +288,7 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		Headers:     headers.NewHeaders(),
 		Body:        make([]byte, 0),
 		state:       requestStateInitialized,
+		options:     options,
 	}
 	for request.state != requestStateDone {
 		if readToIndex >= len(buffer) {
@@ -146,6 +320,26 @@ func RequestFromReader(reader io.Reader) (*Request, error) {
 		copy(buffer, buffer[numBytesParsed:])
 		readToIndex -= numBytesParsed
 
+		if request.headerLimitExceeded(readToIndex) {
+			return nil, ErrHeadersTooLarge
+		}
+
+	}
+
+	if request.body != nil {
+		if readToIndex > request.body.remaining {
+			return nil, fmt.Errorf("the body is larger than the content-length. Received %v, expected %v", readToIndex, request.body.remaining)
+		}
+		request.body.prefix = append([]byte(nil), buffer[:readToIndex]...)
+		request.body.reader = reader
+		return request, nil
+	}
+
+	if readToIndex > 0 {
+		if contentLength, ok := request.Headers.Get("content-length"); ok && !request.switchesProtocols() {
+			return nil, fmt.Errorf("the body is larger than the content-length. Received %v, expected %v", len(request.Body)+readToIndex, contentLength)
+		}
+		request.buffered = append([]byte(nil), buffer[:readToIndex]...)
 	}
 
 	return request, nil
@@ -188,7 +382,7 @@ func requestLineFromString(str string) (*RequestLine, error) {
 		return nil, fmt.Errorf("unrecognized HTTP-version: %s", httpPart)
 	}
 	version := versionParts[1]
-	if version != "1.1" {
+	if version != "1.1" && version != "1.0" {
 		return nil, fmt.Errorf("unrecognized HTTP-version: %s", version)
 	}
 
@@ -1,7 +1,9 @@
 package request
 
 import (
+	"context"
 	"io"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -183,6 +185,177 @@ func TestBodyParse(t *testing.T) {
 	require.NoError(t, err)
 	require.NotNil(t, r)
 	assert.Equal(t, "", string(r.Body))
+
+	// Test: Bytes past the end of the body of an upgrade are kept rather than parsed
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Upgrade: h2c\r\n" +
+			"\r\n" +
+			"hellopipelined",
+		numBytesPerRead: 64,
+	}
+	r, err = RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, "hello", string(r.Body))
+	assert.Equal(t, "pipelined", string(r.Buffered()))
+
+	// Test: Body larger than reported content length
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Host: localhost:42069\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 64,
+	}
+	_, err = RequestFromReader(reader)
+	require.ErrorContains(t, err, "the body is larger than the content-length")
+
+	// Test: Negative or malformed content lengths are rejected
+	for _, length := range []string{"-1", "+5", "0x10", "", "99999999999999999999"} {
+		reader = &chunkReader{
+			data: "POST /submit HTTP/1.1\r\n" +
+				"Host: localhost:42069\r\n" +
+				"Content-Length: " + length + "\r\n" +
+				"\r\n" +
+				"hello",
+			numBytesPerRead: 3,
+		}
+		_, err = RequestFromReader(reader)
+		require.ErrorContains(t, err, "invalid content-length", length)
+	}
+}
+
+func TestRequestContext(t *testing.T) {
+	// Test: Parsed requests default to the background context
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReader(reader)
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, context.Background(), r.Context())
+
+	// Test: WithContext replaces the context on a copy
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, "value")
+	r2 := r.WithContext(ctx)
+	assert.Equal(t, "value", r2.Context().Value(key{}))
+	assert.Nil(t, r.Context().Value(key{}))
+	assert.Equal(t, r.RequestLine, r2.RequestLine)
+}
+
+func TestHeadersReadHook(t *testing.T) {
+	// Test: HeadersRead fires once, before the body is read
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	calls := 0
+	r, err := RequestFromReaderWithOptions(reader, Options{
+		HeadersRead: func() { calls++ },
+	})
+	require.NoError(t, err)
+	require.NotNil(t, r)
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, "hello", string(r.Body))
+}
+
+func TestStreamBody(t *testing.T) {
+	// Test: A streamed body is left in the reader and read through BodyReader
+	reader := &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	bodyRead := 0
+	r, err := RequestFromReaderWithOptions(reader, Options{
+		StreamBody: func(r *Request) bool { return true },
+		BodyRead:   func() { bodyRead++ },
+	})
+	require.NoError(t, err)
+	require.True(t, r.BodyStreamed())
+	assert.Empty(t, r.Body)
+	assert.Less(t, reader.pos, len(reader.data))
+	body, err := io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello world!\n", string(body))
+	assert.Equal(t, 1, bodyRead)
+
+	// Test: A body cut short is an unexpected EOF
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReaderWithOptions(reader, Options{StreamBody: func(r *Request) bool { return true }})
+	require.NoError(t, err)
+	_, err = io.ReadAll(r.BodyReader())
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+
+	// Test: Requests without a body, or not picked, are read as usual
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 5\r\n" +
+			"\r\n" +
+			"hello",
+		numBytesPerRead: 3,
+	}
+	r, err = RequestFromReaderWithOptions(reader, Options{StreamBody: func(r *Request) bool { return false }})
+	require.NoError(t, err)
+	assert.False(t, r.BodyStreamed())
+	body, err = io.ReadAll(r.BodyReader())
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(body))
+}
+
+func TestRequestLimits(t *testing.T) {
+	// Test: Headers within the limit
+	reader := &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	r, err := RequestFromReaderWithOptions(reader, Options{MaxHeaderBytes: 64})
+	require.NoError(t, err)
+	require.NotNil(t, r)
+
+	// Test: Too many header bytes
+	reader = &chunkReader{
+		data:            "GET / HTTP/1.1\r\nHost: localhost:42069\r\nUser-Agent: curl/7.81.0\r\n\r\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithOptions(reader, Options{MaxHeaderBytes: 32})
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: A single unterminated line longer than the limit
+	reader = &chunkReader{
+		data:            "GET /" + strings.Repeat("a", 100),
+		numBytesPerRead: 10,
+	}
+	_, err = RequestFromReaderWithOptions(reader, Options{MaxHeaderBytes: 32})
+	require.ErrorIs(t, err, ErrHeadersTooLarge)
+
+	// Test: Content-Length above the body limit
+	reader = &chunkReader{
+		data: "POST /submit HTTP/1.1\r\n" +
+			"Content-Length: 13\r\n" +
+			"\r\n" +
+			"hello world!\n",
+		numBytesPerRead: 3,
+	}
+	_, err = RequestFromReaderWithOptions(reader, Options{MaxBodyBytes: 12})
+	require.ErrorIs(t, err, ErrBodyTooLarge)
 }
 
 type chunkReader struct {
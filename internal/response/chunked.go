@@ -0,0 +1,207 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+)
+
+var (
+	ErrChunkedClosed    = errors.New("chunked body already closed")
+	ErrInvalidExtension = errors.New("invalid chunk extension")
+	ErrInvalidTrailer   = errors.New("invalid trailer field")
+)
+
+// forbiddenTrailers are fields a recipient needs before the body arrives,
+// so RFC 9110 section 6.5.1 does not allow sending them as trailers.
+var forbiddenTrailers = map[string]bool{
+	"authorization":      true,
+	"cache-control":      true,
+	"content-encoding":   true,
+	"content-length":     true,
+	"content-range":      true,
+	"content-type":       true,
+	"expect":             true,
+	"host":               true,
+	"max-forwards":       true,
+	"pragma":             true,
+	"proxy-authenticate": true,
+	"range":              true,
+	"set-cookie":         true,
+	"te":                 true,
+	"trailer":            true,
+	"transfer-encoding":  true,
+	"www-authenticate":   true,
+}
+
+// ChunkExtension is a name, and optional value, sent after a chunk's size.
+type ChunkExtension struct {
+	Name  string
+	Value string
+}
+
+// ChunkedWriter encodes a body with chunked transfer coding. Empty writes
+// are skipped, since a zero-length chunk ends the body, and Close writes
+// the last chunk along with any trailers.
+type ChunkedWriter struct {
+	writer   io.Writer
+	declared map[string]bool
+	closed   bool
+}
+
+// NewChunkedWriter returns a ChunkedWriter for a response sent with h. The
+// fields h lists in its Trailer header are the only trailers it accepts.
+func NewChunkedWriter(writer io.Writer, h headers.Headers) *ChunkedWriter {
+	declared := map[string]bool{}
+	if trailer, ok := h.Get("trailer"); ok {
+		for _, name := range strings.Split(trailer, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				declared[strings.ToLower(name)] = true
+			}
+		}
+	}
+
+	return &ChunkedWriter{writer: writer, declared: declared}
+}
+
+func (c *ChunkedWriter) Write(p []byte) (int, error) {
+	return c.WriteChunk(p)
+}
+
+// WriteChunk writes p as a single chunk with the given extensions.
+func (c *ChunkedWriter) WriteChunk(p []byte, extensions ...ChunkExtension) (int, error) {
+	if c.closed {
+		return 0, ErrChunkedClosed
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	ext, err := formatExtensions(extensions)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := io.WriteString(c.writer, strconv.FormatInt(int64(len(p)), 16)+ext+"\r\n"); err != nil {
+		return 0, err
+	}
+
+	n, err := c.writer.Write(p)
+
+	if err != nil {
+		return n, err
+	}
+
+	if _, err := io.WriteString(c.writer, "\r\n"); err != nil {
+		return n, err
+	}
+
+	return n, nil
+}
+
+func (c *ChunkedWriter) Close() error {
+	return c.CloseWithTrailers(nil)
+}
+
+// CloseWithTrailers ends the body, sending trailers after the last chunk.
+// Every trailer must have been declared and be allowed in a trailer
+// section; nothing is written if one is not.
+func (c *ChunkedWriter) CloseWithTrailers(trailers headers.Headers) error {
+	if c.closed {
+		return ErrChunkedClosed
+	}
+
+	for key, value := range trailers {
+		name := strings.ToLower(key)
+		switch {
+		case !c.declared[name]:
+			return fmt.Errorf("%w: %v was not declared in the trailer header", ErrInvalidTrailer, key)
+		case forbiddenTrailers[name]:
+			return fmt.Errorf("%w: %v cannot be sent as a trailer", ErrInvalidTrailer, key)
+		case strings.ContainsAny(value, "\r\n"):
+			return fmt.Errorf("%w: %v contains a line break", ErrInvalidTrailer, key)
+		}
+	}
+
+	c.closed = true
+
+	var b strings.Builder
+	b.WriteString("0\r\n")
+	for key, value := range trailers {
+		fmt.Fprintf(&b, "%v: %v\r\n", key, value)
+	}
+	b.WriteString("\r\n")
+
+	_, err := io.WriteString(c.writer, b.String())
+
+	return err
+}
+
+func formatExtensions(extensions []ChunkExtension) (string, error) {
+	var b strings.Builder
+	for _, ext := range extensions {
+		if !isToken(ext.Name) {
+			return "", fmt.Errorf("%w: name %q", ErrInvalidExtension, ext.Name)
+		}
+		b.WriteString(";" + ext.Name)
+
+		if ext.Value == "" {
+			continue
+		}
+		if isToken(ext.Value) {
+			b.WriteString("=" + ext.Value)
+			continue
+		}
+		quoted, ok := quoteString(ext.Value)
+		if !ok {
+			return "", fmt.Errorf("%w: value for %v contains a control character", ErrInvalidExtension, ext.Name)
+		}
+		b.WriteString("=" + quoted)
+	}
+
+	return b.String(), nil
+}
+
+// quoteString returns s as an HTTP quoted-string, which unlike a Go string
+// literal only escapes quotes and backslashes.
+func quoteString(s string) (string, bool) {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c < 0x20 && c != '\t') || c == 0x7f {
+			return "", false
+		}
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+
+	return b.String(), true
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, r := range s {
+		if (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			continue
+		}
+		if strings.ContainsRune("!#$%&'*+-.^_`|~", r) {
+			continue
+		}
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,72 @@
+package response
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkedWriter(t *testing.T) {
+	// Test: Chunks and the last chunk are framed correctly
+	var buf bytes.Buffer
+	c := NewChunkedWriter(&buf, nil)
+	n, err := c.Write([]byte("hello world, this is chunked"))
+	require.NoError(t, err)
+	assert.Equal(t, 28, n)
+	require.NoError(t, c.Close())
+	assert.Equal(t, "1c\r\nhello world, this is chunked\r\n0\r\n\r\n", buf.String())
+
+	// Test: Empty writes do not end the body
+	buf.Reset()
+	c = NewChunkedWriter(&buf, nil)
+	n, err = c.Write(nil)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	c.Write([]byte("abc"))
+	c.Write([]byte{})
+	assert.Equal(t, "3\r\nabc\r\n", buf.String())
+
+	// Test: Writing after Close fails
+	require.NoError(t, c.Close())
+	_, err = c.Write([]byte("late"))
+	require.ErrorIs(t, err, ErrChunkedClosed)
+	require.ErrorIs(t, c.Close(), ErrChunkedClosed)
+
+	// Test: Chunk extensions, quoting values that are not tokens
+	buf.Reset()
+	c = NewChunkedWriter(&buf, nil)
+	_, err = c.WriteChunk([]byte("data"), ChunkExtension{Name: "seq", Value: "1"}, ChunkExtension{Name: "note", Value: `say "hi"`}, ChunkExtension{Name: "last"})
+	require.NoError(t, err)
+	assert.Equal(t, "4;seq=1;note=\"say \\\"hi\\\"\";last\r\ndata\r\n", buf.String())
+
+	// Test: Invalid extensions are rejected before anything is written
+	buf.Reset()
+	_, err = c.WriteChunk([]byte("data"), ChunkExtension{Name: "bad name"})
+	require.ErrorIs(t, err, ErrInvalidExtension)
+	_, err = c.WriteChunk([]byte("data"), ChunkExtension{Name: "x", Value: "line\r\nbreak"})
+	require.ErrorIs(t, err, ErrInvalidExtension)
+	assert.Empty(t, buf.String())
+
+	// Test: Declared trailers are sent after the last chunk
+	h := headers.NewHeaders()
+	h.Set("Trailer", "X-Checksum, X-Length")
+	buf.Reset()
+	c = NewChunkedWriter(&buf, h)
+	c.Write([]byte("abc"))
+	require.NoError(t, c.CloseWithTrailers(headers.Headers{"X-Checksum": "123"}))
+	assert.Equal(t, "3\r\nabc\r\n0\r\nX-Checksum: 123\r\n\r\n", buf.String())
+
+	// Test: Undeclared and forbidden trailers are refused
+	buf.Reset()
+	c = NewChunkedWriter(&buf, h)
+	require.ErrorIs(t, c.CloseWithTrailers(headers.Headers{"x-other": "1"}), ErrInvalidTrailer)
+	h.Set("Trailer", "Content-Length")
+	c = NewChunkedWriter(&buf, h)
+	require.ErrorIs(t, c.CloseWithTrailers(headers.Headers{"content-length": "3"}), ErrInvalidTrailer)
+	require.ErrorIs(t, c.CloseWithTrailers(headers.Headers{"x-length": "3\r\nevil: 1"}), ErrInvalidTrailer)
+	assert.Empty(t, buf.String())
+	require.NoError(t, c.Close())
+}
@@ -0,0 +1,39 @@
+package response
+
+import (
+	"bufio"
+	"errors"
+	"net"
+)
+
+var (
+	ErrNotHijackable = errors.New("connection cannot be hijacked")
+	ErrHijacked      = errors.New("connection has already been hijacked")
+)
+
+// Hijacker is implemented by connections a handler can take over, for
+// protocols such as WebSocket or CONNECT tunnels that stop speaking HTTP
+// after the request.
+type Hijacker interface {
+	Hijack() (net.Conn, *bufio.ReadWriter, error)
+}
+
+// Hijack hands the underlying connection to the caller, who becomes
+// responsible for closing it and for any deadlines. Bytes the client sent
+// after the request that were already read are waiting in the returned
+// reader, so read through it rather than the connection. Anything still
+// buffered in w is flushed first; nothing may be written through w
+// afterwards.
+func (w *Writer) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.writer.(Hijacker)
+
+	if !ok {
+		return nil, nil, ErrNotHijackable
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, nil, err
+	}
+
+	return hijacker.Hijack()
+}
@@ -1,28 +1,72 @@
 package response
 
 import (
+	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"strconv"
+	"strings"
 
 	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
 )
 
 type StatusCode int
 
 const (
+	SwitchingProtocolsStatus  StatusCode = 101
 	OKStatus                  StatusCode = 200
+	NoContentStatus           StatusCode = 204
+	NotModifiedStatus         StatusCode = 304
 	BadRequestStatus          StatusCode = 400
+	UnauthorizedStatus        StatusCode = 401
+	ForbiddenStatus           StatusCode = 403
+	RequestTimeoutStatus      StatusCode = 408
+	ContentTooLargeStatus     StatusCode = 413
+	UpgradeRequiredStatus     StatusCode = 426
+	TooManyRequestsStatus     StatusCode = 429
+	HeadersTooLargeStatus     StatusCode = 431
 	InternalServerErrorStatus StatusCode = 500
+	BadGatewayStatus          StatusCode = 502
+	ServiceUnavailableStatus  StatusCode = 503
+	GatewayTimeoutStatus      StatusCode = 504
 )
 
 func getStatusLine(statusCode StatusCode) string {
 	switch statusCode {
+	case SwitchingProtocolsStatus:
+		return "HTTP/1.1 101 Switching Protocols"
 	case OKStatus:
 		return "HTTP/1.1 200 OK"
+	case NoContentStatus:
+		return "HTTP/1.1 204 No Content"
+	case NotModifiedStatus:
+		return "HTTP/1.1 304 Not Modified"
 	case BadRequestStatus:
 		return "HTTP/1.1 400 Bad Request"
+	case UnauthorizedStatus:
+		return "HTTP/1.1 401 Unauthorized"
+	case ForbiddenStatus:
+		return "HTTP/1.1 403 Forbidden"
+	case RequestTimeoutStatus:
+		return "HTTP/1.1 408 Request Timeout"
+	case ContentTooLargeStatus:
+		return "HTTP/1.1 413 Content Too Large"
+	case UpgradeRequiredStatus:
+		return "HTTP/1.1 426 Upgrade Required"
+	case TooManyRequestsStatus:
+		return "HTTP/1.1 429 Too Many Requests"
+	case HeadersTooLargeStatus:
+		return "HTTP/1.1 431 Request Header Fields Too Large"
 	case InternalServerErrorStatus:
 		return "HTTP/1.1 500 Internal Server Error"
+	case BadGatewayStatus:
+		return "HTTP/1.1 502 Bad Gateway"
+	case ServiceUnavailableStatus:
+		return "HTTP/1.1 503 Service Unavailable"
+	case GatewayTimeoutStatus:
+		return "HTTP/1.1 504 Gateway Timeout"
 	default:
 		return fmt.Sprintf("HTTP/1.1 %v ", statusCode)
 	}
@@ -36,71 +80,442 @@ func GetDefaultHeaders(contentLen int) headers.Headers {
 	return responseHeaders
 }
 
+// DefaultBufferSize is how much of a response Writer holds before writing
+// to the connection. It is also the largest body Writer will hold back to
+// work out a Content-Length for.
+const DefaultBufferSize = 4096
+
+// Flusher is implemented by writers that buffer output, so middleware that
+// wraps a Writer can pass flushes through.
+type Flusher interface {
+	Flush() error
+}
+
+// Aborter is implemented by transports that can cut a response off, so
+// the client does not mistake a truncated body for a complete one.
+type Aborter interface {
+	Abort()
+}
+
+// ErrAborted is returned by writes to a response that has been aborted.
+var ErrAborted = errors.New("response aborted")
+
+// framing is how the end of an HTTP/1.x response body is marked.
+type framing int
+
+const (
+	// framingPending holds the body back until it either completes, and
+	// gets a Content-Length, or outgrows the buffer.
+	framingPending framing = iota
+	framingFixed
+	framingChunked
+	// framingClose ends the body by closing the connection, for HTTP/1.0
+	// clients that cannot read chunked encoding.
+	framingClose
+	// framingNone is for responses that never carry a body, such as 1xx.
+	framingNone
+)
+
 type Writer struct {
 	writer io.Writer
+	buf    *bufio.Writer
+
+	// transport and statusCode are used instead of writer when the response
+	// is carried by something other than HTTP/1.1.
+	transport  Transport
+	statusCode StatusCode
+
+	// extra holds fields added through Header before the headers are written.
+	extra         headers.Headers
+	beforeHeaders []func()
+
+	http10 bool
+	head   bool
+	// discardBody drops body bytes while keeping the framing decisions, so
+	// a HEAD response carries the headers the GET response would have.
+	discardBody bool
+	headers     headers.Headers
+	headersSent bool
+	framing     framing
+	pending     []byte
+	bodyLimit   int
+	chunked     *ChunkedWriter
+	chunksDone  bool
+	aborted     bool
 }
 
+// NewWriter returns a Writer that buffers DefaultBufferSize bytes of output.
+// Call Flush to push buffered bytes out, and Finish once the response is
+// complete.
 func NewWriter(writer io.Writer) *Writer {
+	return NewWriterSize(writer, DefaultBufferSize)
+}
+
+func NewWriterSize(writer io.Writer, size int) *Writer {
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
 	return &Writer{
-		writer: writer,
+		writer:     writer,
+		buf:        bufio.NewWriterSize(writer, size),
+		bodyLimit:  size,
+		statusCode: OKStatus,
 	}
 }
 
+// SetRequest tells w which request it answers so it can frame the body in
+// a way the client understands.
+func (w *Writer) SetRequest(req *request.Request) {
+	w.http10 = req.RequestLine.HttpVersion == "1.0"
+	w.head = req.RequestLine.Method == "HEAD"
+}
+
+// Write sends body bytes. Handlers that did not declare a Content-Length
+// or chunked encoding can simply write their body: small bodies are held
+// back and sent with a Content-Length, larger ones switch to chunked
+// encoding, or to closing the connection for HTTP/1.0 clients.
 func (w *Writer) Write(p []byte) (int, error) {
-	return w.writer.Write(p)
+	if w.transport != nil {
+		return w.transport.WriteData(p)
+	}
+
+	if w.aborted {
+		return 0, ErrAborted
+	}
+
+	if w.headers == nil {
+		if err := w.WriteHeaders(implicitHeaders()); err != nil {
+			return 0, err
+		}
+	}
+
+	if w.discardBody && w.framing != framingPending {
+		return len(p), nil
+	}
+
+	switch w.framing {
+	case framingPending:
+		w.pending = append(w.pending, p...)
+		if len(w.pending) > w.bodyLimit {
+			if err := w.startStreaming(); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	case framingChunked:
+		if w.chunksDone {
+			return 0, ErrChunkedClosed
+		}
+		return w.chunked.Write(p)
+	default:
+		return w.buf.Write(p)
+	}
 }
 
-func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
-	_, err := w.Write([]byte(getStatusLine(statusCode) + "\r\n"))
+// Flush sends any buffered output to the client, for streaming responses
+// that need bytes delivered before the handler returns. A body still being
+// held back for a Content-Length switches to streaming.
+func (w *Writer) Flush() error {
+	if w.transport != nil {
+		if flusher, ok := w.transport.(Flusher); ok {
+			return flusher.Flush()
+		}
+		return nil
+	}
 
-	return err
+	if w.headers != nil && !w.headersSent {
+		if err := w.startStreaming(); err != nil {
+			return err
+		}
+	}
+
+	return w.buf.Flush()
 }
 
-func (w *Writer) WriteHeaders(headers headers.Headers) error {
-	for key, value := range headers {
-		_, err := fmt.Fprintf(w, "%v: %v\r\n", key, value)
+// Finish completes the response: a held back body is sent with its
+// Content-Length, an unterminated chunked body is ended and everything is
+// flushed. A handler that wrote nothing gets an empty 200. The server calls
+// Finish once the handler returns.
+func (w *Writer) Finish() error {
+	if w.transport != nil {
+		return nil
+	}
+
+	if w.aborted {
+		return ErrAborted
+	}
 
-		if err != nil {
+	if w.headers == nil {
+		if err := w.WriteHeaders(implicitHeaders()); err != nil {
 			return err
 		}
 	}
 
-	_, err := fmt.Fprintf(w, "\r\n")
+	if !w.headersSent {
+		w.headers.Overwrite("content-length", strconv.Itoa(len(w.pending)))
+		w.framing = framingFixed
+		if err := w.sendHead(); err != nil {
+			return err
+		}
+		if !w.discardBody {
+			if _, err := w.buf.Write(w.pending); err != nil {
+				return err
+			}
+		}
+		w.pending = nil
+	}
 
-	return err
+	if w.framing == framingChunked && !w.discardBody && !w.chunked.closed {
+		if err := w.chunked.Close(); err != nil {
+			return err
+		}
+	}
+
+	return w.buf.Flush()
+}
+
+// Abort gives up on the response part way through, such as when a proxied
+// body fails mid-stream. Nothing more is written: an unsent response is
+// dropped and a body already under way is left unterminated, so the
+// server's closing of the connection shows the client it is incomplete.
+// HTTP/2 streams are reset instead.
+func (w *Writer) Abort() {
+	if aborter, ok := w.transport.(Aborter); ok {
+		aborter.Abort()
+		return
+	}
+
+	if w.headersSent {
+		w.buf.Flush()
+	}
+
+	w.aborted = true
+}
+
+// Header returns fields that are added to the headers the handler writes,
+// so middleware can annotate a response before the handler runs. Fields the
+// handler sets itself win, except vary and set-cookie, where both are kept.
+func (w *Writer) Header() headers.Headers {
+	if w.extra == nil {
+		w.extra = headers.NewHeaders()
+	}
+	return w.extra
+}
+
+// OnWriteHeaders registers f to run just before the headers are written, so
+// middleware can add fields through Header that depend on what the handler
+// did.
+func (w *Writer) OnWriteHeaders(f func()) {
+	w.beforeHeaders = append(w.beforeHeaders, f)
+}
+
+func (w *Writer) WriteStatusLine(statusCode StatusCode) error {
+	w.statusCode = statusCode
+	return nil
+}
+
+// WriteHeaders sets the response headers. The body framing follows from
+// them: a content-length is trusted, transfer-encoding chunked or a trailer
+// declaration selects chunked encoding, and otherwise Writer decides once
+// it has seen how large the body is. Answers to HEAD keep their headers
+// but drop the body, and 204 and 304 responses never carry one.
+func (w *Writer) WriteHeaders(h headers.Headers) error {
+	if h == nil {
+		h = headers.NewHeaders()
+	}
+
+	hooks := w.beforeHeaders
+	w.beforeHeaders = nil
+	for _, hook := range hooks {
+		hook()
+	}
+	w.mergeExtra(h)
+
+	if w.transport != nil {
+		return w.transport.WriteHeaders(w.statusCode, h)
+	}
+
+	if w.headers != nil {
+		return errors.New("headers already written")
+	}
+
+	w.headers = h
+	w.discardBody = w.head
+
+	_, hasLength := h.Get("content-length")
+	encoding, _ := h.Get("transfer-encoding")
+	_, hasTrailer := h.Get("trailer")
+
+	switch {
+	case w.statusCode < 200:
+		w.framing = framingNone
+	case w.statusCode == NoContentStatus || w.statusCode == NotModifiedStatus:
+		w.framing = framingNone
+		w.discardBody = true
+		// A 304 may describe the selected representation's length, but
+		// neither may announce a body of its own.
+		if w.statusCode == NoContentStatus {
+			h.Delete("content-length")
+		}
+		h.Delete("transfer-encoding")
+		h.Delete("trailer")
+	case hasLength:
+		w.framing = framingFixed
+	case strings.EqualFold(encoding, "chunked") || hasTrailer:
+		return w.startStreaming()
+	default:
+		w.framing = framingPending
+		return nil
+	}
+
+	return w.sendHead()
 }
 
 func (w *Writer) WriteBody(body string) error {
-	_, err := fmt.Fprintf(w, "%s", body)
+	_, err := io.WriteString(w, body)
 
 	return err
 }
 
-func (w *Writer) WriteChunkedBody(p []byte) (int, error) {
-	dataLen := len(p)
-	dataLenHex := fmt.Sprintf("%x", dataLen)
+// WriteChunkedBody sends p as one chunk with the given extensions. Where
+// the body is not chunked, such as for HTTP/1.0 clients, p is written as is.
+func (w *Writer) WriteChunkedBody(p []byte, extensions ...ChunkExtension) (int, error) {
+	if w.transport != nil {
+		return w.transport.WriteData(p)
+	}
+
+	if w.aborted {
+		return 0, ErrAborted
+	}
+
+	if w.headers == nil {
+		if err := w.WriteHeaders(implicitHeaders()); err != nil {
+			return 0, err
+		}
+	}
 
-	output := fmt.Sprintf("%v\r\n%v\r\n", dataLenHex, string(p))
+	if !w.headersSent {
+		if err := w.startStreaming(); err != nil {
+			return 0, err
+		}
+	}
 
-	n, err := fmt.Fprintf(w, "%s", output)
+	if w.framing != framingChunked || w.discardBody {
+		return w.Write(p)
+	}
 
-	if err != nil {
-		return 0, err
+	if w.chunksDone {
+		return 0, ErrChunkedClosed
 	}
 
-	return n, nil
+	return w.chunked.WriteChunk(p, extensions...)
 }
 
+// WriteChunkedBodyDone marks the end of the chunks. The last chunk itself
+// is written along with the trailers, by WriteTrailers or Finish.
 func (w *Writer) WriteChunkedBodyDone() (int, error) {
-	n, err := w.Write([]byte("0\r\n"))
+	w.chunksDone = true
+
+	return 0, nil
+}
+
+// WriteTrailers ends a chunked body with trailers, each of which must have
+// been declared in the trailer header. Clients that cannot receive a
+// chunked body get no trailers.
+func (w *Writer) WriteTrailers(h headers.Headers) error {
+	if w.transport != nil {
+		return w.transport.WriteTrailers(h)
+	}
+
+	if w.aborted {
+		return ErrAborted
+	}
 
-	if err != nil {
-		return 0, err
+	if w.framing != framingChunked || w.discardBody {
+		return nil
 	}
 
-	return n, nil
+	w.chunksDone = true
+
+	return w.chunked.CloseWithTrailers(h)
 }
 
-func (w *Writer) WriteTrailers(h headers.Headers) error {
-	return w.WriteHeaders(h)
+// startStreaming commits to a body of unknown length: chunked encoding, or
+// a connection close for HTTP/1.0 clients. Anything held back so far is
+// sent as the first part of the body.
+func (w *Writer) startStreaming() error {
+	w.headers.Delete("content-length")
+
+	if w.http10 {
+		w.framing = framingClose
+		w.headers.Delete("transfer-encoding")
+		w.headers.Delete("trailer")
+		w.headers.Overwrite("connection", "close")
+	} else {
+		w.framing = framingChunked
+		w.headers.Overwrite("transfer-encoding", "chunked")
+		w.chunked = NewChunkedWriter(w.buf, w.headers)
+	}
+
+	if err := w.sendHead(); err != nil {
+		return err
+	}
+
+	pending := w.pending
+	w.pending = nil
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	_, err := w.Write(pending)
+
+	return err
+}
+
+func (w *Writer) sendHead() error {
+	w.headersSent = true
+
+	if _, err := w.buf.WriteString(getStatusLine(w.statusCode) + "\r\n"); err != nil {
+		return err
+	}
+
+	return w.writeFields(w.headers)
+}
+
+func (w *Writer) writeFields(h headers.Headers) error {
+	for key := range h {
+		for _, value := range h.Values(key) {
+			_, err := fmt.Fprintf(w.buf, "%v: %v\r\n", key, value)
+
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := w.buf.WriteString("\r\n")
+
+	return err
+}
+
+func (w *Writer) mergeExtra(h headers.Headers) {
+	for key, value := range w.extra {
+		current, ok := h.Get(key)
+		switch {
+		case !ok:
+			h.Overwrite(key, value)
+		case (key == "vary" || key == "set-cookie") && current != value:
+			h.Set(key, value)
+		}
+	}
+	w.extra = nil
+}
+
+// implicitHeaders are used when a handler writes a body without setting
+// any headers.
+func implicitHeaders() headers.Headers {
+	h := GetDefaultHeaders(0)
+	h.Delete("content-length")
+	return h
 }
@@ -0,0 +1,223 @@
+package response
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(version string) *request.Request {
+	return &request.Request{RequestLine: request.RequestLine{Method: "GET", RequestTarget: "/", HttpVersion: version}}
+}
+
+func TestFraming(t *testing.T) {
+	// Test: Small bodies get a computed Content-Length
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.WriteStatusLine(OKStatus)
+	h := GetDefaultHeaders(0)
+	h.Delete("content-length")
+	require.NoError(t, w.WriteHeaders(h))
+	_, err := io.Copy(w, strings.NewReader("hello world"))
+	require.NoError(t, err)
+	assert.Empty(t, buf.String())
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "content-length: 11\r\n")
+	assert.NotContains(t, buf.String(), "transfer-encoding")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nhello world"))
+
+	// Test: Writing without any headers implies a 200
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteBody("implicit")
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, buf.String(), "content-length: 8\r\n")
+
+	// Test: A handler that writes nothing still answers
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, buf.String(), "content-length: 0\r\n")
+
+	// Test: Bodies larger than the buffer switch to chunked encoding
+	buf.Reset()
+	w = NewWriterSize(&buf, 16)
+	large := strings.Repeat("x", 40)
+	_, err = io.Copy(w, strings.NewReader(large))
+	require.NoError(t, err)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, buf.String(), "content-length")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n0\r\n\r\n"))
+	assert.Equal(t, large, decodeChunked(t, buf.String()))
+
+	// Test: Flush commits to chunked encoding
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteBody("early")
+	require.NoError(t, w.Flush())
+	assert.Contains(t, buf.String(), "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "5\r\nearly\r\n"))
+
+	// Test: A declared Content-Length is used as is
+	buf.Reset()
+	w = NewWriter(&buf)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(3)))
+	w.WriteBody("abc")
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "content-length: 3\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\nabc"))
+
+	// Test: Declared trailers select chunked encoding and are terminated
+	buf.Reset()
+	w = NewWriter(&buf)
+	h = GetDefaultHeaders(0)
+	h.Delete("content-length")
+	h.Set("trailer", "x-checksum")
+	require.NoError(t, w.WriteHeaders(h))
+	w.WriteBody("data")
+	require.NoError(t, w.WriteTrailers(map[string]string{"x-checksum": "abc"}))
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasSuffix(buf.String(), "4\r\ndata\r\n0\r\nx-checksum: abc\r\n\r\n"))
+
+	// Test: HTTP/1.0 clients get a close-delimited body instead of chunks
+	buf.Reset()
+	w = NewWriterSize(&buf, 16)
+	w.SetRequest(newRequest("1.0"))
+	h = GetDefaultHeaders(0)
+	h.Delete("content-length")
+	h.Set("transfer-encoding", "chunked")
+	require.NoError(t, w.WriteHeaders(h))
+	w.WriteChunkedBody([]byte(large))
+	w.WriteChunkedBodyDone()
+	w.WriteTrailers(map[string]string{"x-checksum": "abc"})
+	require.NoError(t, w.Finish())
+	assert.NotContains(t, buf.String(), "transfer-encoding")
+	assert.NotContains(t, buf.String(), "x-checksum")
+	assert.Contains(t, buf.String(), "connection: close\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"+large))
+
+	// Test: HTTP/1.0 clients still get a Content-Length for small bodies
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetRequest(newRequest("1.0"))
+	w.WriteBody("small")
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "content-length: 5\r\n")
+
+	// Test: Informational responses get no framing headers
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteStatusLine(SwitchingProtocolsStatus)
+	require.NoError(t, w.WriteHeaders(map[string]string{"upgrade": "websocket"}))
+	require.NoError(t, w.Flush())
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\nupgrade: websocket\r\n\r\n", buf.String())
+}
+
+// decodeChunked returns the body of a chunked response.
+func decodeChunked(t *testing.T, raw string) string {
+	t.Helper()
+	_, body, found := strings.Cut(raw, "\r\n\r\n")
+	require.True(t, found)
+	var decoded strings.Builder
+	for {
+		sizeLine, rest, found := strings.Cut(body, "\r\n")
+		require.True(t, found)
+		var size int
+		_, err := fmt.Sscanf(sizeLine, "%x", &size)
+		require.NoError(t, err)
+		if size == 0 {
+			return decoded.String()
+		}
+		decoded.WriteString(rest[:size])
+		body = rest[size+2:]
+	}
+}
+
+func TestNoBody(t *testing.T) {
+	head := &request.Request{RequestLine: request.RequestLine{Method: "HEAD", RequestTarget: "/", HttpVersion: "1.1"}}
+
+	// Test: HEAD keeps the Content-Length a GET would get but sends no body
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	w.SetRequest(head)
+	w.WriteBody("hello world")
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "content-length: 11\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	assert.NotContains(t, buf.String(), "hello")
+
+	// Test: HEAD with a declared length
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.SetRequest(head)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(5)))
+	w.WriteBody("hello")
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "content-length: 5\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+
+	// Test: HEAD of a chunked response sends no chunks or last chunk
+	buf.Reset()
+	w = NewWriterSize(&buf, 16)
+	w.SetRequest(head)
+	w.WriteBody(strings.Repeat("x", 40))
+	w.WriteChunkedBody([]byte("more"))
+	w.WriteTrailers(nil)
+	require.NoError(t, w.Finish())
+	assert.Contains(t, buf.String(), "transfer-encoding: chunked\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+	assert.NotContains(t, buf.String(), "xxxx")
+	assert.NotContains(t, buf.String(), "0\r\n\r\n")
+
+	// Test: 204 drops the body and any framing headers
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteStatusLine(NoContentStatus)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(7)))
+	w.WriteBody("ignored")
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 204 No Content\r\n"))
+	assert.NotContains(t, buf.String(), "content-length")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+
+	// Test: 304 keeps a declared length but sends no body
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteStatusLine(NotModifiedStatus)
+	require.NoError(t, w.WriteHeaders(GetDefaultHeaders(7)))
+	w.WriteBody("ignored")
+	require.NoError(t, w.Finish())
+	assert.True(t, strings.HasPrefix(buf.String(), "HTTP/1.1 304 Not Modified\r\n"))
+	assert.Contains(t, buf.String(), "content-length: 7\r\n")
+	assert.True(t, strings.HasSuffix(buf.String(), "\r\n\r\n"))
+}
+
+func TestAbort(t *testing.T) {
+	// Test: A body under way is flushed but never terminated
+	var buf bytes.Buffer
+	w := NewWriterSize(&buf, 16)
+	w.WriteBody(strings.Repeat("x", 40))
+	w.Abort()
+	assert.ErrorIs(t, w.WriteBody("more"), ErrAborted)
+	assert.ErrorIs(t, w.Finish(), ErrAborted)
+	assert.Contains(t, buf.String(), "transfer-encoding: chunked\r\n")
+	assert.Contains(t, buf.String(), "xxxx")
+	assert.NotContains(t, buf.String(), "0\r\n\r\n")
+
+	// Test: A response still held back is dropped entirely
+	buf.Reset()
+	w = NewWriter(&buf)
+	w.WriteBody("partial")
+	w.Abort()
+	assert.ErrorIs(t, w.Finish(), ErrAborted)
+	assert.Empty(t, buf.String())
+}
@@ -0,0 +1,20 @@
+package response
+
+import "github.com/allscorpion/build-http-from-scratch/internal/headers"
+
+// Transport carries a response over a protocol other than HTTP/1.1, such as
+// an HTTP/2 stream. Writer turns its calls into status, header, data and
+// trailer events instead of raw HTTP/1.1 bytes.
+type Transport interface {
+	WriteHeaders(statusCode StatusCode, h headers.Headers) error
+	WriteData(p []byte) (int, error)
+	WriteTrailers(h headers.Headers) error
+}
+
+// NewTransportWriter returns a Writer that sends the response through t.
+func NewTransportWriter(t Transport) *Writer {
+	return &Writer{
+		transport:  t,
+		statusCode: OKStatus,
+	}
+}
@@ -0,0 +1,291 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"log"
+	"net"
+	"os"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/http2"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+)
+
+// Config holds everything that can be tuned on a Server. The zero value
+// serves plaintext over TCP with no timeouts or limits.
+type Config struct {
+	// Addr is the address to bind. For tcp networks it is a host:port such
+	// as ":42069" or "127.0.0.1:8080", for unix it is a socket path.
+	Addr string
+	// Network is passed to net.Listen: "tcp" (the default), "tcp4", "tcp6"
+	// or "unix".
+	Network string
+	// SocketMode sets the permissions of the socket file when Network is
+	// "unix". Zero leaves the mode to the process umask.
+	SocketMode os.FileMode
+	// TLSConfig, when set, terminates TLS on every accepted connection.
+	TLSConfig *tls.Config
+	// ClientCAs, when set together with TLSConfig, is the pool client
+	// certificates are verified against.
+	ClientCAs *x509.CertPool
+	// ClientAuth controls whether client certificates are requested and
+	// required. It defaults to tls.RequireAndVerifyClientCert when ClientCAs
+	// is set.
+	ClientAuth tls.ClientAuthType
+	// EnableHTTP2 serves HTTP/2 alongside HTTP/1.1: negotiated with ALPN
+	// over TLS, and in cleartext for clients that open with the HTTP/2
+	// preface or upgrade with h2c.
+	EnableHTTP2 bool
+
+	// ReadHeaderTimeout is how long a client has to send the request line
+	// and headers once it starts a request. Clients that are too slow get a
+	// 408 Request Timeout. Zero falls back to ReadTimeout.
+	ReadHeaderTimeout time.Duration
+	// ReadTimeout bounds reading the whole request, including the body.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds how long the handler has to write the response
+	// once the request has been read.
+	WriteTimeout time.Duration
+	// IdleTimeout is how long a connection may stay open without sending
+	// any bytes of a request. Zero falls back to ReadTimeout.
+	IdleTimeout time.Duration
+	// RequestTimeout, when non-zero, bounds how long a handler's request
+	// context stays valid.
+	RequestTimeout time.Duration
+
+	// MaxHeaderBytes limits the size of the request line and headers.
+	// Larger requests get a 431. Zero means no limit.
+	MaxHeaderBytes int
+	// MaxBodyBytes limits the declared Content-Length of a request. Larger
+	// requests get a 413. Zero means no limit, except on HTTP/2 where
+	// bodies are buffered and default to 10 MiB.
+	MaxBodyBytes int
+	// StreamBody picks HTTP/1 requests whose body is left for the handler to
+	// read through Request.BodyReader instead of being read into Body before
+	// the handler runs. ReadTimeout still bounds reading it, and the server
+	// only notices the client going away once it has been read in full. A
+	// handler that hijacks the connection must read the body first.
+	StreamBody func(req *request.Request) bool
+
+	// MaxConns limits how many connections are served at once. Hijacked
+	// connections stop counting once their handler returns. Zero means no
+	// limit.
+	MaxConns int
+	// MaxConnsPerIP limits how many connections a single client IP may
+	// hold open. Connections over it always get a 503 Service Unavailable,
+	// since queueing them would still tie up the server for that client.
+	// Zero means no limit.
+	MaxConnsPerIP int
+	// RejectOverCapacity answers connections beyond MaxConns with a 503
+	// Service Unavailable. By default the server stops accepting until a
+	// connection closes, leaving new ones queued in the listen backlog.
+	RejectOverCapacity bool
+
+	// WriteBufferSize is how many bytes of a response are buffered before
+	// they are written to the connection. Defaults to
+	// response.DefaultBufferSize.
+	WriteBufferSize int
+
+	// Logger receives the server's diagnostic messages. Defaults to
+	// log.Default().
+	Logger *log.Logger
+	// ErrorHandler writes the response for requests that could not be read.
+	// Defaults to a plain text body carrying the error message.
+	ErrorHandler ErrorHandler
+	// ConnState, if set, is called whenever a connection changes state.
+	ConnState func(net.Conn, ConnState)
+}
+
+type ErrorHandler func(w *response.Writer, err *HandlerError)
+
+type Option func(*Config)
+
+// WithConfig replaces the whole configuration. Options after it still apply
+// on top.
+func WithConfig(config Config) Option {
+	return func(c *Config) {
+		*c = config
+	}
+}
+
+func WithAddr(addr string) Option {
+	return func(c *Config) {
+		c.Addr = addr
+	}
+}
+
+func WithNetwork(network string) Option {
+	return func(c *Config) {
+		c.Network = network
+	}
+}
+
+func WithSocketMode(mode os.FileMode) Option {
+	return func(c *Config) {
+		c.SocketMode = mode
+	}
+}
+
+func WithTLSConfig(config *tls.Config) Option {
+	return func(c *Config) {
+		c.TLSConfig = config
+	}
+}
+
+// WithClientCAs turns on client certificate authentication against pool.
+func WithClientCAs(pool *x509.CertPool, auth tls.ClientAuthType) Option {
+	return func(c *Config) {
+		c.ClientCAs = pool
+		c.ClientAuth = auth
+	}
+}
+
+func WithHTTP2() Option {
+	return func(c *Config) {
+		c.EnableHTTP2 = true
+	}
+}
+
+func WithReadHeaderTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.ReadHeaderTimeout = timeout
+	}
+}
+
+func WithReadTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.ReadTimeout = timeout
+	}
+}
+
+func WithWriteTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.WriteTimeout = timeout
+	}
+}
+
+func WithIdleTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.IdleTimeout = timeout
+	}
+}
+
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *Config) {
+		c.RequestTimeout = timeout
+	}
+}
+
+func WithMaxHeaderBytes(n int) Option {
+	return func(c *Config) {
+		c.MaxHeaderBytes = n
+	}
+}
+
+func WithMaxBodyBytes(n int) Option {
+	return func(c *Config) {
+		c.MaxBodyBytes = n
+	}
+}
+
+func WithStreamBody(match func(req *request.Request) bool) Option {
+	return func(c *Config) {
+		c.StreamBody = match
+	}
+}
+
+func WithMaxConns(n int) Option {
+	return func(c *Config) {
+		c.MaxConns = n
+	}
+}
+
+func WithMaxConnsPerIP(n int) Option {
+	return func(c *Config) {
+		c.MaxConnsPerIP = n
+	}
+}
+
+func WithRejectOverCapacity() Option {
+	return func(c *Config) {
+		c.RejectOverCapacity = true
+	}
+}
+
+func WithWriteBufferSize(size int) Option {
+	return func(c *Config) {
+		c.WriteBufferSize = size
+	}
+}
+
+func WithLogger(logger *log.Logger) Option {
+	return func(c *Config) {
+		c.Logger = logger
+	}
+}
+
+func WithErrorHandler(handler ErrorHandler) Option {
+	return func(c *Config) {
+		c.ErrorHandler = handler
+	}
+}
+
+func WithConnState(hook func(net.Conn, ConnState)) Option {
+	return func(c *Config) {
+		c.ConnState = hook
+	}
+}
+
+func (c *Config) network() string {
+	if c.Network == "" {
+		return "tcp"
+	}
+	return c.Network
+}
+
+// tlsConfig returns TLSConfig with the client authentication and ALPN
+// settings applied, leaving the caller's config untouched.
+func (c *Config) tlsConfig() *tls.Config {
+	if c.TLSConfig == nil {
+		return nil
+	}
+
+	config := c.TLSConfig.Clone()
+
+	if c.ClientCAs != nil {
+		config.ClientCAs = c.ClientCAs
+		config.ClientAuth = c.ClientAuth
+		if config.ClientAuth == tls.NoClientCert {
+			config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+
+	if c.EnableHTTP2 && len(config.NextProtos) == 0 {
+		config.NextProtos = []string{http2.NextProto, "http/1.1"}
+	}
+
+	return config
+}
+
+func (c *Config) logger() *log.Logger {
+	if c.Logger == nil {
+		return log.Default()
+	}
+	return c.Logger
+}
+
+func (c *Config) readHeaderTimeout() time.Duration {
+	if c.ReadHeaderTimeout != 0 {
+		return c.ReadHeaderTimeout
+	}
+	return c.ReadTimeout
+}
+
+func (c *Config) idleTimeout() time.Duration {
+	if c.IdleTimeout != 0 {
+		return c.IdleTimeout
+	}
+	return c.ReadTimeout
+}
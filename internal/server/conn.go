@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bufio"
+	"net"
+	"sync/atomic"
+)
+
+type ConnState int32
+
+const (
+	// StateIdle is a connection that has not sent any bytes of a request
+	// yet. Shutdown closes these straight away.
+	StateIdle ConnState = iota
+	// StateActive is a connection that is sending a request or waiting on
+	// its handler. Shutdown waits for these to finish.
+	StateActive
+	// StateClosed is a connection the server has finished with.
+	StateClosed
+	// StateHijacked is a connection a handler has taken over. The server
+	// no longer tracks, times out or closes it.
+	StateHijacked
+)
+
+func (c ConnState) String() string {
+	switch c {
+	case StateIdle:
+		return "idle"
+	case StateActive:
+		return "active"
+	case StateClosed:
+		return "closed"
+	case StateHijacked:
+		return "hijacked"
+	default:
+		return "unknown"
+	}
+}
+
+type trackedConn struct {
+	net.Conn
+	state atomic.Int32
+	// onActive, if set, is called from Read when the first bytes of a
+	// request arrive.
+	onActive func()
+	// hijack, if set, hands the connection over to a handler.
+	hijack   func() (net.Conn, *bufio.ReadWriter, error)
+	hijacked atomic.Bool
+	// ip is the client address counted against MaxConnsPerIP, if any.
+	ip string
+}
+
+func (c *trackedConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && c.state.CompareAndSwap(int32(StateIdle), int32(StateActive)) && c.onActive != nil {
+		c.onActive()
+	}
+	return n, err
+}
+
+func (c *trackedConn) getState() ConnState {
+	return ConnState(c.state.Load())
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+)
+
+// CORSConfig describes which cross origin requests browsers may make.
+type CORSConfig struct {
+	// AllowedOrigins are exact origins such as "https://example.com", or
+	// patterns with a single "*" such as "https://*.example.com". A lone
+	// "*" allows every origin.
+	AllowedOrigins []string
+	// AllowedOriginPatterns are matched against the whole origin.
+	AllowedOriginPatterns []*regexp.Regexp
+	// AllowedMethods defaults to GET, HEAD and POST.
+	AllowedMethods []string
+	// AllowedHeaders are request headers a preflight may ask for. "*"
+	// allows any.
+	AllowedHeaders []string
+	// ExposedHeaders are response headers scripts may read.
+	ExposedHeaders []string
+	// AllowCredentials lets the origins allowed by name or pattern make
+	// credentialed requests. Origins allowed only by a lone "*" never get
+	// credentials, since that would let every site make them.
+	AllowCredentials bool
+	// MaxAge is how long browsers may cache a preflight result. Zero leaves
+	// it to the browser.
+	MaxAge time.Duration
+}
+
+var defaultCORSMethods = []string{"GET", "HEAD", "POST"}
+
+// matchOrigin reports whether origin is allowed and whether credentials
+// may be allowed for it.
+func (c CORSConfig) matchOrigin(origin string) (allowed bool, credentials bool) {
+	for _, allowed := range c.AllowedOrigins {
+		if allowed != "*" && strings.EqualFold(allowed, origin) {
+			return true, c.AllowCredentials
+		}
+		if prefix, suffix, ok := strings.Cut(allowed, "*"); ok && allowed != "*" {
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(strings.ToLower(origin), strings.ToLower(prefix)) &&
+				strings.HasSuffix(strings.ToLower(origin), strings.ToLower(suffix)) {
+				return true, c.AllowCredentials
+			}
+		}
+	}
+
+	for _, pattern := range c.AllowedOriginPatterns {
+		if loc := pattern.FindStringIndex(origin); loc != nil && loc[0] == 0 && loc[1] == len(origin) {
+			return true, c.AllowCredentials
+		}
+	}
+
+	return slices.Contains(c.AllowedOrigins, "*"), false
+}
+
+func (c CORSConfig) methods() []string {
+	if len(c.AllowedMethods) == 0 {
+		return defaultCORSMethods
+	}
+	return c.AllowedMethods
+}
+
+func (c CORSConfig) allowsMethod(method string) bool {
+	return slices.ContainsFunc(c.methods(), func(allowed string) bool {
+		return strings.EqualFold(allowed, method)
+	})
+}
+
+func (c CORSConfig) allowsHeaders(requested []string) bool {
+	if slices.Contains(c.AllowedHeaders, "*") {
+		return true
+	}
+
+	for _, name := range requested {
+		if !slices.ContainsFunc(c.AllowedHeaders, func(allowed string) bool {
+			return strings.EqualFold(allowed, name)
+		}) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// allowOrigin is the Access-Control-Allow-Origin value for origin. Browsers
+// reject "*" on credentialed requests, so the origin is echoed back then.
+func (c CORSConfig) allowOrigin(origin string, credentials bool) string {
+	if slices.Contains(c.AllowedOrigins, "*") && !credentials {
+		return "*"
+	}
+	return origin
+}
+
+// CORS answers preflight requests from allowed origins itself and adds the
+// Access-Control headers to the responses of actual cross origin requests.
+// Requests from other origins are passed on without CORS headers, which
+// browsers treat as a refusal.
+func CORS(config CORSConfig) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			origin, hasOrigin := req.Headers.Get("origin")
+			requestMethod, isPreflight := req.Headers.Get("access-control-request-method")
+			isPreflight = isPreflight && hasOrigin && req.RequestLine.Method == "OPTIONS"
+
+			if isPreflight {
+				writePreflight(w, req, config, origin, requestMethod)
+				return
+			}
+
+			w.Header().Set("vary", "Origin")
+			if allowed, credentials := config.matchOrigin(origin); hasOrigin && allowed {
+				h := w.Header()
+				h.Set("access-control-allow-origin", config.allowOrigin(origin, credentials))
+				if credentials {
+					h.Set("access-control-allow-credentials", "true")
+				}
+				if len(config.ExposedHeaders) > 0 {
+					h.Set("access-control-expose-headers", strings.Join(config.ExposedHeaders, ", "))
+				}
+			}
+
+			next(w, req)
+		}
+	}
+}
+
+func writePreflight(w *response.Writer, req *request.Request, config CORSConfig, origin string, method string) {
+	h := headers.NewHeaders()
+	h.Set("vary", "Origin, Access-Control-Request-Method, Access-Control-Request-Headers")
+
+	requested := splitList(req.Headers["access-control-request-headers"])
+
+	allowed, credentials := config.matchOrigin(origin)
+
+	if allowed && config.allowsMethod(method) && config.allowsHeaders(requested) {
+		h.Set("access-control-allow-origin", config.allowOrigin(origin, credentials))
+		h.Set("access-control-allow-methods", strings.Join(config.methods(), ", "))
+		if len(requested) > 0 {
+			allowHeaders := strings.Join(config.AllowedHeaders, ", ")
+			if slices.Contains(config.AllowedHeaders, "*") {
+				// "*" is not a wildcard on credentialed requests, so name
+				// the requested headers instead.
+				allowHeaders = strings.Join(requested, ", ")
+			}
+			h.Set("access-control-allow-headers", allowHeaders)
+		}
+		if credentials {
+			h.Set("access-control-allow-credentials", "true")
+		}
+		if config.MaxAge > 0 {
+			h.Set("access-control-max-age", strconv.Itoa(int(config.MaxAge.Seconds())))
+		}
+	}
+
+	w.WriteStatusLine(response.NoContentStatus)
+	w.WriteHeaders(h)
+}
+
+func splitList(value string) []string {
+	var items []string
+	for item := range strings.SplitSeq(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
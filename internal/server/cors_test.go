@@ -0,0 +1,129 @@
+package server
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCORS(t *testing.T) {
+	handler := Chain(func(w *response.Writer, req *request.Request) {
+		body := req.RequestLine.Method + " " + req.RequestLine.RequestTarget
+		h := response.GetDefaultHeaders(len(body))
+		h.Set("vary", "Accept-Encoding")
+		w.WriteStatusLine(response.OKStatus)
+		w.WriteHeaders(h)
+		w.WriteBody(body)
+	}, CORS(CORSConfig{
+		AllowedOrigins:        []string{"https://app.example.com", "https://*.preview.example.com"},
+		AllowedOriginPatterns: []*regexp.Regexp{regexp.MustCompile(`http://localhost:\d+`)},
+		AllowedMethods:        []string{"GET", "POST", "DELETE"},
+		AllowedHeaders:        []string{"Content-Type", "X-Request-Id"},
+		ExposedHeaders:        []string{"X-Total-Count"},
+		AllowCredentials:      true,
+		MaxAge:                10 * time.Minute,
+	}))
+
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.Listener.Addr().String()
+
+	send := func(raw string) string {
+		return roundTripRequest(t, "tcp", addr, raw)
+	}
+
+	// Test: Preflight from an allowed origin is answered without the handler
+	resp := send("OPTIONS /items HTTP/1.1\r\nHost: localhost\r\nOrigin: https://app.example.com\r\n" +
+		"Access-Control-Request-Method: DELETE\r\nAccess-Control-Request-Headers: content-type, x-request-id\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 204 No Content\r\n"))
+	assert.Contains(t, resp, "access-control-allow-origin: https://app.example.com\r\n")
+	assert.Contains(t, resp, "access-control-allow-methods: GET, POST, DELETE\r\n")
+	assert.Contains(t, resp, "access-control-allow-headers: Content-Type, X-Request-Id\r\n")
+	assert.Contains(t, resp, "access-control-allow-credentials: true\r\n")
+	assert.Contains(t, resp, "access-control-max-age: 600\r\n")
+	assert.NotContains(t, resp, "OPTIONS /items")
+
+	// Test: Preflight for a method that is not allowed gets no CORS headers
+	resp = send("OPTIONS /items HTTP/1.1\r\nHost: localhost\r\nOrigin: https://app.example.com\r\n" +
+		"Access-Control-Request-Method: PUT\r\n\r\n")
+	assert.NotContains(t, resp, "access-control-allow-origin")
+
+	// Test: Preflight asking for a header that is not allowed
+	resp = send("OPTIONS /items HTTP/1.1\r\nHost: localhost\r\nOrigin: https://app.example.com\r\n" +
+		"Access-Control-Request-Method: GET\r\nAccess-Control-Request-Headers: x-secret\r\n\r\n")
+	assert.NotContains(t, resp, "access-control-allow-origin")
+
+	// Test: Wildcard and regex origins
+	resp = send("OPTIONS /items HTTP/1.1\r\nHost: localhost\r\nOrigin: https://pr-12.preview.example.com\r\n" +
+		"Access-Control-Request-Method: GET\r\n\r\n")
+	assert.Contains(t, resp, "access-control-allow-origin: https://pr-12.preview.example.com\r\n")
+	resp = send("GET /items HTTP/1.1\r\nHost: localhost\r\nOrigin: http://localhost:3000\r\n\r\n")
+	assert.Contains(t, resp, "access-control-allow-origin: http://localhost:3000\r\n")
+	resp = send("GET /items HTTP/1.1\r\nHost: localhost\r\nOrigin: http://localhost:3000.evil.test\r\n\r\n")
+	assert.NotContains(t, resp, "access-control-allow-origin")
+	resp = send("GET /items HTTP/1.1\r\nHost: localhost\r\nOrigin: https://preview.example.com.evil.test\r\n\r\n")
+	assert.NotContains(t, resp, "access-control-allow-origin")
+
+	// Test: Actual requests are annotated and still reach the handler
+	resp = send("GET /items HTTP/1.1\r\nHost: localhost\r\nOrigin: https://app.example.com\r\n\r\n")
+	assert.Contains(t, resp, "access-control-allow-origin: https://app.example.com\r\n")
+	assert.Contains(t, resp, "access-control-allow-credentials: true\r\n")
+	assert.Contains(t, resp, "access-control-expose-headers: X-Total-Count\r\n")
+	assert.Contains(t, resp, "vary: Accept-Encoding, Origin\r\n")
+	assert.True(t, strings.HasSuffix(resp, "GET /items"))
+
+	// Test: Other origins reach the handler without CORS headers
+	resp = send("GET /items HTTP/1.1\r\nHost: localhost\r\nOrigin: https://evil.test\r\n\r\n")
+	assert.NotContains(t, resp, "access-control-allow-origin")
+	assert.True(t, strings.HasSuffix(resp, "GET /items"))
+
+	// Test: Plain OPTIONS requests are left to the handler
+	resp = send("OPTIONS /items HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasSuffix(resp, "OPTIONS /items"))
+
+	// Test: "OPTIONS *" is answered by the server
+	resp = send("OPTIONS * HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "allow: GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS\r\n")
+	assert.Contains(t, resp, "content-length: 0\r\n")
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	config := CORSConfig{AllowedOrigins: []string{"*"}, AllowedHeaders: []string{"*"}}
+
+	// Test: Any origin gets "*" without credentials
+	allowed, credentials := config.matchOrigin("https://anything.test")
+	assert.True(t, allowed)
+	assert.False(t, credentials)
+	assert.Equal(t, "*", config.allowOrigin("https://anything.test", credentials))
+	assert.True(t, config.allowsHeaders([]string{"x-anything"}))
+
+	// Test: A lone "*" never allows credentials, even with AllowCredentials
+	config.AllowCredentials = true
+	config.AllowedOrigins = []string{"*", "https://app.example.com"}
+	s, err := Serve(0, Chain(okHandler, CORS(config)))
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.Listener.Addr().String()
+
+	resp := roundTripRequest(t, "tcp", addr, "GET / HTTP/1.1\r\nHost: localhost\r\nOrigin: https://evil.test\r\n\r\n")
+	assert.Contains(t, resp, "access-control-allow-origin: *\r\n")
+	assert.NotContains(t, resp, "access-control-allow-credentials")
+	assert.NotContains(t, resp, "https://evil.test")
+
+	resp = roundTripRequest(t, "tcp", addr, "OPTIONS / HTTP/1.1\r\nHost: localhost\r\nOrigin: https://evil.test\r\nAccess-Control-Request-Method: GET\r\n\r\n")
+	assert.Contains(t, resp, "access-control-allow-origin: *\r\n")
+	assert.NotContains(t, resp, "access-control-allow-credentials")
+
+	// Test: Origins allowed by name still get credentials
+	resp = roundTripRequest(t, "tcp", addr, "GET / HTTP/1.1\r\nHost: localhost\r\nOrigin: https://app.example.com\r\n\r\n")
+	assert.Contains(t, resp, "access-control-allow-origin: https://app.example.com\r\n")
+	assert.Contains(t, resp, "access-control-allow-credentials: true\r\n")
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+)
+
+// maxWatchedBytes bounds how much early client data connWatcher keeps for a
+// handler that may hijack the connection later.
+const maxWatchedBytes = 64 << 10
+
+// connWatcher keeps reading from conn after the request has been parsed and
+// calls cancel once the client goes away. Bytes the client sends meanwhile
+// are held on to in case the connection is hijacked. Once maxWatchedBytes
+// are held it stops reading, leaving the rest in the connection rather than
+// dropping it, and no longer notices the client going away.
+type connWatcher struct {
+	conn     net.Conn
+	cancel   context.CancelFunc
+	started  atomic.Bool
+	stopping atomic.Bool
+	done     chan struct{}
+	read     []byte
+}
+
+func newConnWatcher(conn net.Conn, cancel context.CancelFunc) *connWatcher {
+	return &connWatcher{conn: conn, cancel: cancel, done: make(chan struct{})}
+}
+
+// start begins watching. It does nothing if the watcher has already started
+// or been stopped.
+func (w *connWatcher) start() {
+	if w.started.CompareAndSwap(false, true) {
+		go w.watch()
+	}
+}
+
+func (w *connWatcher) watch() {
+	defer close(w.done)
+
+	buffer := make([]byte, 512)
+	for {
+		room := maxWatchedBytes - len(w.read)
+
+		if room <= 0 {
+			return
+		}
+
+		n, err := w.conn.Read(buffer[:min(len(buffer), room)])
+		w.read = append(w.read, buffer[:n]...)
+
+		if err != nil {
+			if !w.stopping.Load() {
+				w.cancel()
+			}
+			return
+		}
+	}
+}
+
+// stop ends watching without cancelling and returns what the client sent
+// in the meantime.
+func (w *connWatcher) stop() []byte {
+	w.stopping.Store(true)
+	if w.started.CompareAndSwap(false, true) {
+		close(w.done)
+		return nil
+	}
+	w.conn.SetReadDeadline(time.Unix(1, 0))
+	<-w.done
+	w.conn.SetReadDeadline(time.Time{})
+	return w.read
+}
+
+func (c *trackedConn) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if c.hijack == nil {
+		return nil, nil, response.ErrNotHijackable
+	}
+	return c.hijack()
+}
+
+// hijacker returns the function behind trackedConn.Hijack for a request
+// that has been read from reader. The connection stops being tracked, loses
+// its deadlines and is left open when the handler returns.
+func (s *Server) hijacker(conn *trackedConn, reader io.Reader, req *request.Request, watcher *connWatcher) func() (net.Conn, *bufio.ReadWriter, error) {
+	return func() (net.Conn, *bufio.ReadWriter, error) {
+		if !conn.hijacked.CompareAndSwap(false, true) {
+			return nil, nil, response.ErrHijacked
+		}
+
+		var early bytes.Buffer
+		early.Write(req.Buffered())
+		if buffered, ok := reader.(*bufio.Reader); ok {
+			pending, _ := buffered.Peek(buffered.Buffered())
+			early.Write(pending)
+		}
+		early.Write(watcher.stop())
+
+		conn.SetDeadline(time.Time{})
+		s.trackConn(conn, false)
+		s.setState(conn, StateHijacked)
+
+		rw := bufio.NewReadWriter(
+			bufio.NewReader(io.MultiReader(&early, conn.Conn)),
+			bufio.NewWriter(conn.Conn),
+		)
+
+		return conn.Conn, rw, nil
+	}
+}
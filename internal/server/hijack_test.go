@@ -0,0 +1,147 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHijack(t *testing.T) {
+	var mu sync.Mutex
+	states := []ConnState{}
+	secondErr := make(chan error, 1)
+
+	// The handler turns the connection into a line based echo service after
+	// the request, uppercasing nothing and answering until the client quits.
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, rw, err := w.Hijack()
+		if err != nil {
+			return
+		}
+		_, _, err = w.Hijack()
+		secondErr <- err
+
+		go func() {
+			defer conn.Close()
+			rw.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n")
+			rw.Flush()
+			for {
+				line, err := rw.ReadString('\n')
+				if err != nil || line == "quit\n" {
+					return
+				}
+				rw.WriteString("echo: " + line)
+				rw.Flush()
+			}
+		}()
+	}, WithWriteTimeout(50*time.Millisecond), WithReadTimeout(50*time.Millisecond), WithConnState(func(_ net.Conn, state ConnState) {
+		mu.Lock()
+		defer mu.Unlock()
+		states = append(states, state)
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Bytes sent along with the request reach the hijacker
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write([]byte("CONNECT /tunnel HTTP/1.1\r\nHost: localhost\r\n\r\nfirst\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	line, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 200 Connection Established\r\n", line)
+	reader.ReadString('\n')
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: first\n", line)
+
+	// Test: A connection can only be hijacked once
+	assert.ErrorIs(t, <-secondErr, response.ErrHijacked)
+
+	// Test: Server timeouts no longer apply
+	time.Sleep(150 * time.Millisecond)
+	_, err = conn.Write([]byte("after the timeouts\n"))
+	require.NoError(t, err)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: after the timeouts\n", line)
+
+	// Test: Reported as hijacked and ignored by Shutdown
+	mu.Lock()
+	assert.Equal(t, []ConnState{StateIdle, StateActive, StateHijacked}, states)
+	mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+
+	_, err = conn.Write([]byte("still here\n"))
+	require.NoError(t, err)
+	line, err = reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "echo: still here\n", line)
+
+	// Test: HTTP/2 streams cannot be hijacked
+	w := response.NewTransportWriter(nil)
+	_, _, err = w.Hijack()
+	assert.ErrorIs(t, err, response.ErrNotHijackable)
+}
+
+func TestHijackEarlyDataOverWatchLimit(t *testing.T) {
+	payload := make([]byte, maxWatchedBytes+10_000)
+	for i := range payload {
+		payload[i] = byte(i % 251)
+	}
+	sent := make(chan struct{})
+	received := make(chan []byte, 1)
+
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		<-sent
+		// Give the watcher time to fill up before taking over.
+		time.Sleep(50 * time.Millisecond)
+		conn, rw, err := w.Hijack()
+		if err != nil {
+			received <- nil
+			return
+		}
+		defer conn.Close()
+		got := make([]byte, len(payload))
+		_, err = io.ReadFull(rw, got)
+		if err != nil {
+			got = nil
+		}
+		received <- got
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write(append([]byte("CONNECT /tunnel HTTP/1.1\r\nHost: localhost\r\n\r\n"), payload...))
+	require.NoError(t, err)
+	close(sent)
+
+	// Test: Early data past the watcher's limit is left for the hijacker, not dropped
+	select {
+	case got := <-received:
+		assert.True(t, bytes.Equal(payload, got), "hijacked stream was corrupted")
+	case <-time.After(2 * time.Second):
+		t.Fatal("handler did not finish")
+	}
+}
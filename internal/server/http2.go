@@ -0,0 +1,135 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/http2"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+)
+
+// negotiateHTTP2 works out whether conn speaks HTTP/2, either through ALPN
+// on TLS or by opening with the client preface in cleartext. When it does
+// not, the returned reader holds the bytes already inspected and must be
+// used to read the HTTP/1.1 request.
+func (s *Server) negotiateHTTP2(conn *trackedConn) (bool, io.Reader) {
+	if tlsConn, ok := conn.Conn.(*tls.Conn); ok {
+		if err := tlsConn.Handshake(); err != nil {
+			return false, conn
+		}
+		return tlsConn.ConnectionState().NegotiatedProtocol == http2.NextProto, conn
+	}
+
+	reader := bufio.NewReaderSize(conn, len(http2.ClientPreface))
+
+	for n := 1; n <= len(http2.ClientPreface); n++ {
+		peeked, err := reader.Peek(n)
+
+		if err != nil || peeked[n-1] != http2.ClientPreface[n-1] {
+			return false, reader
+		}
+	}
+
+	return true, reader
+}
+
+// serveHTTP2 hands conn over to the HTTP/2 implementation. upgrade is the
+// request that switched a cleartext connection over with h2c, if any.
+func (s *Server) serveHTTP2(conn *trackedConn, reader io.Reader, upgrade *request.Request, settings []byte) {
+	// A TLS handshake does not read through conn, so the first frame would
+	// still fire onActive and arm ReadHeaderTimeout with nothing to clear
+	// it. HTTP/2 manages its own timeouts, so only the state is reported.
+	conn.onActive = func() {
+		if s.ConnState != nil {
+			s.ConnState(conn.Conn, StateActive)
+		}
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	opts := http2.Options{
+		BaseContext:     s.baseCtx,
+		Shutdown:        s.shuttingDown,
+		IdleTimeout:     s.idleTimeout(),
+		MaxHeaderBytes:  s.MaxHeaderBytes,
+		MaxBodyBytes:    s.MaxBodyBytes,
+		Upgrade:         upgrade,
+		UpgradeSettings: settings,
+	}
+
+	if tlsConn, ok := conn.Conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		opts.TLS = &state
+	}
+
+	handler := s.serve
+	if s.RequestTimeout > 0 {
+		handler = func(w *response.Writer, req *request.Request) {
+			ctx, cancel := context.WithTimeout(req.Context(), s.RequestTimeout)
+			defer cancel()
+			s.serve(w, req.WithContext(ctx))
+		}
+	}
+
+	if err := http2.ServeConn(conn, reader, http2.Handler(handler), opts); err != nil {
+		s.logger().Printf("http2 connection error: %v\n", err)
+	}
+}
+
+// h2cSettings reports whether req asks to upgrade to cleartext HTTP/2 and
+// returns the decoded HTTP2-Settings header if so.
+func h2cSettings(req *request.Request) ([]byte, bool) {
+	if !hasToken(req.Headers, "upgrade", "h2c") || !hasToken(req.Headers, "connection", "upgrade") {
+		return nil, false
+	}
+
+	encoded, ok := req.Headers.Get("http2-settings")
+
+	if !ok {
+		return nil, false
+	}
+
+	settings, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.TrimSpace(encoded), "="))
+
+	if err != nil {
+		return nil, false
+	}
+
+	return settings, true
+}
+
+func writeSwitchingProtocols(w *response.Writer, protocol string) error {
+	if err := w.WriteStatusLine(response.SwitchingProtocolsStatus); err != nil {
+		return err
+	}
+
+	h := headers.NewHeaders()
+	h.Set("connection", "upgrade")
+	h.Set("upgrade", protocol)
+
+	return w.WriteHeaders(h)
+}
+
+// hasToken reports whether the comma separated header name contains token,
+// ignoring case.
+func hasToken(h headers.Headers, name string, token string) bool {
+	value, ok := h.Get(name)
+
+	if !ok {
+		return false
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+
+	return false
+}
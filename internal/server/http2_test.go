@@ -0,0 +1,304 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/http2"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func echoHandler(w *response.Writer, req *request.Request) {
+	body := fmt.Sprintf("HTTP/%s %s %s %s", req.RequestLine.HttpVersion, req.RequestLine.Method, req.RequestLine.RequestTarget, req.Body)
+	if req.RequestLine.RequestTarget == "/slow" {
+		time.Sleep(200 * time.Millisecond)
+	}
+	h := response.GetDefaultHeaders(len(body))
+	h.Set("x-host", req.Headers["host"])
+	w.WriteStatusLine(response.OKStatus)
+	w.WriteHeaders(h)
+	w.WriteBody(body)
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestHTTP2(t *testing.T) {
+	cert := newTestCert(t, "localhost", []string{"localhost"}, nil, true)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert.cert)
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert.tlsCertificate()}}
+
+	// Test: Negotiates h2 with ALPN over TLS
+	s, err := Serve(0, echoHandler, WithTLSConfig(tlsConfig), WithHTTP2())
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.Listener.Addr().(*net.TCPAddr)
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: pool},
+			ForceAttemptHTTP2: true,
+		},
+		Timeout: 2 * time.Second,
+	}
+	url := fmt.Sprintf("https://localhost:%d", addr.Port)
+
+	resp, err := client.Get(url + "/hello")
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, fmt.Sprintf("localhost:%d", addr.Port), resp.Header.Get("x-host"))
+	assert.Empty(t, resp.Header.Get("connection"))
+	assert.Equal(t, "HTTP/2 GET /hello ", readBody(t, resp))
+
+	// Test: Request bodies are delivered to the handler
+	resp, err = client.Post(url+"/echo", "text/plain", strings.NewReader("ping"))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2 POST /echo ping", readBody(t, resp))
+
+	// Test: Bodies larger than the flow control window
+	large := strings.Repeat("x", 200_000)
+	resp, err = client.Post(url+"/large", "text/plain", strings.NewReader(large))
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2 POST /large "+large, readBody(t, resp))
+
+	// Test: Concurrent streams on one connection
+	var wg sync.WaitGroup
+	for i := range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(fmt.Sprintf("%s/stream/%d", url, i))
+			if assert.NoError(t, err) {
+				assert.Equal(t, fmt.Sprintf("HTTP/2 GET /stream/%d ", i), readBody(t, resp))
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Test: HEAD gets the GET headers without a body
+	resp, err = client.Head(url + "/hello")
+	require.NoError(t, err)
+	assert.Equal(t, int64(len("HTTP/2 HEAD /hello ")), resp.ContentLength)
+	assert.Empty(t, readBody(t, resp))
+
+	// Test: Clients without h2 still get HTTP/1.1
+	_, raw := tlsRoundTrip(t, addr.String(), &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	assert.Contains(t, raw, "HTTP/1.1 200 OK")
+	assert.Contains(t, raw, "HTTP/1.1 GET / ")
+
+	// Test: Bodies over MaxBodyBytes get a 413
+	s2, err := Serve(0, echoHandler, WithTLSConfig(tlsConfig), WithHTTP2(), WithMaxBodyBytes(10))
+	require.NoError(t, err)
+	defer s2.Close()
+	url2 := fmt.Sprintf("https://localhost:%d", s2.Listener.Addr().(*net.TCPAddr).Port)
+
+	resp, err = client.Post(url2+"/echo", "text/plain", strings.NewReader(large))
+	require.NoError(t, err)
+	assert.Equal(t, 413, resp.StatusCode)
+	resp.Body.Close()
+
+	// Test: ReadHeaderTimeout and ReadTimeout do not cut HTTP/2 connections short
+	s4, err := Serve(0, echoHandler, WithTLSConfig(tlsConfig), WithHTTP2(), WithReadHeaderTimeout(50*time.Millisecond), WithReadTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+	defer s4.Close()
+	url4 := fmt.Sprintf("https://localhost:%d", s4.Listener.Addr().(*net.TCPAddr).Port)
+
+	timeoutClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig:   &tls.Config{RootCAs: pool},
+			ForceAttemptHTTP2: true,
+		},
+		Timeout: 2 * time.Second,
+	}
+	resp, err = timeoutClient.Get(url4 + "/slow")
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "HTTP/2 GET /slow ", readBody(t, resp))
+
+	time.Sleep(100 * time.Millisecond)
+	resp, err = timeoutClient.Get(url4 + "/again")
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/2 GET /again ", readBody(t, resp))
+
+	// Test: Cleartext HTTP/2 with prior knowledge
+	s3, err := Serve(0, echoHandler, WithHTTP2())
+	require.NoError(t, err)
+	defer s3.Close()
+	url3 := fmt.Sprintf("http://127.0.0.1:%d", s3.Listener.Addr().(*net.TCPAddr).Port)
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	h2cClient := &http.Client{Transport: &http.Transport{Protocols: protocols}, Timeout: 2 * time.Second}
+	resp, err = h2cClient.Get(url3 + "/prior")
+	require.NoError(t, err)
+	assert.Equal(t, 2, resp.ProtoMajor)
+	assert.Equal(t, "HTTP/2 GET /prior ", readBody(t, resp))
+
+	// Test: Bodies are bounded by default, since they are buffered in full
+	resp, err = h2cClient.Post(url3+"/huge", "text/plain", strings.NewReader(strings.Repeat("x", 10<<20+1)))
+	require.NoError(t, err)
+	assert.Equal(t, 413, resp.StatusCode)
+	resp.Body.Close()
+
+	// Test: Endless CONTINUATION frames are a connection error without MaxHeaderBytes
+	flood, err := net.Dial("tcp", s3.Listener.Addr().String())
+	require.NoError(t, err)
+	defer flood.Close()
+	flood.SetDeadline(time.Now().Add(2 * time.Second))
+	go func() {
+		flood.Write([]byte(http2.ClientPreface))
+		http2.WriteFrame(flood, http2.FrameSettings, 0, 0, nil)
+		http2.WriteFrame(flood, http2.FrameHeaders, 0, 1, nil)
+		chunk := make([]byte, 1<<14)
+		for range 80 {
+			if http2.WriteFrame(flood, http2.FrameContinuation, 0, 1, chunk) != nil {
+				return
+			}
+		}
+	}()
+	floodReader := bufio.NewReader(flood)
+	for {
+		frame, err := http2.ReadFrame(floodReader, 1<<14)
+		require.NoError(t, err)
+		if frame.Type == http2.FrameGoAway {
+			assert.Equal(t, uint32(http2.ErrCodeEnhanceYourCalm), binary.BigEndian.Uint32(frame.Payload[4:8]))
+			break
+		}
+	}
+
+	// Test: The header list limit is advertised and applies to the decoded size
+	s5, err := Serve(0, echoHandler, WithHTTP2(), WithMaxHeaderBytes(8192))
+	require.NoError(t, err)
+	defer s5.Close()
+	bomb, err := net.Dial("tcp", s5.Listener.Addr().String())
+	require.NoError(t, err)
+	defer bomb.Close()
+	bomb.SetDeadline(time.Now().Add(2 * time.Second))
+	block := []byte{0x82, 0x86, 0x84, 0x40, 0x01, 'x', 0x7f, 0xa1, 0x1e}
+	block = append(block, strings.Repeat("v", 4000)...)
+	block = append(block, strings.Repeat("\xbe", 2000)...)
+	_, err = bomb.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	require.NoError(t, http2.WriteFrame(bomb, http2.FrameSettings, 0, 0, nil))
+	require.NoError(t, http2.WriteFrame(bomb, http2.FrameHeaders, http2.FlagEndHeaders|http2.FlagEndStream, 1, block))
+	bombReader := bufio.NewReader(bomb)
+	var advertised uint32
+	for {
+		frame, err := http2.ReadFrame(bombReader, 1<<14)
+		require.NoError(t, err)
+		if frame.Type == http2.FrameSettings && !frame.Has(http2.FlagAck) {
+			for setting := frame.Payload; len(setting) >= 6; setting = setting[6:] {
+				if http2.SettingID(binary.BigEndian.Uint16(setting)) == http2.SettingMaxHeaderListSize {
+					advertised = binary.BigEndian.Uint32(setting[2:6])
+				}
+			}
+		}
+		if frame.Type == http2.FrameGoAway {
+			assert.Equal(t, uint32(http2.ErrCodeCompression), binary.BigEndian.Uint32(frame.Payload[4:8]))
+			break
+		}
+	}
+	assert.Equal(t, uint32(8192), advertised)
+
+	// Test: Cleartext HTTP/1.1 is unaffected
+	raw = roundTrip(t, "tcp", s3.Listener.Addr().String())
+	assert.Contains(t, raw, "HTTP/1.1 GET / ")
+
+	// Test: HTTP/1.1 HEAD responses have no body and keep the connection usable
+	h1Client := &http.Client{Timeout: 2 * time.Second}
+	for range 2 {
+		resp, err = h1Client.Head(url3 + "/head")
+		require.NoError(t, err)
+		assert.Equal(t, 1, resp.ProtoMajor)
+		assert.Equal(t, int64(len("HTTP/1.1 HEAD /head ")), resp.ContentLength)
+		assert.Empty(t, readBody(t, resp))
+	}
+
+	// Test: Upgrades to h2c and answers the original request on stream 1
+	conn, err := net.Dial("tcp", s3.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	_, err = conn.Write([]byte("GET /upgrade HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade, HTTP2-Settings\r\nUpgrade: h2c\r\nHTTP2-Settings: AAIAAAAA\r\n\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(conn)
+	status, err := reader.ReadString('\n')
+	require.NoError(t, err)
+	assert.Equal(t, "HTTP/1.1 101 Switching Protocols\r\n", status)
+	for {
+		line, err := reader.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	_, err = conn.Write([]byte(http2.ClientPreface))
+	require.NoError(t, err)
+	require.NoError(t, http2.WriteFrame(conn, http2.FrameSettings, 0, 0, nil))
+
+	decoder := http2.NewDecoder(4096, 0)
+	var fields []http2.HeaderField
+	var body []byte
+	for {
+		frame, err := http2.ReadFrame(reader, 1<<14)
+		require.NoError(t, err)
+		require.Contains(t, []uint32{0, 1}, frame.StreamID)
+		if frame.Type == http2.FrameHeaders {
+			fields, err = decoder.Decode(frame.Payload)
+			require.NoError(t, err)
+		}
+		if frame.Type == http2.FrameData {
+			body = append(body, frame.Payload...)
+		}
+		if frame.StreamID == 1 && frame.Has(http2.FlagEndStream) {
+			break
+		}
+	}
+	require.NotEmpty(t, fields)
+	assert.Equal(t, http2.HeaderField{Name: ":status", Value: "200"}, fields[0])
+	assert.Equal(t, "HTTP/1.1 GET /upgrade ", string(body))
+
+	// Test: Shutdown lets in-flight streams finish and closes idle connections
+	resp, err = client.Get(url + "/warm")
+	require.NoError(t, err)
+	readBody(t, resp)
+
+	done := make(chan string)
+	go func() {
+		resp, err := client.Get(url + "/slow")
+		if !assert.NoError(t, err) {
+			done <- ""
+			return
+		}
+		done <- readBody(t, resp)
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	require.NoError(t, s.Shutdown(ctx))
+	assert.Equal(t, "HTTP/2 GET /slow ", <-done)
+}
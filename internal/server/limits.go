@@ -0,0 +1,151 @@
+package server
+
+import (
+	"net"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+)
+
+const (
+	// acceptBackoffMin and acceptBackoffMax bound how long the server waits
+	// after a failed Accept, such as when the process is out of file
+	// descriptors, so it does not spin retrying.
+	acceptBackoffMin = 5 * time.Millisecond
+	acceptBackoffMax = time.Second
+	// rejectRetryAfter is the Retry-After sent with a 503 for connections
+	// over a limit.
+	rejectRetryAfter = "1"
+	// maxRejecting bounds how many rejected connections are answered at
+	// once. Past it they are closed without a response, so a flood of
+	// connections cannot pile up goroutines.
+	maxRejecting = 64
+)
+
+// waitForSlot blocks until fewer than MaxConns connections are open when
+// the server queues connections over capacity. It reports false if the
+// server closes while waiting.
+func (s *Server) waitForSlot() bool {
+	if s.slots == nil || s.RejectOverCapacity {
+		return true
+	}
+
+	select {
+	case s.slots <- struct{}{}:
+		return true
+	case <-s.shuttingDown:
+		return false
+	case <-s.baseCtx.Done():
+		return false
+	}
+}
+
+// releaseQueuedSlot gives back the slot waitForSlot took when Accept
+// fails.
+func (s *Server) releaseQueuedSlot() {
+	if !s.RejectOverCapacity {
+		s.releaseSlot()
+	}
+}
+
+// admit applies the connection limits to a freshly accepted connection,
+// answering it with a 503 and reporting false if it is over one. The
+// connection holds a slot if it is admitted.
+func (s *Server) admit(c *trackedConn) bool {
+	if s.slots != nil && s.RejectOverCapacity {
+		select {
+		case s.slots <- struct{}{}:
+		default:
+			s.reject(c.Conn, "the server is at capacity")
+			return false
+		}
+	}
+
+	if s.MaxConnsPerIP > 0 {
+		if addr, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+			c.ip = addr.IP.String()
+		}
+	}
+
+	if c.ip != "" {
+		s.mu.Lock()
+		over := s.connsPerIP[c.ip] >= s.MaxConnsPerIP
+		if !over {
+			s.connsPerIP[c.ip]++
+		}
+		s.mu.Unlock()
+
+		if over {
+			s.releaseSlot()
+			s.reject(c.Conn, "too many connections from your address")
+			return false
+		}
+	}
+
+	return true
+}
+
+// release gives back the limits c held once the server is done with it.
+func (s *Server) release(c *trackedConn) {
+	if c.ip != "" {
+		s.mu.Lock()
+		s.connsPerIP[c.ip]--
+		if s.connsPerIP[c.ip] == 0 {
+			delete(s.connsPerIP, c.ip)
+		}
+		s.mu.Unlock()
+	}
+
+	s.releaseSlot()
+}
+
+func (s *Server) releaseSlot() {
+	if s.slots != nil {
+		<-s.slots
+	}
+}
+
+// reject answers conn with a 503 without reading a request and closes it,
+// or just closes it when too many rejections are already in flight.
+func (s *Server) reject(conn net.Conn, message string) {
+	s.logger().Printf("rejected the connection from %v: %s\n", conn.RemoteAddr(), message)
+
+	select {
+	case s.rejecting <- struct{}{}:
+	default:
+		conn.Close()
+		return
+	}
+
+	go func() {
+		defer func() { <-s.rejecting }()
+		defer conn.Close()
+
+		conn.SetWriteDeadline(time.Now().Add(lingerTimeout))
+		w := response.NewWriter(conn)
+		w.Header().Set("retry-after", rejectRetryAfter)
+		s.errorHandler()(w, &HandlerError{StatusCode: response.ServiceUnavailableStatus, ErrorMessage: message})
+
+		if w.Finish() == nil {
+			closeWriteAndDrain(conn)
+		}
+	}()
+}
+
+// acceptBackoff waits before the next Accept after a failure, doubling the
+// delay each time. It reports false if the server closes while waiting.
+func (s *Server) acceptBackoff(delay *time.Duration) bool {
+	*delay = min(max(*delay*2, acceptBackoffMin), acceptBackoffMax)
+
+	timer := time.NewTimer(*delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-s.shuttingDown:
+		return false
+	case <-s.baseCtx.Done():
+		return false
+	}
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"io"
+	"log"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveLimited starts a server with opts and returns it with a channel that
+// receives a value each time a connection is admitted.
+func serveLimited(t *testing.T, opts ...Option) (*Server, chan struct{}) {
+	admitted := make(chan struct{}, 16)
+	opts = append(opts,
+		WithAddr("127.0.0.1:0"),
+		WithLogger(log.New(io.Discard, "", 0)),
+		WithConnState(func(conn net.Conn, state ConnState) {
+			if state == StateIdle {
+				admitted <- struct{}{}
+			}
+		}),
+	)
+	s := New(okHandler, opts...)
+	require.NoError(t, s.Start())
+	t.Cleanup(func() { s.Close() })
+	return s, admitted
+}
+
+// holdConn opens a connection that sends nothing, so it stays open until
+// closed, and waits for the server to admit it.
+func holdConn(t *testing.T, s *Server, admitted chan struct{}) net.Conn {
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	select {
+	case <-admitted:
+	case <-time.After(time.Second):
+		t.Fatal("connection was not admitted")
+	}
+	return conn
+}
+
+func TestMaxConnsReject(t *testing.T) {
+	s, admitted := serveLimited(t, WithMaxConns(1), WithRejectOverCapacity())
+	addr := s.Listener.Addr().String()
+
+	// Test: Connections over capacity get a 503 straight away
+	held := holdConn(t, s, admitted)
+	resp := roundTrip(t, "tcp", addr)
+	assert.Contains(t, resp, "HTTP/1.1 503 Service Unavailable")
+	assert.Contains(t, resp, "retry-after: 1\r\n")
+	assert.Contains(t, resp, "the server is at capacity")
+
+	// Test: Capacity frees up when a connection closes
+	held.Close()
+	assert.Eventually(t, func() bool {
+		return len(s.slots) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, roundTrip(t, "tcp", addr), "HTTP/1.1 200 OK")
+
+	// Test: Past the rejection limit connections are closed without a response
+	held = holdConn(t, s, admitted)
+	defer held.Close()
+	for range maxRejecting {
+		s.rejecting <- struct{}{}
+	}
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	unanswered, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, unanswered)
+	for range maxRejecting {
+		<-s.rejecting
+	}
+	assert.Contains(t, roundTrip(t, "tcp", addr), "HTTP/1.1 503 Service Unavailable")
+}
+
+func TestMaxConnsQueue(t *testing.T) {
+	s, admitted := serveLimited(t, WithMaxConns(1))
+
+	// Test: Connections over capacity wait in the backlog
+	held := holdConn(t, s, admitted)
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 1))
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+
+	// Test: A queued connection is served once another closes
+	held.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "HTTP/1.1 200 OK")
+
+	// Test: Close does not hang while the listener waits for a slot
+	holdConn(t, s, admitted)
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close hung")
+	}
+}
+
+func TestMaxConnsPerIP(t *testing.T) {
+	s, admitted := serveLimited(t, WithMaxConnsPerIP(1), WithMaxConns(4))
+	addr := s.Listener.Addr().String()
+
+	// Test: A client over its connection cap gets a 503 even in queue mode
+	held := holdConn(t, s, admitted)
+	resp := roundTrip(t, "tcp", addr)
+	assert.Contains(t, resp, "HTTP/1.1 503 Service Unavailable")
+	assert.Contains(t, resp, "too many connections from your address")
+
+	// Test: Rejected connections give their slot back. The listener holds
+	// one while it waits in Accept.
+	held.Close()
+	assert.Eventually(t, func() bool {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.connsPerIP) == 0 && len(s.slots) == 1
+	}, time.Second, 10*time.Millisecond)
+	assert.Contains(t, roundTrip(t, "tcp", addr), "HTTP/1.1 200 OK")
+}
+
+type failingListener struct {
+	net.Listener
+	accepts atomic.Int32
+}
+
+func (l *failingListener) Accept() (net.Conn, error) {
+	l.accepts.Add(1)
+	return nil, &net.OpError{Op: "accept", Net: "tcp", Err: syscall.EMFILE}
+}
+
+func TestAcceptBackoff(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	listener := &failingListener{Listener: inner}
+	s, err := ServeListener(listener, okHandler, WithLogger(log.New(io.Discard, "", 0)))
+	require.NoError(t, err)
+
+	// Test: Failing Accepts back off exponentially instead of spinning
+	time.Sleep(200 * time.Millisecond)
+	accepts := listener.accepts.Load()
+	assert.Greater(t, accepts, int32(2))
+	assert.Less(t, accepts, int32(10))
+
+	// Test: Close interrupts the backoff
+	done := make(chan struct{})
+	go func() {
+		s.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Close hung")
+	}
+}
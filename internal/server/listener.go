@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+)
+
+// ServeListener serves on a listener the caller has already created, such as
+// a test listener or one inherited from a supervisor. The server takes
+// ownership of the listener and closes it on Close or Shutdown.
+func ServeListener(listener net.Listener, handler Handler, opts ...Option) (*Server, error) {
+	server := New(handler, opts...)
+
+	if err := server.StartListener(listener); err != nil {
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// listenUnix binds a Unix domain socket at path. A socket file left behind
+// by a process that is no longer running is removed first; one that still
+// accepts connections is reported as in use.
+func listenUnix(network string, path string, mode os.FileMode) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen(network, path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			listener.Close()
+			return nil, err
+		}
+	}
+
+	return listener, nil
+}
+
+func removeStaleSocket(path string) error {
+	info, err := os.Lstat(path)
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if info.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", path)
+	}
+
+	conn, err := net.Dial("unix", path)
+
+	if err == nil {
+		conn.Close()
+		return fmt.Errorf("%s is in use by another process", path)
+	}
+
+	return os.Remove(path)
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+)
+
+// InheritedListeners is only supported on Unix. It returns an error when
+// the process was passed listeners through LISTEN_FDS, and no listeners
+// otherwise.
+func InheritedListeners() ([]net.Listener, []string, error) {
+	if os.Getenv("LISTEN_FDS") == "" {
+		return nil, nil, nil
+	}
+
+	return nil, nil, errors.New("inherited listeners are only supported on Unix")
+}
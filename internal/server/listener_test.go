@@ -0,0 +1,86 @@
+package server
+
+import (
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func okHandler(w *response.Writer, req *request.Request) {
+	body := "ok"
+	w.WriteStatusLine(response.OKStatus)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func roundTrip(t *testing.T, network string, addr string) string {
+	return roundTripRequest(t, network, addr, "GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+}
+
+func roundTripRequest(t *testing.T, network string, addr string, raw string) string {
+	conn, err := net.Dial(network, addr)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte(raw))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	return string(resp)
+}
+
+func TestServeListener(t *testing.T) {
+	// Test: Serves on a listener supplied by the caller
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s, err := ServeListener(listener, okHandler)
+	require.NoError(t, err)
+	assert.Contains(t, roundTrip(t, "tcp", listener.Addr().String()), "HTTP/1.1 200 OK")
+	require.NoError(t, s.Close())
+
+	// Test: The listener is closed with the server
+	_, err = net.Dial("tcp", listener.Addr().String())
+	require.Error(t, err)
+}
+
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "server.sock")
+
+	// Test: A stale socket file is replaced and permissions applied
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	s := New(okHandler, WithNetwork("unix"), WithAddr(path), WithSocketMode(0600))
+	require.NoError(t, s.Start())
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	assert.Contains(t, roundTrip(t, "unix", path), "HTTP/1.1 200 OK")
+
+	// Test: A socket that is still in use is not removed
+	other := New(okHandler, WithNetwork("unix"), WithAddr(path))
+	require.Error(t, other.Start())
+	assert.Contains(t, roundTrip(t, "unix", path), "HTTP/1.1 200 OK")
+
+	// Test: The socket file is removed on close
+	require.NoError(t, s.Close())
+	_, err = os.Stat(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	// Test: A regular file is never removed
+	require.NoError(t, os.WriteFile(path, []byte("data"), 0600))
+	s = New(okHandler, WithNetwork("unix"), WithAddr(path))
+	require.Error(t, s.Start())
+}
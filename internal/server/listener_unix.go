@@ -0,0 +1,78 @@
+//go:build unix
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// listenFDsStart is the first file descriptor systemd passes to a
+// socket-activated service; stdin, stdout and stderr come before it.
+const listenFDsStart = 3
+
+// InheritedListeners returns the listeners passed to this process through
+// systemd-style socket activation (LISTEN_PID, LISTEN_FDS and optionally
+// LISTEN_FDNAMES) together with their names, in the order they were passed.
+// It returns no listeners when the process was not socket-activated. The
+// environment variables are cleared so child processes do not inherit them.
+func InheritedListeners() ([]net.Listener, []string, error) {
+	return inheritedListeners(listenFDsStart)
+}
+
+func inheritedListeners(firstFD int) ([]net.Listener, []string, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	if fds == "" {
+		return nil, nil, nil
+	}
+
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil, nil
+	}
+
+	count, err := strconv.Atoi(fds)
+
+	if err != nil || count < 0 {
+		return nil, nil, fmt.Errorf("invalid LISTEN_FDS: %q", fds)
+	}
+
+	nameList := strings.Split(names, ":")
+	listeners := make([]net.Listener, 0, count)
+	listenerNames := make([]string, 0, count)
+
+	for i := range count {
+		fd := firstFD + i
+		syscall.CloseOnExec(fd)
+
+		name := fmt.Sprintf("LISTEN_FD_%d", fd)
+		if names != "" && i < len(nameList) {
+			name = nameList[i]
+		}
+
+		file := os.NewFile(uintptr(fd), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, nil, fmt.Errorf("inherited fd %d is not a listener: %w", fd, err)
+		}
+
+		listeners = append(listeners, listener)
+		listenerNames = append(listenerNames, name)
+	}
+
+	return listeners, listenerNames, nil
+}
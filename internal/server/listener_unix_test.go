@@ -0,0 +1,51 @@
+//go:build unix
+
+package server
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInheritedListeners(t *testing.T) {
+	// Test: No listeners without socket activation
+	t.Setenv("LISTEN_FDS", "")
+	listeners, names, err := InheritedListeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+	assert.Empty(t, names)
+
+	// Test: Listeners for another process are ignored
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	listeners, _, err = InheritedListeners()
+	require.NoError(t, err)
+	assert.Empty(t, listeners)
+
+	// Test: An inherited listening socket is picked up by name
+	original, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer original.Close()
+	file, err := original.(*net.TCPListener).File()
+	require.NoError(t, err)
+	defer file.Close()
+
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_FDNAMES", "web")
+	listeners, names, err = inheritedListeners(int(file.Fd()))
+	require.NoError(t, err)
+	require.Len(t, listeners, 1)
+	assert.Equal(t, []string{"web"}, names)
+	assert.Empty(t, os.Getenv("LISTEN_FDS"))
+
+	s, err := ServeListener(listeners[0], okHandler)
+	require.NoError(t, err)
+	defer s.Close()
+	assert.Contains(t, roundTrip(t, "tcp", original.Addr().String()), "HTTP/1.1 200 OK")
+}
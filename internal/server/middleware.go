@@ -0,0 +1,14 @@
+package server
+
+// Middleware wraps a Handler with extra behaviour, such as authentication or
+// header rewriting, and returns the wrapped Handler.
+type Middleware func(Handler) Handler
+
+// Chain applies middlewares to handler so that the first middleware listed is
+// the outermost one and sees the request first.
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
@@ -0,0 +1,156 @@
+package server
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+)
+
+// LoadCertPool reads PEM encoded CA certificates from the given files, for
+// use as Config.ClientCAs.
+func LoadCertPool(files ...string) (*x509.CertPool, error) {
+	pool := x509.NewCertPool()
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in %s", file)
+		}
+	}
+
+	return pool, nil
+}
+
+// ClientCertificate returns the leaf of the client certificate chain the
+// server verified during the handshake, or nil when the client did not
+// present one or it was not verified against ClientCAs.
+func ClientCertificate(req *request.Request) *x509.Certificate {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return req.TLS.VerifiedChains[0][0]
+}
+
+// ClientCertPolicy describes which verified client certificates may reach a
+// handler. A certificate is accepted when it matches any listed value; an
+// empty policy accepts any verified certificate.
+type ClientCertPolicy struct {
+	CommonNames    []string
+	DNSNames       []string
+	EmailAddresses []string
+	URIs           []string
+	Organizations  []string
+}
+
+func (p ClientCertPolicy) isEmpty() bool {
+	return len(p.CommonNames) == 0 && len(p.DNSNames) == 0 && len(p.EmailAddresses) == 0 &&
+		len(p.URIs) == 0 && len(p.Organizations) == 0
+}
+
+func (p ClientCertPolicy) Allows(cert *x509.Certificate) bool {
+	if cert == nil {
+		return false
+	}
+
+	if p.isEmpty() {
+		return true
+	}
+
+	if slices.Contains(p.CommonNames, cert.Subject.CommonName) {
+		return true
+	}
+
+	for _, name := range cert.DNSNames {
+		if slices.Contains(p.DNSNames, name) {
+			return true
+		}
+	}
+
+	for _, email := range cert.EmailAddresses {
+		if slices.Contains(p.EmailAddresses, email) {
+			return true
+		}
+	}
+
+	for _, uri := range cert.URIs {
+		if slices.Contains(p.URIs, uri.String()) {
+			return true
+		}
+	}
+
+	for _, org := range cert.Subject.Organization {
+		if slices.Contains(p.Organizations, org) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// RequireClientCert rejects requests with 403 Forbidden unless they carry a
+// verified client certificate accepted by policy.
+func RequireClientCert(policy ClientCertPolicy) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			if !policy.Allows(ClientCertificate(req)) {
+				writeError(w, &HandlerError{StatusCode: response.ForbiddenStatus, ErrorMessage: "client certificate not allowed"})
+				return
+			}
+			next(w, req)
+		}
+	}
+}
+
+// RequireClientCertForPaths applies a policy per request path. The policy
+// with the longest matching path prefix wins; prefixes match whole path
+// segments of the cleaned, decoded path, so "/admin" covers "/admin/x" and
+// "//admin" but not "/administrators". Requests matching no prefix are
+// passed through untouched, and request targets that cannot be parsed are
+// refused.
+func RequireClientCertForPaths(policies map[string]ClientCertPolicy) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			target, err := url.ParseRequestURI(req.RequestLine.RequestTarget)
+
+			if err != nil {
+				writeError(w, &HandlerError{StatusCode: response.BadRequestStatus, ErrorMessage: "invalid request target"})
+				return
+			}
+
+			requestPath := path.Clean("/" + target.Path)
+			matched := ""
+			found := false
+			for prefix := range policies {
+				if pathHasPrefix(requestPath, prefix) && (!found || len(prefix) > len(matched)) {
+					matched = prefix
+					found = true
+				}
+			}
+
+			if found && !policies[matched].Allows(ClientCertificate(req)) {
+				writeError(w, &HandlerError{StatusCode: response.ForbiddenStatus, ErrorMessage: "client certificate not allowed"})
+				return
+			}
+
+			next(w, req)
+		}
+	}
+}
+
+// pathHasPrefix reports whether prefix matches p on a path segment boundary.
+func pathHasPrefix(p string, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
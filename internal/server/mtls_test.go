@@ -0,0 +1,116 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClientCertificates(t *testing.T) {
+	serverCert := newTestCert(t, "server", []string{"server.test"}, nil, true)
+	ca := newTestCert(t, "client-ca", nil, nil, true)
+	billing := newTestCert(t, "billing", []string{"billing.internal"}, ca, false)
+	reports := newTestCert(t, "reports", []string{"reports.internal"}, ca, false)
+	rogue := newTestCert(t, "billing", []string{"billing.internal"}, nil, false)
+
+	serverPool := x509.NewCertPool()
+	serverPool.AddCert(serverCert.cert)
+	clientPool := x509.NewCertPool()
+	clientPool.AddCert(ca.cert)
+
+	handler := Chain(func(w *response.Writer, req *request.Request) {
+		body := "anonymous"
+		if cert := ClientCertificate(req); cert != nil {
+			body = cert.Subject.CommonName
+		}
+		w.WriteStatusLine(response.OKStatus)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, RequireClientCertForPaths(map[string]ClientCertPolicy{
+		"/billing":        {DNSNames: []string{"billing.internal"}},
+		"/billing/public": {},
+		"/reports":        {CommonNames: []string{"reports"}},
+	}))
+
+	s, err := Serve(0, handler,
+		WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert.tlsCertificate()}}),
+		WithClientCAs(clientPool, tls.VerifyClientCertIfGiven),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+
+	get := func(client *testCert, path string) string {
+		config := &tls.Config{RootCAs: serverPool, ServerName: "server.test"}
+		if client != nil {
+			config.Certificates = []tls.Certificate{client.tlsCertificate()}
+		}
+		conn, err := tls.Dial("tcp", s.Listener.Addr().String(), config)
+		if err != nil {
+			return err.Error()
+		}
+		defer conn.Close()
+		_, err = conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := io.ReadAll(conn)
+		if err != nil {
+			return err.Error()
+		}
+		return string(resp)
+	}
+
+	// Test: The verified identity is exposed to the handler
+	resp := get(billing, "/")
+	assert.Contains(t, resp, "HTTP/1.1 200 OK")
+	assert.Contains(t, resp, "billing")
+
+	// Test: Unprotected paths accept anonymous clients
+	assert.Contains(t, get(nil, "/"), "anonymous")
+
+	// Test: Policies match on SANs and subjects
+	assert.Contains(t, get(billing, "/billing/invoices"), "HTTP/1.1 200 OK")
+	assert.Contains(t, get(reports, "/billing/invoices"), "HTTP/1.1 403 Forbidden")
+	assert.Contains(t, get(reports, "/reports"), "HTTP/1.1 200 OK")
+	assert.Contains(t, get(nil, "/reports"), "HTTP/1.1 403 Forbidden")
+
+	// Test: The longest prefix wins, and an empty policy accepts any verified cert
+	assert.Contains(t, get(reports, "/billing/public/rates"), "HTTP/1.1 200 OK")
+	assert.Contains(t, get(nil, "/billing/public/rates"), "HTTP/1.1 403 Forbidden")
+
+	// Test: Paths that resolve to a protected prefix are still protected
+	for _, target := range []string{"http://localhost/reports", "//reports", "/./reports/", "/%72eports", "/x/../reports"} {
+		assert.Contains(t, get(nil, target), "HTTP/1.1 403 Forbidden", target)
+	}
+
+	// Test: Prefixes only match whole path segments
+	assert.Contains(t, get(nil, "/reportsarchive"), "HTTP/1.1 200 OK")
+
+	// Test: Unparseable request targets are refused
+	assert.Contains(t, get(reports, "reports"), "HTTP/1.1 400 Bad Request")
+
+	// Test: Certificates not signed by the CA fail the handshake
+	assert.NotContains(t, get(rogue, "/billing/invoices"), "HTTP/1.1 200 OK")
+
+	// Test: RequireAndVerifyClientCert rejects clients without a certificate
+	s2, err := Serve(0, RequireClientCert(ClientCertPolicy{})(handler),
+		WithTLSConfig(&tls.Config{Certificates: []tls.Certificate{serverCert.tlsCertificate()}}),
+		WithClientCAs(clientPool, tls.NoClientCert),
+	)
+	require.NoError(t, err)
+	defer s2.Close()
+	conn, err := tls.Dial("tcp", s2.Listener.Addr().String(), &tls.Config{RootCAs: serverPool, ServerName: "server.test"})
+	if err == nil {
+		conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, err = io.ReadAll(conn)
+		conn.Close()
+	}
+	require.Error(t, err)
+}
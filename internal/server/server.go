@@ -1,18 +1,46 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
 	"github.com/allscorpion/build-http-from-scratch/internal/request"
 	"github.com/allscorpion/build-http-from-scratch/internal/response"
 )
 
 type Server struct {
+	Config
 	Listener net.Listener
 	isOpen   atomic.Bool
 	Handler  Handler
+
+	baseCtx    context.Context
+	cancelBase context.CancelFunc
+	// shuttingDown is closed when Shutdown starts so long lived connections
+	// such as HTTP/2 ones can wind down on their own.
+	shuttingDown chan struct{}
+	shutdownOnce sync.Once
+
+	// slots holds a token for each open connection when MaxConns is set.
+	slots chan struct{}
+	// rejecting holds a token for each connection being answered with a
+	// 503 by reject.
+	rejecting chan struct{}
+
+	mu         sync.Mutex
+	conns      map[*trackedConn]struct{}
+	connsPerIP map[string]int
+	onShutdown []func()
+	closeOnce  sync.Once
+	closeErr   error
 }
 
 type HandlerError struct {
@@ -20,68 +48,420 @@ type HandlerError struct {
 	ErrorMessage string
 }
 
+func (e *HandlerError) Error() string {
+	return fmt.Sprintf("%d: %s", e.StatusCode, e.ErrorMessage)
+}
+
 type Handler func(w *response.Writer, req *request.Request)
 
-func Serve(port int, handler Handler) (*Server, error) {
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+// serverMethods is the Allow list for "OPTIONS *".
+const serverMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
+
+const (
+	shutdownPollInterval = 10 * time.Millisecond
+	lingerTimeout        = 500 * time.Millisecond
+)
+
+// New builds a Server from the given options without binding anything.
+// Call Start to begin accepting connections.
+func New(handler Handler, opts ...Option) *Server {
+	server := &Server{
+		Handler:    handler,
+		conns:      map[*trackedConn]struct{}{},
+		connsPerIP: map[string]int{},
+		rejecting:  make(chan struct{}, maxRejecting),
+
+		shuttingDown: make(chan struct{}),
+	}
+	server.baseCtx, server.cancelBase = context.WithCancel(context.Background())
+
+	for _, opt := range opts {
+		opt(&server.Config)
+	}
+
+	if server.MaxConns > 0 {
+		server.slots = make(chan struct{}, server.MaxConns)
+	}
+
+	return server
+}
+
+// Start binds Addr on Network and accepts connections in the background.
+func (s *Server) Start() error {
+	var listener net.Listener
+	var err error
+
+	switch s.network() {
+	case "unix", "unixpacket":
+		listener, err = listenUnix(s.network(), s.Addr, s.SocketMode)
+	default:
+		listener, err = net.Listen(s.network(), s.Addr)
+	}
 
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	server := Server{
-		Listener: listener,
-		isOpen:   atomic.Bool{},
-		Handler:  handler,
+	return s.StartListener(listener)
+}
+
+// StartListener accepts connections from listener in the background instead
+// of binding Addr. The server takes ownership of the listener.
+func (s *Server) StartListener(listener net.Listener) error {
+	if tlsConfig := s.tlsConfig(); tlsConfig != nil {
+		listener = tls.NewListener(listener, tlsConfig)
 	}
 
-	server.isOpen.Store(true)
+	s.Listener = listener
+	s.isOpen.Store(true)
 
-	go server.listen()
+	go s.listen()
 
-	return &server, nil
+	return nil
 }
 
+// Serve listens on the given TCP port on all interfaces. It is shorthand for
+// New with WithAddr followed by Start.
+func Serve(port int, handler Handler, opts ...Option) (*Server, error) {
+	opts = append([]Option{WithAddr(fmt.Sprintf(":%d", port))}, opts...)
+	server := New(handler, opts...)
+
+	if err := server.Start(); err != nil {
+		return nil, err
+	}
+
+	return server, nil
+}
+
+// Close stops accepting new connections, cancels every request context and
+// immediately closes every open connection, including ones with a handler
+// still running.
 func (s *Server) Close() error {
 	defer func() {
-		fmt.Println("The connection has been closed")
+		s.logger().Println("The connection has been closed")
 	}()
 	s.isOpen.Store(false)
-	return s.Listener.Close()
+	err := s.closeListener()
+	s.cancelBase()
+	s.closeConns(false)
+	return err
 }
 
-func (s *Server) listen() {
+// Shutdown stops accepting new connections, closes idle connections and waits
+// for in-flight requests to finish. If ctx is done before that happens the
+// remaining request contexts are cancelled, their connections force-closed and
+// the context's error is returned.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.isOpen.Store(false)
+	err := s.closeListener()
+	s.shutdownOnce.Do(func() { close(s.shuttingDown) })
+
+	s.mu.Lock()
+	hooks := s.onShutdown
+	s.mu.Unlock()
+
+	for _, hook := range hooks {
+		go hook()
+	}
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
 	for {
+		if s.closeConns(true) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			s.cancelBase()
+			s.closeConns(false)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// RegisterOnShutdown registers a function to be called in its own goroutine
+// when Shutdown is called.
+func (s *Server) RegisterOnShutdown(f func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onShutdown = append(s.onShutdown, f)
+}
+
+func (s *Server) closeListener() error {
+	s.closeOnce.Do(func() {
+		if s.Listener != nil {
+			s.closeErr = s.Listener.Close()
+		}
+	})
+	return s.closeErr
+}
+
+// closeConns closes tracked connections, or only the idle ones when idleOnly
+// is set, and reports whether no connections are left open.
+func (s *Server) closeConns(idleOnly bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		if idleOnly && c.getState() != StateIdle {
+			continue
+		}
+		c.Close()
+		delete(s.conns, c)
+	}
+
+	return len(s.conns) == 0
+}
+
+func (s *Server) trackConn(c *trackedConn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if add {
+		s.conns[c] = struct{}{}
+	} else {
+		delete(s.conns, c)
+	}
+}
+
+func (s *Server) setState(c *trackedConn, state ConnState) {
+	c.state.Store(int32(state))
+	if s.ConnState != nil {
+		s.ConnState(c.Conn, state)
+	}
+}
+
+func (s *Server) listen() {
+	var delay time.Duration
+
+	for s.waitForSlot() {
 		conn, err := s.Listener.Accept()
 
 		if !s.isOpen.Load() {
+			if conn != nil {
+				conn.Close()
+			}
 			break
 		}
 
 		if err != nil {
-			fmt.Printf("an error has occured accepted the connection %v\n", err)
+			s.releaseQueuedSlot()
+			s.logger().Printf("an error has occured accepted the connection %v\n", err)
+			if !s.acceptBackoff(&delay) {
+				break
+			}
 			continue
 		}
 
-		fmt.Println("the connection has been accepted")
+		delay = 0
+		s.logger().Println("the connection has been accepted")
+
+		c := &trackedConn{Conn: conn}
+		if !s.admit(c) {
+			continue
+		}
+
+		s.trackConn(c, true)
+		s.setState(c, StateIdle)
 
 		go func() {
-			s.handle(conn)
+			defer func() {
+				s.release(c)
+				if c.hijacked.Load() {
+					return
+				}
+				s.trackConn(c, false)
+				s.setState(c, StateClosed)
+			}()
+			s.handle(c)
 		}()
 	}
 }
 
-func (s *Server) handle(conn net.Conn) {
-	defer conn.Close()
-	req, err := request.RequestFromReader(conn)
-	responseWriter := response.NewWriter(conn)
+func (s *Server) handle(conn *trackedConn) {
+	defer func() {
+		if !conn.hijacked.Load() {
+			conn.Close()
+		}
+	}()
+
+	if idleTimeout := s.idleTimeout(); idleTimeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(idleTimeout))
+	}
+
+	var requestStart time.Time
+	headersRead := false
+	conn.onActive = func() {
+		if s.ConnState != nil {
+			s.ConnState(conn.Conn, StateActive)
+		}
+		requestStart = time.Now()
+		if headerTimeout := s.readHeaderTimeout(); headerTimeout > 0 {
+			conn.SetReadDeadline(requestStart.Add(headerTimeout))
+		} else {
+			conn.SetReadDeadline(time.Time{})
+		}
+	}
+
+	var reader io.Reader = conn
+	if s.EnableHTTP2 {
+		isHTTP2, buffered := s.negotiateHTTP2(conn)
+		if isHTTP2 {
+			s.serveHTTP2(conn, buffered, nil, nil)
+			return
+		}
+		reader = buffered
+	}
+
+	var watcher *connWatcher
+	var bodyRead atomic.Bool
+	req, err := request.RequestFromReaderWithOptions(reader, request.Options{
+		HeadersRead: func() {
+			headersRead = true
+			if s.ReadTimeout > 0 {
+				conn.SetReadDeadline(requestStart.Add(s.ReadTimeout))
+			} else {
+				conn.SetReadDeadline(time.Time{})
+			}
+		},
+		MaxHeaderBytes: s.MaxHeaderBytes,
+		MaxBodyBytes:   s.MaxBodyBytes,
+		StreamBody:     s.StreamBody,
+		BodyRead: func() {
+			bodyRead.Store(true)
+			conn.SetReadDeadline(time.Time{})
+			watcher.start()
+		},
+	})
+	responseWriter := response.NewWriterSize(conn, s.WriteBufferSize)
 	if err != nil {
-		responseWriter.WriteStatusLine(response.InternalServerErrorStatus)
-		body := err.Error()
-		responseWriter.WriteHeaders(response.GetDefaultHeaders(len(body)))
-		responseWriter.WriteBody(body)
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() && conn.getState() == StateIdle {
+			return
+		}
+		s.errorHandler()(responseWriter, requestError(err, headersRead))
+		responseWriter.Finish()
+		closeWriteAndDrain(conn.Conn)
 		return
 	}
 
-	s.Handler(responseWriter, req)
+	if tlsConn, ok := conn.Conn.(*tls.Conn); ok {
+		state := tlsConn.ConnectionState()
+		req.TLS = &state
+	}
+	req.RemoteAddr = conn.RemoteAddr().String()
+	responseWriter.SetRequest(req)
+
+	if s.EnableHTTP2 && req.TLS == nil {
+		if settings, ok := h2cSettings(req); ok {
+			if err := writeSwitchingProtocols(responseWriter, "h2c"); err != nil {
+				return
+			}
+			if err := responseWriter.Flush(); err != nil {
+				return
+			}
+			s.serveHTTP2(conn, reader, req, settings)
+			return
+		}
+	}
+
+	// A streamed body is still to be read, so its deadline stays and the
+	// watcher waits until the handler has read it.
+	if !req.BodyStreamed() {
+		conn.SetReadDeadline(time.Time{})
+	}
+	if s.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(s.WriteTimeout))
+	}
+
+	ctx, cancelConn := context.WithCancel(s.baseCtx)
+	defer cancelConn()
+	watcher = newConnWatcher(conn, cancelConn)
+	if !req.BodyStreamed() {
+		watcher.start()
+	}
+	conn.hijack = s.hijacker(conn, reader, req, watcher)
+
+	if s.RequestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.RequestTimeout)
+		defer cancel()
+	}
+
+	s.serve(responseWriter, req.WithContext(ctx))
+
+	if !conn.hijacked.Load() {
+		responseWriter.Finish()
+		if req.BodyStreamed() && !bodyRead.Load() {
+			closeWriteAndDrain(conn.Conn)
+		}
+	}
+}
+
+// serve passes req to the handler. "OPTIONS *" asks about the server rather
+// than any resource, so it is answered here.
+func (s *Server) serve(w *response.Writer, req *request.Request) {
+	if req.RequestLine.Method == "OPTIONS" && req.RequestLine.RequestTarget == "*" {
+		h := headers.NewHeaders()
+		h.Set("allow", serverMethods)
+		h.Set("content-length", "0")
+		w.WriteStatusLine(response.OKStatus)
+		w.WriteHeaders(h)
+		return
+	}
+
+	s.Handler(w, req)
+}
+
+// requestError maps a failure from reading a request to the status the
+// client should see.
+func requestError(err error, headersRead bool) *HandlerError {
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr) && netErr.Timeout():
+		message := "timed out reading the request headers"
+		if headersRead {
+			message = "timed out reading the request body"
+		}
+		return &HandlerError{StatusCode: response.RequestTimeoutStatus, ErrorMessage: message}
+	case errors.Is(err, request.ErrHeadersTooLarge):
+		return &HandlerError{StatusCode: response.HeadersTooLargeStatus, ErrorMessage: err.Error()}
+	case errors.Is(err, request.ErrBodyTooLarge):
+		return &HandlerError{StatusCode: response.ContentTooLargeStatus, ErrorMessage: err.Error()}
+	default:
+		return &HandlerError{StatusCode: response.InternalServerErrorStatus, ErrorMessage: err.Error()}
+	}
+}
+
+func (s *Server) errorHandler() ErrorHandler {
+	if s.ErrorHandler != nil {
+		return s.ErrorHandler
+	}
+	return writeError
+}
+
+func writeError(w *response.Writer, handlerErr *HandlerError) {
+	w.WriteStatusLine(handlerErr.StatusCode)
+	body := handlerErr.ErrorMessage
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+type closeWriter interface {
+	CloseWrite() error
+}
+
+// closeWriteAndDrain half-closes conn and discards whatever the client is
+// still sending for a short while. Closing a socket with unread data makes
+// the kernel send a reset, which can destroy an error response the client
+// has not read yet.
+func closeWriteAndDrain(conn net.Conn) {
+	if cw, ok := conn.(closeWriter); ok {
+		cw.CloseWrite()
+	}
+	conn.SetReadDeadline(time.Now().Add(lingerTimeout))
+	io.Copy(io.Discard, conn)
 }
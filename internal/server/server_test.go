@@ -0,0 +1,477 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShutdown(t *testing.T) {
+	// Test: Shutdown waits for in-flight handlers and closes idle connections
+	started := make(chan struct{})
+	release := make(chan struct{})
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+		body := "done"
+		w.WriteStatusLine(response.OKStatus)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	})
+	require.NoError(t, err)
+
+	active, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer active.Close()
+	idle, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer idle.Close()
+
+	_, err = active.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	hookCalled := make(chan struct{})
+	s.RegisterOnShutdown(func() { close(hookCalled) })
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- s.Shutdown(context.Background())
+	}()
+
+	idle.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = idle.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+
+	select {
+	case <-hookCalled:
+	case <-time.After(time.Second):
+		t.Fatal("shutdown hook was not called")
+	}
+
+	select {
+	case <-shutdownErr:
+		t.Fatal("shutdown returned before the handler finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	resp, err := io.ReadAll(active)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "HTTP/1.1 200 OK")
+	assert.Contains(t, string(resp), "done")
+	require.NoError(t, <-shutdownErr)
+
+	_, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.Error(t, err)
+
+	// Test: Shutdown force-closes connections once the context expires
+	release = make(chan struct{})
+	defer close(release)
+	started = make(chan struct{})
+	s, err = Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-release
+	})
+	require.NoError(t, err)
+
+	active, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer active.Close()
+	_, err = active.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err = s.Shutdown(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	active.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = active.Read(make([]byte, 1))
+	assert.ErrorIs(t, err, io.EOF)
+}
+
+func TestRequestContext(t *testing.T) {
+	// Test: The context is cancelled when the client disconnects
+	started := make(chan struct{})
+	cancelled := make(chan error, 1)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-req.Context().Done()
+		cancelled <- req.Context().Err()
+	})
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+	conn.Close()
+
+	select {
+	case err := <-cancelled:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("context was not cancelled on disconnect")
+	}
+
+	// Test: The context expires after the request timeout
+	s, err = Serve(0, func(w *response.Writer, req *request.Request) {
+		<-req.Context().Done()
+		cancelled <- req.Context().Err()
+	}, WithRequestTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+
+	conn, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+
+	select {
+	case err := <-cancelled:
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	case <-time.After(time.Second):
+		t.Fatal("context did not time out")
+	}
+
+	// Test: Close cancels in-flight request contexts
+	started = make(chan struct{})
+	s, err = Serve(0, func(w *response.Writer, req *request.Request) {
+		close(started)
+		<-req.Context().Done()
+		cancelled <- req.Context().Err()
+	})
+	require.NoError(t, err)
+
+	conn, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	<-started
+	s.Close()
+
+	select {
+	case err := <-cancelled:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("context was not cancelled on close")
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	handler := func(w *response.Writer, req *request.Request) {
+		body := "ok"
+		w.WriteStatusLine(response.OKStatus)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}
+	s, err := Serve(0, handler,
+		WithReadHeaderTimeout(50*time.Millisecond),
+		WithReadTimeout(200*time.Millisecond),
+		WithIdleTimeout(50*time.Millisecond),
+	)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: Headers that arrive too slowly get a 408
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: local"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "HTTP/1.1 408 Request Timeout")
+
+	// Test: A body that arrives too slowly gets a 408
+	conn, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabc"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "HTTP/1.1 408 Request Timeout")
+	assert.Contains(t, string(resp), "body")
+
+	// Test: An idle connection is closed without a response
+	conn, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Empty(t, resp)
+
+	// Test: A prompt request is unaffected
+	conn, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err = io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "HTTP/1.1 200 OK")
+}
+
+func TestStreamBody(t *testing.T) {
+	bodies := make(chan string, 1)
+	errs := make(chan error, 1)
+	s, err := Serve(0, func(w *response.Writer, req *request.Request) {
+		if req.RequestLine.RequestTarget == "/read" {
+			body, err := io.ReadAll(req.BodyReader())
+			bodies <- string(body)
+			errs <- err
+			if err == nil {
+				<-req.Context().Done()
+				errs <- req.Context().Err()
+			}
+			return
+		}
+		body := "ignored"
+		w.WriteStatusLine(response.OKStatus)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}, WithStreamBody(func(req *request.Request) bool { return true }), WithReadTimeout(200*time.Millisecond))
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Test: The handler reads the body as it arrives, then notices the client going away
+	conn, err := net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("POST /read HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nhello"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	_, err = conn.Write([]byte("world"))
+	require.NoError(t, err)
+	assert.Equal(t, "helloworld", <-bodies)
+	require.NoError(t, <-errs)
+	conn.Close()
+	select {
+	case err := <-errs:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		t.Fatal("context was not cancelled on disconnect")
+	}
+
+	// Test: ReadTimeout still bounds a body that arrives too slowly
+	conn, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST /read HTTP/1.1\r\nHost: localhost\r\nContent-Length: 10\r\n\r\nabc"))
+	require.NoError(t, err)
+	select {
+	case body := <-bodies:
+		assert.Equal(t, "abc", body)
+		var netErr net.Error
+		require.ErrorAs(t, <-errs, &netErr)
+		assert.True(t, netErr.Timeout())
+	case <-time.After(time.Second):
+		t.Fatal("reading the body did not time out")
+	}
+
+	// Test: A body the handler never reads does not cut off the response
+	conn, err = net.Dial("tcp", s.Listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 65536\r\n\r\n" + strings.Repeat("x", 65536)))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(resp), "HTTP/1.1 200 OK")
+	assert.True(t, strings.HasSuffix(string(resp), "ignored"))
+}
+
+func TestConfig(t *testing.T) {
+	var states []ConnState
+	var statesMu sync.Mutex
+	logs := &syncBuffer{}
+	handled := make(chan *HandlerError, 1)
+	s := New(func(w *response.Writer, req *request.Request) {
+		body := "ok"
+		w.WriteStatusLine(response.OKStatus)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	},
+		WithAddr("127.0.0.1:0"),
+		WithNetwork("tcp4"),
+		WithMaxHeaderBytes(64),
+		WithMaxBodyBytes(8),
+		WithLogger(log.New(logs, "", 0)),
+		WithConnState(func(conn net.Conn, state ConnState) {
+			statesMu.Lock()
+			defer statesMu.Unlock()
+			states = append(states, state)
+		}),
+	)
+	require.NoError(t, s.Start())
+	defer s.Close()
+
+	send := func(raw string) string {
+		conn, err := net.Dial("tcp", s.Listener.Addr().String())
+		require.NoError(t, err)
+		defer conn.Close()
+		_, err = conn.Write([]byte(raw))
+		require.NoError(t, err)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		return string(resp)
+	}
+
+	// Test: Binds the configured address and reports connection states
+	resp := send("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 200 OK")
+	assert.True(t, strings.HasPrefix(s.Listener.Addr().String(), "127.0.0.1:"))
+	assert.Contains(t, logs.String(), "the connection has been accepted")
+	assert.Eventually(t, func() bool {
+		statesMu.Lock()
+		defer statesMu.Unlock()
+		return len(states) == 3
+	}, time.Second, 10*time.Millisecond)
+	statesMu.Lock()
+	assert.Equal(t, []ConnState{StateIdle, StateActive, StateClosed}, states)
+	statesMu.Unlock()
+
+	// Test: Oversized headers get a 431
+	resp = send("GET / HTTP/1.1\r\nHost: localhost\r\nX-Padding: " + strings.Repeat("a", 64) + "\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 431 Request Header Fields Too Large")
+
+	// Test: An oversized body gets a 413
+	resp = send("POST / HTTP/1.1\r\nHost: localhost\r\nContent-Length: 9\r\n\r\n123456789")
+	assert.Contains(t, resp, "HTTP/1.1 413 Content Too Large")
+
+	// Test: A custom error handler replaces the default response
+	s.Close()
+	s, err := Serve(0, nil, WithErrorHandler(func(w *response.Writer, err *HandlerError) {
+		handled <- err
+		body := "custom"
+		w.WriteStatusLine(response.BadRequestStatus)
+		w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+		w.WriteBody(body)
+	}))
+	require.NoError(t, err)
+	defer s.Close()
+	resp = send("GARBAGE\r\n\r\n")
+	assert.Contains(t, resp, "HTTP/1.1 400 Bad Request")
+	assert.Contains(t, resp, "custom")
+	assert.Equal(t, response.InternalServerErrorStatus, (<-handled).StatusCode)
+}
+
+// countingListener counts the Write calls made on the connections it
+// accepts.
+type countingListener struct {
+	net.Listener
+	writes *atomic.Int32
+}
+
+func (l countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{Conn: conn, writes: l.writes}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	writes *atomic.Int32
+}
+
+func (c countingConn) Write(p []byte) (int, error) {
+	c.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+func TestWriteBuffering(t *testing.T) {
+	release := make(chan struct{})
+	s := New(func(w *response.Writer, req *request.Request) {
+		h := response.GetDefaultHeaders(0)
+		h.Delete("content-length")
+		h.Set("transfer-encoding", "chunked")
+		for i := range 10 {
+			h.Set(fmt.Sprintf("x-header-%d", i), "value")
+		}
+		w.WriteStatusLine(response.OKStatus)
+		w.WriteHeaders(h)
+		w.WriteChunkedBody([]byte("first"))
+		if req.RequestLine.RequestTarget == "/stream" {
+			w.Flush()
+			<-release
+		}
+		w.WriteChunkedBody([]byte("second"))
+		w.WriteChunkedBodyDone()
+		w.WriteTrailers(nil)
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	writes := &atomic.Int32{}
+	require.NoError(t, s.StartListener(countingListener{Listener: listener, writes: writes}))
+	defer s.Close()
+
+	// Test: A small response goes out in a single write when the handler returns
+	resp := roundTrip(t, "tcp", listener.Addr().String())
+	assert.Contains(t, resp, "x-header-9: value\r\n")
+	assert.True(t, strings.HasSuffix(resp, "6\r\nsecond\r\n0\r\n\r\n"))
+	assert.Equal(t, int32(1), writes.Load())
+
+	// Test: Flush delivers buffered output before the handler returns
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET /stream HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	var received []byte
+	buffer := make([]byte, 1024)
+	for !strings.Contains(string(received), "5\r\nfirst\r\n") {
+		n, err := conn.Read(buffer)
+		require.NoError(t, err)
+		received = append(received, buffer[:n]...)
+	}
+	assert.NotContains(t, string(received), "second")
+	close(release)
+	rest, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(rest), "second")
+}
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+)
+
+// ErrHandlerTimeout is returned by writes from a handler that Timeout has
+// already answered for.
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// Timeout runs the handler with a request context that expires after
+// timeout. If the handler has not written its headers by then, the client
+// gets a 503 Service Unavailable and the handler is abandoned: it keeps
+// running until it notices the context, but everything it writes is
+// discarded. A handler that has already started its response is waited
+// for, since the status can no longer change. Handlers behind Timeout
+// cannot hijack the connection.
+func Timeout(timeout time.Duration) Middleware {
+	return func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			ctx, cancel := context.WithTimeout(req.Context(), timeout)
+			defer cancel()
+
+			t := &timeoutTransport{w: w}
+			done := make(chan struct{})
+
+			go func() {
+				defer close(done)
+				next(response.NewTransportWriter(t), req.WithContext(ctx))
+			}()
+
+			select {
+			case <-done:
+				return
+			case <-ctx.Done():
+			}
+
+			t.mu.Lock()
+			committed := t.committed
+			if !committed {
+				t.timedOut = true
+				writeError(w, &HandlerError{StatusCode: response.ServiceUnavailableStatus, ErrorMessage: "the request timed out"})
+			}
+			t.mu.Unlock()
+
+			if committed {
+				<-done
+			}
+		}
+	}
+}
+
+// timeoutTransport passes a handler's response on to the real Writer until
+// Timeout gives up on the handler.
+type timeoutTransport struct {
+	w *response.Writer
+
+	mu sync.Mutex
+	// committed is set once the handler has started its response.
+	committed bool
+	timedOut  bool
+}
+
+func (t *timeoutTransport) WriteHeaders(statusCode response.StatusCode, h headers.Headers) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timedOut {
+		return ErrHandlerTimeout
+	}
+
+	t.committed = true
+	t.w.WriteStatusLine(statusCode)
+
+	return t.w.WriteHeaders(h)
+}
+
+func (t *timeoutTransport) WriteData(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timedOut {
+		return 0, ErrHandlerTimeout
+	}
+
+	t.committed = true
+
+	return t.w.Write(p)
+}
+
+func (t *timeoutTransport) WriteTrailers(h headers.Headers) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timedOut {
+		return ErrHandlerTimeout
+	}
+
+	return t.w.WriteTrailers(h)
+}
+
+func (t *timeoutTransport) Flush() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.timedOut {
+		return ErrHandlerTimeout
+	}
+
+	return t.w.Flush()
+}
+
+func (t *timeoutTransport) Abort() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.timedOut {
+		t.w.Abort()
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTimeout(t *testing.T) {
+	abandoned := make(chan error, 1)
+	handler := Chain(func(w *response.Writer, req *request.Request) {
+		switch req.RequestLine.RequestTarget {
+		case "/slow":
+			<-req.Context().Done()
+			time.Sleep(20 * time.Millisecond)
+			abandoned <- w.WriteBody("too late")
+		case "/stubborn":
+			time.Sleep(100 * time.Millisecond)
+			_, err := w.Write([]byte("ignored"))
+			abandoned <- err
+		case "/streaming":
+			w.WriteStatusLine(response.OKStatus)
+			w.WriteHeaders(nil)
+			w.WriteBody("started")
+			w.Flush()
+			<-req.Context().Done()
+			w.WriteBody(" and finished")
+		default:
+			okHandler(w, req)
+		}
+	}, func(next Handler) Handler {
+		return func(w *response.Writer, req *request.Request) {
+			w.Header().Set("x-outer", "kept")
+			next(w, req)
+		}
+	}, Timeout(50*time.Millisecond))
+
+	s, err := Serve(0, handler)
+	require.NoError(t, err)
+	defer s.Close()
+	addr := s.Listener.Addr().String()
+
+	send := func(target string) string {
+		return roundTripRequest(t, "tcp", addr, "GET "+target+" HTTP/1.1\r\nHost: localhost\r\n\r\n")
+	}
+
+	// Test: Fast handlers answer as usual, with outer middleware headers
+	resp := send("/")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "x-outer: kept\r\n")
+	assert.True(t, strings.HasSuffix(resp, "\r\n\r\nok"))
+
+	// Test: A handler that has written nothing by the deadline gets a 503
+	resp = send("/slow")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.Contains(t, resp, "x-outer: kept\r\n")
+	assert.True(t, strings.HasSuffix(resp, "the request timed out"))
+
+	// Test: Writes from the abandoned handler are discarded
+	select {
+	case err := <-abandoned:
+		assert.ErrorIs(t, err, ErrHandlerTimeout)
+	case <-time.After(time.Second):
+		t.Fatal("handler did not return")
+	}
+
+	// Test: The deadline applies even to handlers ignoring the context
+	start := time.Now()
+	resp = send("/stubborn")
+	assert.Less(t, time.Since(start), 90*time.Millisecond)
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 503 Service Unavailable\r\n"))
+	assert.NotContains(t, resp, "ignored")
+	assert.ErrorIs(t, <-abandoned, ErrHandlerTimeout)
+
+	// Test: A response already under way is left to finish
+	resp = send("/streaming")
+	assert.True(t, strings.HasPrefix(resp, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, resp, "started")
+	assert.Contains(t, resp, " and finished")
+}
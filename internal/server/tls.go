@@ -0,0 +1,171 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloadInterval is how often Certificates looks at the files on disk for
+// changes. Checks happen lazily during handshakes, so an idle server does no
+// work.
+const certReloadInterval = time.Second
+
+type KeyPair struct {
+	CertFile string
+	KeyFile  string
+}
+
+// Certificates serves one or more certificate/key pairs from disk. During the
+// handshake it picks the certificate matching the client's SNI name and
+// reloads any pair whose files changed since they were last read, so
+// certificates can be rotated without a restart.
+type Certificates struct {
+	pairs          []KeyPair
+	reloadInterval time.Duration
+
+	mu        sync.RWMutex
+	certs     []*tls.Certificate
+	modTimes  []time.Time
+	lastCheck time.Time
+}
+
+func LoadCertificates(pairs ...KeyPair) (*Certificates, error) {
+	if len(pairs) == 0 {
+		return nil, errors.New("at least one key pair is required")
+	}
+
+	c := &Certificates{
+		pairs:          pairs,
+		reloadInterval: certReloadInterval,
+		certs:          make([]*tls.Certificate, len(pairs)),
+		modTimes:       make([]time.Time, len(pairs)),
+	}
+
+	for i := range pairs {
+		if err := c.load(i); err != nil {
+			return nil, err
+		}
+	}
+
+	c.lastCheck = time.Now()
+
+	return c, nil
+}
+
+// TLSConfig returns a config that serves these certificates. Callers may
+// adjust the result further, for example to require client certificates.
+func (c *Certificates) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: c.GetCertificate,
+	}
+}
+
+// GetCertificate implements tls.Config.GetCertificate. It returns the first
+// certificate the client supports for the requested server name, falling
+// back to the first pair when none match.
+func (c *Certificates) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.reloadIfChanged()
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	for _, cert := range c.certs {
+		if hello.SupportsCertificate(cert) == nil {
+			return cert, nil
+		}
+	}
+
+	return c.certs[0], nil
+}
+
+// Reload re-reads every pair from disk. A pair that fails to load keeps
+// serving its previous certificate and the error is returned.
+func (c *Certificates) Reload() error {
+	var errs []error
+	for i := range c.pairs {
+		if err := c.load(i); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (c *Certificates) reloadIfChanged() {
+	c.mu.Lock()
+	if time.Since(c.lastCheck) < c.reloadInterval {
+		c.mu.Unlock()
+		return
+	}
+	c.lastCheck = time.Now()
+	modTimes := append([]time.Time(nil), c.modTimes...)
+	c.mu.Unlock()
+
+	for i, pair := range c.pairs {
+		modTime, err := latestModTime(pair)
+		if err != nil || !modTime.After(modTimes[i]) {
+			continue
+		}
+		c.load(i)
+	}
+}
+
+func (c *Certificates) load(i int) error {
+	pair := c.pairs[i]
+	modTime, err := latestModTime(pair)
+
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(pair.CertFile, pair.KeyFile)
+
+	if err != nil {
+		return fmt.Errorf("loading %s: %w", pair.CertFile, err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.certs[i] = &cert
+	c.modTimes[i] = modTime
+
+	return nil
+}
+
+func latestModTime(pair KeyPair) (time.Time, error) {
+	certInfo, err := os.Stat(pair.CertFile)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	keyInfo, err := os.Stat(pair.KeyFile)
+
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if keyInfo.ModTime().After(certInfo.ModTime()) {
+		return keyInfo.ModTime(), nil
+	}
+
+	return certInfo.ModTime(), nil
+}
+
+// ServeTLS listens for HTTPS on the given TCP port using the certificate and
+// key files, which are reloaded when they change on disk.
+func ServeTLS(port int, handler Handler, certFile string, keyFile string, opts ...Option) (*Server, error) {
+	certs, err := LoadCertificates(KeyPair{CertFile: certFile, KeyFile: keyFile})
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts = append([]Option{WithTLSConfig(certs.TLSConfig())}, opts...)
+
+	return Serve(port, handler, opts...)
+}
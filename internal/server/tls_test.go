@@ -0,0 +1,155 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate for the given DNS names, signed by
+// parent or self-signed when parent is nil.
+func newTestCert(t *testing.T, commonName string, dnsNames []string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: commonName},
+		DNSNames:              dnsNames,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCert{cert: cert, key: key}
+}
+
+func (c *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+}
+
+func (c *testCert) writeFiles(t *testing.T, dir string, name string) KeyPair {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+
+	pair := KeyPair{
+		CertFile: filepath.Join(dir, name+".crt"),
+		KeyFile:  filepath.Join(dir, name+".key"),
+	}
+	require.NoError(t, os.WriteFile(pair.CertFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.cert.Raw}), 0600))
+	require.NoError(t, os.WriteFile(pair.KeyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600))
+
+	return pair
+}
+
+func tlsRoundTrip(t *testing.T, addr string, config *tls.Config) (*tls.ConnectionState, string) {
+	t.Helper()
+	conn, err := tls.Dial("tcp", addr, config)
+	require.NoError(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	state := conn.ConnectionState()
+	return &state, string(resp)
+}
+
+func tlsStateHandler(w *response.Writer, req *request.Request) {
+	body := "plaintext"
+	if req.TLS != nil {
+		body = tls.VersionName(req.TLS.Version) + " " + req.TLS.ServerName
+	}
+	w.WriteStatusLine(response.OKStatus)
+	w.WriteHeaders(response.GetDefaultHeaders(len(body)))
+	w.WriteBody(body)
+}
+
+func TestServeTLS(t *testing.T) {
+	dir := t.TempDir()
+	alpha := newTestCert(t, "alpha", []string{"alpha.test"}, nil, true)
+	beta := newTestCert(t, "beta", []string{"beta.test"}, nil, true)
+	pool := x509.NewCertPool()
+	pool.AddCert(alpha.cert)
+	pool.AddCert(beta.cert)
+
+	// Test: Serves HTTPS from cert and key files and exposes TLS state
+	alphaPair := alpha.writeFiles(t, dir, "alpha")
+	s, err := ServeTLS(0, tlsStateHandler, alphaPair.CertFile, alphaPair.KeyFile)
+	require.NoError(t, err)
+	defer s.Close()
+
+	_, resp := tlsRoundTrip(t, s.Listener.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "alpha.test", MaxVersion: tls.VersionTLS12})
+	assert.Contains(t, resp, "HTTP/1.1 200 OK")
+	assert.Contains(t, resp, "TLS 1.2 alpha.test")
+
+	// Test: Picks the certificate matching the SNI name
+	betaPair := beta.writeFiles(t, dir, "beta")
+	certs, err := LoadCertificates(alphaPair, betaPair)
+	require.NoError(t, err)
+	s2, err := Serve(0, tlsStateHandler, WithTLSConfig(certs.TLSConfig()))
+	require.NoError(t, err)
+	defer s2.Close()
+
+	state, resp := tlsRoundTrip(t, s2.Listener.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "beta.test"})
+	assert.Equal(t, "beta", state.PeerCertificates[0].Subject.CommonName)
+	assert.Contains(t, resp, "TLS 1.3 beta.test")
+	state, _ = tlsRoundTrip(t, s2.Listener.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "alpha.test"})
+	assert.Equal(t, "alpha", state.PeerCertificates[0].Subject.CommonName)
+
+	// Test: Certificates are reloaded after the files change
+	certs.reloadInterval = 0
+	rotated := newTestCert(t, "alpha-rotated", []string{"alpha.test"}, nil, true)
+	rotated.writeFiles(t, dir, "alpha")
+	future := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(alphaPair.CertFile, future, future))
+	pool.AddCert(rotated.cert)
+
+	state, _ = tlsRoundTrip(t, s2.Listener.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "alpha.test"})
+	assert.Equal(t, "alpha-rotated", state.PeerCertificates[0].Subject.CommonName)
+
+	// Test: A broken file keeps the previous certificate
+	require.NoError(t, os.WriteFile(alphaPair.KeyFile, []byte("garbage"), 0600))
+	require.Error(t, certs.Reload())
+	state, _ = tlsRoundTrip(t, s2.Listener.Addr().String(), &tls.Config{RootCAs: pool, ServerName: "alpha.test"})
+	assert.Equal(t, "alpha-rotated", state.PeerCertificates[0].Subject.CommonName)
+}
@@ -0,0 +1,153 @@
+package session
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrInvalidCookie = errors.New("session cookie is invalid")
+
+// Codec protects session data stored in a cookie. The cookie name is bound
+// into the result so a value cannot be replayed under another name.
+type Codec interface {
+	Encode(name string, payload []byte) (string, error)
+	Decode(name string, value string) ([]byte, error)
+}
+
+// Signer is a Codec that signs payloads with HMAC-SHA256. Clients can read
+// but not change the data. The first key signs; every key is accepted when
+// verifying, so keys can be rotated by putting the new one first.
+type Signer struct {
+	keys [][]byte
+}
+
+func NewSigner(keys ...[]byte) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session: at least one signing key is required")
+	}
+
+	for _, key := range keys {
+		if len(key) < 32 {
+			return nil, errors.New("session: signing keys must be at least 32 bytes")
+		}
+	}
+
+	return &Signer{keys: keys}, nil
+}
+
+func (s *Signer) Encode(name string, payload []byte) (string, error) {
+	data := base64.RawURLEncoding.EncodeToString(payload)
+	mac := sign(s.keys[0], name, data)
+
+	return data + "." + base64.RawURLEncoding.EncodeToString(mac), nil
+}
+
+func (s *Signer) Decode(name string, value string) ([]byte, error) {
+	data, encodedMAC, found := strings.Cut(value, ".")
+
+	if !found {
+		return nil, ErrInvalidCookie
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(encodedMAC)
+
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+
+	for _, key := range s.keys {
+		if hmac.Equal(mac, sign(key, name, data)) {
+			payload, err := base64.RawURLEncoding.DecodeString(data)
+
+			if err != nil {
+				return nil, ErrInvalidCookie
+			}
+
+			return payload, nil
+		}
+	}
+
+	return nil, ErrInvalidCookie
+}
+
+func sign(key []byte, name string, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(name + "|" + data))
+	return mac.Sum(nil)
+}
+
+// Encrypter is a Codec that seals payloads with AES-GCM, so clients can
+// neither read nor change the data. Keys must be 16, 24 or 32 bytes. The
+// first key encrypts and every key is tried when decrypting.
+type Encrypter struct {
+	aeads []cipher.AEAD
+}
+
+func NewEncrypter(keys ...[]byte) (*Encrypter, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("session: at least one encryption key is required")
+	}
+
+	e := &Encrypter{}
+
+	for _, key := range keys {
+		block, err := aes.NewCipher(key)
+
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+
+		aead, err := cipher.NewGCM(block)
+
+		if err != nil {
+			return nil, fmt.Errorf("session: %w", err)
+		}
+
+		e.aeads = append(e.aeads, aead)
+	}
+
+	return e, nil
+}
+
+func (e *Encrypter) Encode(name string, payload []byte) (string, error) {
+	aead := e.aeads[0]
+	nonce := make([]byte, aead.NonceSize())
+
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, payload, []byte(name))
+
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (e *Encrypter) Decode(name string, value string) ([]byte, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(value)
+
+	if err != nil {
+		return nil, ErrInvalidCookie
+	}
+
+	for _, aead := range e.aeads {
+		if len(sealed) < aead.NonceSize() {
+			return nil, ErrInvalidCookie
+		}
+
+		nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+		payload, err := aead.Open(nil, nonce, ciphertext, []byte(name))
+
+		if err == nil {
+			return payload, nil
+		}
+	}
+
+	return nil, ErrInvalidCookie
+}
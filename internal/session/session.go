@@ -0,0 +1,391 @@
+package session
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/cookie"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+)
+
+var ErrCookieTooLarge = errors.New("session cookie exceeds 4096 bytes")
+
+const (
+	DefaultCookieName = "session"
+	maxCookieSize     = 4096
+)
+
+// Options configures a Manager. Sessions live in the cookie itself when
+// Store is nil, protected by Codec. With a Store the cookie only carries
+// the session ID, which Codec signs if set.
+type Options struct {
+	// CookieName defaults to DefaultCookieName.
+	CookieName string
+	// Cookie supplies Path, Domain, Secure and SameSite for the session
+	// cookie. Path defaults to "/" and the cookie is always HttpOnly.
+	Cookie cookie.Cookie
+	Codec  Codec
+	Store  Store
+	// IdleTimeout ends sessions not used for this long. Every request then
+	// refreshes the cookie.
+	IdleTimeout time.Duration
+	// AbsoluteTimeout ends sessions this long after they were created, no
+	// matter how active they are.
+	AbsoluteTimeout time.Duration
+	// Logger receives errors saving sessions. Defaults to log.Default().
+	Logger *log.Logger
+}
+
+type Manager struct {
+	Options
+}
+
+func New(opts Options) (*Manager, error) {
+	if opts.Codec == nil && opts.Store == nil {
+		return nil, errors.New("session: a Codec or a Store is required")
+	}
+
+	if opts.CookieName == "" {
+		opts.CookieName = DefaultCookieName
+	}
+
+	if opts.Cookie.Path == "" {
+		opts.Cookie.Path = "/"
+	}
+
+	if opts.Logger == nil {
+		opts.Logger = log.Default()
+	}
+
+	return &Manager{Options: opts}, nil
+}
+
+// Session holds the values of one client's session. Handlers get it with
+// FromRequest. Changes are sent when the response headers are written; with
+// a Store, changes made after that are saved once the handler returns.
+type Session struct {
+	mu        sync.Mutex
+	id        string
+	record    Record
+	isNew     bool
+	dirty     bool
+	renewed   bool
+	destroyed bool
+	hadCookie bool
+	committed bool
+	// staleIDs are IDs given up by Renew, removed from the Store on commit.
+	staleIDs []string
+}
+
+func (s *Session) ID() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.id
+}
+
+// IsNew reports whether the session was started by this request.
+func (s *Session) IsNew() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isNew
+}
+
+func (s *Session) Get(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	value, ok := s.record.Values[key]
+	return value, ok
+}
+
+func (s *Session) Set(key string, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record.Values[key] = value
+	s.dirty = true
+}
+
+func (s *Session) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.record.Values, key)
+	s.dirty = true
+}
+
+// Renew moves the session to a new ID, keeping its values. Call it when
+// the user logs in or their privileges change, so an ID planted by an
+// attacker before login is worthless afterwards.
+func (s *Session) Renew() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.isNew {
+		s.staleIDs = append(s.staleIDs, s.id)
+	}
+	s.id = newID()
+	s.record.Created = time.Now()
+	s.renewed = true
+	s.dirty = true
+}
+
+// Destroy ends the session and tells the client to drop its cookie.
+func (s *Session) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.destroyed = true
+	s.record.Values = map[string]string{}
+}
+
+type contextKey struct{}
+
+// FromRequest returns the session attached by Manager.Middleware, or nil.
+func FromRequest(req *request.Request) *Session {
+	s, _ := req.Context().Value(contextKey{}).(*Session)
+	return s
+}
+
+// Middleware loads the session for each request and sends any changes
+// with the response.
+func (m *Manager) Middleware() server.Middleware {
+	return func(next server.Handler) server.Handler {
+		return func(w *response.Writer, req *request.Request) {
+			s := m.load(req)
+			w.OnWriteHeaders(func() { m.commit(w, s) })
+
+			next(w, req.WithContext(context.WithValue(req.Context(), contextKey{}, s)))
+
+			m.persist(s)
+		}
+	}
+}
+
+// cookiePayload is what a cookie backed session stores in its cookie.
+type cookiePayload struct {
+	ID string `json:"i"`
+	Record
+}
+
+func (m *Manager) load(req *request.Request) *Session {
+	c, err := cookie.Get(req, m.CookieName)
+
+	if err == nil {
+		s := m.decode(c.Value)
+		if s != nil && !m.expired(&s.record) {
+			s.hadCookie = true
+			return s
+		}
+		if s != nil && m.Store != nil {
+			m.Store.Delete(s.id)
+		}
+	}
+
+	// Unknown IDs are never adopted, so a client cannot pick its own.
+	now := time.Now()
+	return &Session{
+		id:        newID(),
+		record:    Record{Values: map[string]string{}, Created: now, LastSeen: now},
+		isNew:     true,
+		hadCookie: err == nil,
+	}
+}
+
+func (m *Manager) decode(value string) *Session {
+	if m.Store == nil {
+		payload, err := m.Codec.Decode(m.CookieName, value)
+
+		if err != nil {
+			return nil
+		}
+
+		var data cookiePayload
+		if json.Unmarshal(payload, &data) != nil || data.ID == "" {
+			return nil
+		}
+
+		if data.Values == nil {
+			data.Values = map[string]string{}
+		}
+
+		return &Session{id: data.ID, record: data.Record}
+	}
+
+	id := value
+	if m.Codec != nil {
+		payload, err := m.Codec.Decode(m.CookieName, value)
+
+		if err != nil {
+			return nil
+		}
+
+		id = string(payload)
+	}
+
+	record, err := m.Store.Load(id)
+
+	if err != nil {
+		if !errors.Is(err, ErrNotFound) {
+			m.Logger.Printf("session: loading session: %v\n", err)
+		}
+		return nil
+	}
+
+	if record.Values == nil {
+		record.Values = map[string]string{}
+	}
+
+	return &Session{id: id, record: *record}
+}
+
+func (m *Manager) expired(record *Record) bool {
+	now := time.Now()
+
+	if m.IdleTimeout > 0 && now.Sub(record.LastSeen) > m.IdleTimeout {
+		return true
+	}
+
+	return m.AbsoluteTimeout > 0 && now.Sub(record.Created) > m.AbsoluteTimeout
+}
+
+// ttl is how much longer the session may live, or zero for no limit.
+func (m *Manager) ttl(record *Record) time.Duration {
+	var ttl time.Duration
+
+	if m.IdleTimeout > 0 {
+		ttl = m.IdleTimeout
+	}
+
+	if m.AbsoluteTimeout > 0 {
+		remaining := time.Until(record.Created.Add(m.AbsoluteTimeout))
+		if ttl == 0 || remaining < ttl {
+			ttl = max(remaining, time.Second)
+		}
+	}
+
+	return ttl
+}
+
+// commit sends the session cookie, and saves the session when a Store is
+// used, just before the response headers are written.
+func (m *Manager) commit(w *response.Writer, s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.committed = true
+
+	if m.Store != nil {
+		for _, id := range s.staleIDs {
+			m.Store.Delete(id)
+		}
+		s.staleIDs = nil
+	}
+
+	if s.destroyed {
+		if m.Store != nil && !s.isNew {
+			m.Store.Delete(s.id)
+		}
+		if s.hadCookie {
+			m.setCookie(w, "", -1)
+		}
+		return
+	}
+
+	if (s.isNew && !s.dirty) || (!s.dirty && !s.renewed && m.IdleTimeout == 0) {
+		return
+	}
+
+	s.record.LastSeen = time.Now()
+	ttl := m.ttl(&s.record)
+	value, err := m.encode(s, ttl)
+
+	if err != nil {
+		m.Logger.Printf("session: saving session: %v\n", err)
+		return
+	}
+
+	s.dirty = false
+	s.renewed = false
+	s.isNew = false
+
+	maxAge := 0
+	if ttl > 0 {
+		maxAge = int(math.Ceil(ttl.Seconds()))
+	}
+
+	m.setCookie(w, value, maxAge)
+}
+
+// persist saves changes made after the headers were written. Only a Store
+// can take them; a cookie has already been sent.
+func (m *Manager) persist(s *Session) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if m.Store == nil || !s.committed || !s.dirty || s.destroyed || s.isNew {
+		return
+	}
+
+	if err := m.Store.Save(s.id, &s.record, m.ttl(&s.record)); err != nil {
+		m.Logger.Printf("session: saving session: %v\n", err)
+		return
+	}
+
+	s.dirty = false
+}
+
+func (m *Manager) encode(s *Session, ttl time.Duration) (string, error) {
+	if m.Store != nil {
+		if err := m.Store.Save(s.id, &s.record, ttl); err != nil {
+			return "", err
+		}
+
+		if m.Codec == nil {
+			return s.id, nil
+		}
+
+		return m.Codec.Encode(m.CookieName, []byte(s.id))
+	}
+
+	payload, err := json.Marshal(cookiePayload{ID: s.id, Record: s.record})
+
+	if err != nil {
+		return "", err
+	}
+
+	value, err := m.Codec.Encode(m.CookieName, payload)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(m.CookieName)+len(value)+1 > maxCookieSize {
+		return "", ErrCookieTooLarge
+	}
+
+	return value, nil
+}
+
+func (m *Manager) setCookie(w *response.Writer, value string, maxAge int) {
+	c := m.Cookie
+	c.Name = m.CookieName
+	c.Value = value
+	c.MaxAge = maxAge
+	c.HttpOnly = true
+	c.Expires = time.Time{}
+
+	if err := cookie.Set(w.Header(), &c); err != nil {
+		m.Logger.Printf("session: %v\n", err)
+	}
+}
+
+func newID() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,282 @@
+package session
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/cookie"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	keyA = bytes.Repeat([]byte("a"), 32)
+	keyB = bytes.Repeat([]byte("b"), 32)
+)
+
+// client sends requests through a session Manager and keeps the cookie it
+// is given, like a browser would.
+type client struct {
+	t       *testing.T
+	handler server.Handler
+	cookie  string
+}
+
+func newClient(t *testing.T, m *Manager, handler func(s *Session, path string) string) *client {
+	return &client{t: t, handler: server.Chain(func(w *response.Writer, req *request.Request) {
+		w.WriteBody(handler(FromRequest(req), req.RequestLine.RequestTarget))
+	}, m.Middleware())}
+}
+
+// get returns the body and Set-Cookie value of a request for path.
+func (c *client) get(path string) (string, string) {
+	raw := "GET " + path + " HTTP/1.1\r\nHost: localhost\r\n"
+	if c.cookie != "" {
+		raw += "Cookie: " + c.cookie + "\r\n"
+	}
+	req, err := request.RequestFromReader(strings.NewReader(raw + "\r\n"))
+	require.NoError(c.t, err)
+
+	var buf bytes.Buffer
+	w := response.NewWriter(&buf)
+	c.handler(w, req)
+	require.NoError(c.t, w.Finish())
+
+	head, body, _ := strings.Cut(buf.String(), "\r\n\r\n")
+	setCookie := ""
+	for _, line := range strings.Split(head, "\r\n") {
+		if value, ok := strings.CutPrefix(line, "set-cookie: "); ok {
+			setCookie = value
+			pair, _, _ := strings.Cut(value, ";")
+			c.cookie = pair
+			if strings.Contains(value, "Max-Age=0") {
+				c.cookie = ""
+			}
+		}
+	}
+	return body, setCookie
+}
+
+func counter(s *Session, path string) string {
+	switch path {
+	case "/login":
+		s.Renew()
+		s.Set("user", "alice")
+		return "welcome"
+	case "/logout":
+		s.Destroy()
+		return "bye"
+	case "/peek":
+		value, _ := s.Get("count")
+		return value
+	}
+	count, _ := s.Get("count")
+	count += "+"
+	s.Set("count", count)
+	return count
+}
+
+func TestCookieSessions(t *testing.T) {
+	signer, err := NewSigner(keyA)
+	require.NoError(t, err)
+	encrypter, err := NewEncrypter(keyA)
+	require.NoError(t, err)
+
+	for name, codec := range map[string]Codec{"signed": signer, "encrypted": encrypter} {
+		m, err := New(Options{Codec: codec, Cookie: cookieTemplate()})
+		require.NoError(t, err)
+		c := newClient(t, m, counter)
+
+		// Test: Values survive between requests
+		body, setCookie := c.get("/")
+		assert.Equal(t, "+", body, name)
+		assert.Contains(t, setCookie, "; Path=/; Secure; HttpOnly; SameSite=Lax", name)
+		body, _ = c.get("/")
+		assert.Equal(t, "++", body, name)
+
+		// Test: Requests that do not change the session send no cookie
+		body, setCookie = c.get("/peek")
+		assert.Equal(t, "++", body, name)
+		assert.Empty(t, setCookie, name)
+
+		// Test: Tampered cookies are ignored
+		saved := c.cookie
+		c.cookie = saved[:len(saved)-2] + "xx"
+		body, _ = c.get("/peek")
+		assert.Empty(t, body, name)
+		c.cookie = saved
+
+		// Test: Destroy clears the cookie
+		_, setCookie = c.get("/logout")
+		assert.Contains(t, setCookie, "Max-Age=0", name)
+		body, _ = c.get("/peek")
+		assert.Empty(t, body, name)
+	}
+
+	// Test: Encrypted sessions cannot be read by the client
+	m, err := New(Options{Codec: encrypter})
+	require.NoError(t, err)
+	c := newClient(t, m, func(s *Session, path string) string {
+		s.Set("secret", "visible-if-signed-only")
+		return ""
+	})
+	c.get("/")
+	assert.NotContains(t, c.cookie, "visible")
+
+	// Test: Visitors that never use the session get no cookie
+	m, err = New(Options{Codec: signer})
+	require.NoError(t, err)
+	c = newClient(t, m, func(s *Session, path string) string { return "" })
+	_, setCookie := c.get("/")
+	assert.Empty(t, setCookie)
+}
+
+func TestKeyRotation(t *testing.T) {
+	for name, newCodec := range map[string]func(keys ...[]byte) (Codec, error){
+		"signer":    func(keys ...[]byte) (Codec, error) { return NewSigner(keys...) },
+		"encrypter": func(keys ...[]byte) (Codec, error) { return NewEncrypter(keys...) },
+	} {
+		oldCodec, err := newCodec(keyA)
+		require.NoError(t, err)
+		rotated, err := newCodec(keyB, keyA)
+		require.NoError(t, err)
+		retired, err := newCodec(keyB)
+		require.NoError(t, err)
+
+		// Test: Values from the old key are still accepted after rotation
+		value, err := oldCodec.Encode("session", []byte("payload"))
+		require.NoError(t, err)
+		payload, err := rotated.Decode("session", value)
+		require.NoError(t, err, name)
+		assert.Equal(t, "payload", string(payload))
+
+		// Test: Once the old key is dropped its values are rejected
+		_, err = retired.Decode("session", value)
+		require.ErrorIs(t, err, ErrInvalidCookie, name)
+
+		// Test: A value is only valid under the cookie name it was made for
+		_, err = oldCodec.Decode("other", value)
+		require.ErrorIs(t, err, ErrInvalidCookie, name)
+	}
+
+	// Test: Short keys are refused
+	_, err := NewSigner([]byte("short"))
+	require.Error(t, err)
+	_, err = NewEncrypter([]byte("short"))
+	require.Error(t, err)
+}
+
+func TestStoreSessions(t *testing.T) {
+	fileStore, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	signer, err := NewSigner(keyA)
+	require.NoError(t, err)
+
+	for name, opts := range map[string]Options{
+		"memory":       {Store: NewMemoryStore()},
+		"file":         {Store: fileStore},
+		"signed-files": {Store: fileStore, Codec: signer},
+	} {
+		m, err := New(opts)
+		require.NoError(t, err)
+		c := newClient(t, m, counter)
+
+		// Test: Values live in the store and the cookie carries an ID
+		body, _ := c.get("/")
+		assert.Equal(t, "+", body, name)
+		body, _ = c.get("/")
+		assert.Equal(t, "++", body, name)
+		assert.NotContains(t, c.cookie, "+")
+
+		// Test: Logging in moves the session to a new ID
+		before := c.cookie
+		_, setCookie := c.get("/login")
+		assert.NotEmpty(t, setCookie, name)
+		assert.NotEqual(t, before, c.cookie, name)
+		body, _ = c.get("/peek")
+		assert.Equal(t, "++", body, name)
+
+		// Test: The old ID no longer works
+		after := c.cookie
+		c.cookie = before
+		body, _ = c.get("/peek")
+		assert.Empty(t, body, name)
+
+		// Test: Unknown IDs are replaced rather than adopted
+		c.cookie = "session=attacker-chosen-id"
+		c.get("/")
+		assert.NotEqual(t, "session=attacker-chosen-id", c.cookie, name)
+
+		// Test: Destroy removes the session from the store
+		c.cookie = after
+		c.get("/logout")
+		c.cookie = after
+		body, _ = c.get("/peek")
+		assert.Empty(t, body, name)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	signer, err := NewSigner(keyA)
+	require.NoError(t, err)
+
+	// Test: Idle sessions expire and active ones are refreshed
+	m, err := New(Options{Codec: signer, IdleTimeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+	c := newClient(t, m, counter)
+	c.get("/")
+	for range 3 {
+		time.Sleep(60 * time.Millisecond)
+		_, setCookie := c.get("/peek")
+		assert.Contains(t, setCookie, "Max-Age=")
+	}
+	body, _ := c.get("/peek")
+	assert.Equal(t, "+", body)
+	time.Sleep(150 * time.Millisecond)
+	body, _ = c.get("/peek")
+	assert.Empty(t, body)
+
+	// Test: Sessions end after the absolute timeout even when active
+	m, err = New(Options{Store: NewMemoryStore(), AbsoluteTimeout: 150 * time.Millisecond})
+	require.NoError(t, err)
+	c = newClient(t, m, counter)
+	c.get("/")
+	time.Sleep(60 * time.Millisecond)
+	body, _ = c.get("/")
+	assert.Equal(t, "++", body)
+	time.Sleep(120 * time.Millisecond)
+	body, _ = c.get("/peek")
+	assert.Empty(t, body)
+
+	// Test: Stores drop expired records
+	store := NewMemoryStore()
+	require.NoError(t, store.Save("id", &Record{Values: map[string]string{"a": "1"}}, 10*time.Millisecond))
+	_, err = store.Load("id")
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+	_, err = store.Load("id")
+	require.ErrorIs(t, err, ErrNotFound)
+
+	fileStore, err := NewFileStore(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, fileStore.Save("id", &Record{}, 10*time.Millisecond))
+	time.Sleep(20 * time.Millisecond)
+	require.NoError(t, fileStore.Sweep())
+	_, err = fileStore.Load("id")
+	require.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestNewRequiresBackend(t *testing.T) {
+	// Test: A Codec or Store must be configured
+	_, err := New(Options{})
+	require.Error(t, err)
+}
+
+func cookieTemplate() cookie.Cookie {
+	return cookie.Cookie{Secure: true, SameSite: cookie.SameSiteLax}
+}
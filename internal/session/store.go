@@ -0,0 +1,233 @@
+package session
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotFound = errors.New("session not found")
+
+// Record is the data kept for a session.
+type Record struct {
+	Values   map[string]string `json:"v"`
+	Created  time.Time         `json:"c"`
+	LastSeen time.Time         `json:"s"`
+}
+
+// Store keeps sessions on the server, so the cookie only carries an ID.
+// Load returns ErrNotFound for unknown or expired sessions. A ttl of zero
+// means the record does not expire.
+type Store interface {
+	Load(id string) (*Record, error)
+	Save(id string, record *Record, ttl time.Duration) error
+	Delete(id string) error
+}
+
+const sweepInterval = time.Minute
+
+// MemoryStore keeps sessions in memory. They are lost on restart and not
+// shared between processes.
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]memoryEntry
+	lastSweep time.Time
+}
+
+type memoryEntry struct {
+	record  Record
+	expires time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]memoryEntry{}, lastSweep: time.Now()}
+}
+
+func (m *MemoryStore) Load(id string) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[id]
+
+	if !ok || isExpired(entry.expires) {
+		return nil, ErrNotFound
+	}
+
+	record := entry.record
+	record.Values = copyValues(entry.record.Values)
+
+	return &record, nil
+}
+
+func (m *MemoryStore) Save(id string, record *Record, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		m.lastSweep = now
+		for key, entry := range m.entries {
+			if isExpired(entry.expires) {
+				delete(m.entries, key)
+			}
+		}
+	}
+
+	stored := *record
+	stored.Values = copyValues(record.Values)
+	m.entries[id] = memoryEntry{record: stored, expires: expiry(ttl)}
+
+	return nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, id)
+
+	return nil
+}
+
+// FileStore keeps each session as a JSON file in a directory. Files are
+// named after a hash of the session ID, so the ID never reaches the file
+// system.
+type FileStore struct {
+	dir string
+}
+
+type fileEntry struct {
+	Record  Record    `json:"r"`
+	Expires time.Time `json:"e"`
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &FileStore{dir: dir}, nil
+}
+
+func (f *FileStore) path(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return filepath.Join(f.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+func (f *FileStore) Load(id string) (*Record, error) {
+	data, err := os.ReadFile(f.path(id))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var entry fileEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, err
+	}
+
+	if isExpired(entry.Expires) {
+		os.Remove(f.path(id))
+		return nil, ErrNotFound
+	}
+
+	return &entry.Record, nil
+}
+
+func (f *FileStore) Save(id string, record *Record, ttl time.Duration) error {
+	data, err := json.Marshal(fileEntry{Record: *record, Expires: expiry(ttl)})
+
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so readers never see a
+	// partly written session.
+	tmp, err := os.CreateTemp(f.dir, ".session-*")
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), f.path(id))
+}
+
+func (f *FileStore) Delete(id string) error {
+	err := os.Remove(f.path(id))
+
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// Sweep removes expired session files. Expired sessions are never loaded,
+// so this only reclaims disk space; call it periodically.
+func (f *FileStore) Sweep() error {
+	files, err := os.ReadDir(f.dir)
+
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+
+		path := filepath.Join(f.dir, file.Name())
+		data, err := os.ReadFile(path)
+
+		if err != nil {
+			continue
+		}
+
+		var entry fileEntry
+		if json.Unmarshal(data, &entry) != nil || isExpired(entry.Expires) {
+			os.Remove(path)
+		}
+	}
+
+	return nil
+}
+
+func expiry(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+func isExpired(expires time.Time) bool {
+	return !expires.IsZero() && time.Now().After(expires)
+}
+
+func copyValues(values map[string]string) map[string]string {
+	copied := make(map[string]string, len(values))
+	for key, value := range values {
+		copied[key] = value
+	}
+	return copied
+}
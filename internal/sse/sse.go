@@ -0,0 +1,209 @@
+package sse
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+)
+
+var (
+	ErrInvalidField = errors.New("sse: event and id fields cannot contain line breaks")
+	ErrClosed       = errors.New("sse: stream closed")
+)
+
+// Event is one server-sent event. Empty fields are left out, except Data
+// which is always sent so the browser dispatches the event.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+	// Retry tells the client how long to wait before reconnecting.
+	Retry time.Duration
+}
+
+// Writer streams events over a chunked text/event-stream response. It is
+// safe for concurrent use, so a heartbeat can run alongside the handler.
+type Writer struct {
+	w  *response.Writer
+	mu sync.Mutex
+
+	closed        bool
+	stopHeartbeat chan struct{}
+}
+
+// NewWriter writes the response head for an event stream and returns a
+// Writer for its events.
+func NewWriter(w *response.Writer) (*Writer, error) {
+	h := response.GetDefaultHeaders(0)
+	h.Delete("content-length")
+	h.Overwrite("content-type", "text/event-stream")
+	h.Set("cache-control", "no-cache")
+	h.Set("transfer-encoding", "chunked")
+
+	if err := w.WriteStatusLine(response.OKStatus); err != nil {
+		return nil, err
+	}
+
+	if err := w.WriteHeaders(h); err != nil {
+		return nil, err
+	}
+
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+
+	return &Writer{w: w}, nil
+}
+
+// Handler returns a handler that opens an event stream, passes it to fn and
+// closes it once fn returns, so a heartbeat never writes to a response the
+// server has already finished.
+func Handler(fn func(events *Writer, req *request.Request)) server.Handler {
+	return func(w *response.Writer, req *request.Request) {
+		events, err := NewWriter(w)
+
+		if err != nil {
+			return
+		}
+
+		defer events.Close()
+		fn(events, req)
+	}
+}
+
+// LastEventID returns the id of the last event a reconnecting client saw,
+// or "" on a first connection.
+func LastEventID(req *request.Request) string {
+	id, _ := req.Headers.Get("last-event-id")
+	return strings.TrimSpace(id)
+}
+
+// Send writes a single event.
+func (s *Writer) Send(event Event) error {
+	if strings.ContainsAny(event.ID, "\r\n\x00") || strings.ContainsAny(event.Event, "\r\n") {
+		return ErrInvalidField
+	}
+
+	var b strings.Builder
+
+	if event.ID != "" {
+		b.WriteString("id: " + event.ID + "\n")
+	}
+
+	if event.Event != "" {
+		b.WriteString("event: " + event.Event + "\n")
+	}
+
+	if event.Retry > 0 {
+		b.WriteString("retry: " + strconv.FormatInt(event.Retry.Milliseconds(), 10) + "\n")
+	}
+
+	// Every line of the payload needs its own data field; the client joins
+	// them back together with newlines.
+	data := strings.ReplaceAll(event.Data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\r", "\n")
+	for _, line := range strings.Split(data, "\n") {
+		b.WriteString("data: " + line + "\n")
+	}
+
+	b.WriteString("\n")
+
+	return s.write(b.String())
+}
+
+// Comment writes a comment line, which clients ignore but which keeps
+// proxies from timing out an idle stream.
+func (s *Writer) Comment(text string) error {
+	var b strings.Builder
+
+	for _, line := range strings.Split(strings.ReplaceAll(text, "\r", ""), "\n") {
+		b.WriteString(": " + line + "\n")
+	}
+
+	b.WriteString("\n")
+
+	return s.write(b.String())
+}
+
+// Heartbeat sends a comment every interval until the stream is closed or
+// the request's context is done. The stream must be closed before the
+// handler returns, as Handler does, or the heartbeat may write after the
+// server has finished the response.
+func (s *Writer) Heartbeat(req *request.Request, interval time.Duration) {
+	s.mu.Lock()
+	if s.stopHeartbeat != nil || s.closed {
+		s.mu.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	s.stopHeartbeat = stop
+	s.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := s.Comment("heartbeat"); err != nil {
+					return
+				}
+			case <-stop:
+				return
+			case <-req.Context().Done():
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the heartbeat and ends the chunked body.
+func (s *Writer) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return nil
+	}
+
+	s.closed = true
+
+	if s.stopHeartbeat != nil {
+		close(s.stopHeartbeat)
+	}
+
+	if _, err := s.w.WriteChunkedBodyDone(); err != nil {
+		return err
+	}
+
+	if err := s.w.WriteTrailers(headers.NewHeaders()); err != nil {
+		return err
+	}
+
+	return s.w.Flush()
+}
+
+// write sends one event as a single chunk and flushes it, so each event
+// reaches the client as soon as it is written.
+func (s *Writer) write(event string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return ErrClosed
+	}
+
+	if _, err := s.w.WriteChunkedBody([]byte(event)); err != nil {
+		return err
+	}
+
+	return s.w.Flush()
+}
@@ -0,0 +1,106 @@
+package sse
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWriter(t *testing.T) {
+	// Test: Writes the event stream response head
+	var buf syncBuffer
+	s, err := NewWriter(response.NewWriter(&buf))
+	require.NoError(t, err)
+	head := buf.String()
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 200 OK\r\n"))
+	assert.Contains(t, head, "content-type: text/event-stream\r\n")
+	assert.Contains(t, head, "cache-control: no-cache\r\n")
+	assert.Contains(t, head, "transfer-encoding: chunked\r\n")
+	assert.NotContains(t, head, "content-length")
+
+	// Test: Every field of an event, one chunk per event
+	start := len(buf.String())
+	require.NoError(t, s.Send(Event{ID: "7", Event: "progress", Data: "50%", Retry: 3 * time.Second}))
+	event := "id: 7\nevent: progress\nretry: 3000\ndata: 50%\n\n"
+	assert.Equal(t, fmt.Sprintf("%x\r\n%s\r\n", len(event), event), buf.String()[start:])
+
+	// Test: Multi-line data gets a data field per line
+	start = len(buf.String())
+	require.NoError(t, s.Send(Event{Data: "line one\r\nline two\rline three\n"}))
+	assert.Contains(t, buf.String()[start:], "data: line one\ndata: line two\ndata: line three\ndata: \n\n")
+
+	// Test: Line breaks in id or event would inject fields
+	require.ErrorIs(t, s.Send(Event{ID: "1\ndata: injected", Data: "x"}), ErrInvalidField)
+	require.ErrorIs(t, s.Send(Event{Event: "a\rb", Data: "x"}), ErrInvalidField)
+
+	// Test: Comments
+	start = len(buf.String())
+	require.NoError(t, s.Comment("hello\nworld"))
+	assert.Contains(t, buf.String()[start:], ": hello\n: world\n\n")
+
+	// Test: Heartbeats until closed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := (&request.Request{Headers: headers.NewHeaders()}).WithContext(ctx)
+	s.Heartbeat(req, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		return strings.Count(buf.String(), ": heartbeat\n") >= 2
+	}, time.Second, 5*time.Millisecond)
+
+	// Test: Close ends the chunked body and later sends fail
+	require.NoError(t, s.Close())
+	assert.True(t, strings.HasSuffix(buf.String(), "0\r\n\r\n"))
+	time.Sleep(30 * time.Millisecond)
+	assert.True(t, strings.HasSuffix(buf.String(), "0\r\n\r\n"))
+	require.ErrorIs(t, s.Send(Event{Data: "late"}), ErrClosed)
+
+	// Test: Last-Event-ID from a reconnecting client
+	req.Headers.Set("Last-Event-ID", " 42 ")
+	assert.Equal(t, "42", LastEventID(req))
+	assert.Equal(t, "", LastEventID(&request.Request{Headers: headers.NewHeaders()}))
+}
+
+func TestHandler(t *testing.T) {
+	// Test: A handler that returns without closing the stream leaves no heartbeat behind
+	var buf syncBuffer
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req := (&request.Request{Headers: headers.NewHeaders()}).WithContext(ctx)
+	Handler(func(events *Writer, req *request.Request) {
+		events.Heartbeat(req, time.Millisecond)
+		for !strings.Contains(buf.String(), ": heartbeat\n") {
+			time.Sleep(time.Millisecond)
+		}
+	})(response.NewWriter(&buf), req)
+	written := buf.String()
+	assert.True(t, strings.HasSuffix(written, "0\r\n\r\n"))
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, written, buf.String())
+}
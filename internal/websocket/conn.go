@@ -0,0 +1,497 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close codes from RFC 6455 section 7.4.1.
+const (
+	CloseNormalClosure    = 1000
+	CloseGoingAway        = 1001
+	CloseProtocolError    = 1002
+	CloseUnsupportedData  = 1003
+	CloseNoStatusReceived = 1005
+	CloseAbnormalClosure  = 1006
+	CloseInvalidPayload   = 1007
+	ClosePolicyViolation  = 1008
+	CloseMessageTooBig    = 1009
+	CloseInternalError    = 1011
+)
+
+const (
+	maxControlPayload = 125
+	closeTimeout      = 5 * time.Second
+)
+
+// DefaultMaxMessageSize limits incoming messages when
+// Upgrader.MaxMessageSize is not set.
+const DefaultMaxMessageSize = 32 << 20
+
+var (
+	ErrClosed          = errors.New("websocket: connection closed")
+	ErrMessageTooLarge = errors.New("websocket: message too large")
+)
+
+// CloseError is returned by ReadMessage once the connection is closed,
+// either by the peer's close frame or by a protocol violation we detected.
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// Conn is a WebSocket connection. One goroutine may read while others
+// write; writes are serialised internally.
+type Conn struct {
+	conn        net.Conn
+	reader      *bufio.Reader
+	isServer    bool
+	subprotocol string
+
+	maxMessageSize    int64
+	writeFragmentSize int
+	compress          bool
+
+	pingHandler func(data []byte) error
+	pongHandler func(data []byte) error
+
+	writeMu   sync.Mutex
+	closeSent bool
+}
+
+func newConn(conn net.Conn, reader *bufio.Reader, isServer bool) *Conn {
+	c := &Conn{conn: conn, reader: reader, isServer: isServer, maxMessageSize: DefaultMaxMessageSize}
+	c.pingHandler = func(data []byte) error {
+		return c.writeControl(opPong, data)
+	}
+	return c
+}
+
+// Subprotocol returns the protocol negotiated during the handshake, if any.
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// SetPingHandler replaces the default handler, which answers with a pong.
+// Handlers run on the goroutine calling ReadMessage.
+func (c *Conn) SetPingHandler(handler func(data []byte) error) {
+	c.pingHandler = handler
+}
+
+func (c *Conn) SetPongHandler(handler func(data []byte) error) {
+	c.pongHandler = handler
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
+
+// ReadMessage returns the next complete data message, reassembling
+// fragments and answering control frames along the way. Once the peer
+// closes, or breaks the protocol, a *CloseError is returned and the
+// connection is closed.
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	var messageType MessageType
+	var message []byte
+	started := false
+	compressed := false
+
+	for {
+		f, err := c.readFrame(int64(len(message)))
+
+		if err != nil {
+			return 0, nil, c.failRead(err)
+		}
+
+		switch f.opcode {
+		case opPing:
+			if err := c.pingHandler(f.payload); err != nil {
+				return 0, nil, err
+			}
+			continue
+		case opPong:
+			if c.pongHandler != nil {
+				if err := c.pongHandler(f.payload); err != nil {
+					return 0, nil, err
+				}
+			}
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opContinuation:
+			if !started {
+				return 0, nil, c.fail(CloseProtocolError, "continuation without a message")
+			}
+			if f.rsv1 {
+				return 0, nil, c.fail(CloseProtocolError, "RSV1 set on a continuation frame")
+			}
+		case opText, opBinary:
+			if started {
+				return 0, nil, c.fail(CloseProtocolError, "new message before the last one finished")
+			}
+			if f.rsv1 && !c.compress {
+				return 0, nil, c.fail(CloseProtocolError, "RSV1 set without compression")
+			}
+			started = true
+			compressed = f.rsv1
+			messageType = MessageType(f.opcode)
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode))
+		}
+
+		message = append(message, f.payload...)
+
+		if f.fin {
+			break
+		}
+	}
+
+	if compressed {
+		decompressed, err := decompressMessage(message, c.maxMessageSize)
+
+		if errors.Is(err, ErrMessageTooLarge) {
+			return 0, nil, c.fail(CloseMessageTooBig, err.Error())
+		}
+
+		if err != nil {
+			return 0, nil, c.fail(CloseInvalidPayload, "invalid compressed data")
+		}
+
+		message = decompressed
+	}
+
+	if messageType == TextMessage && !utf8.Valid(message) {
+		return 0, nil, c.fail(CloseInvalidPayload, "text message is not valid UTF-8")
+	}
+
+	return messageType, message, nil
+}
+
+// WriteMessage sends data as one message, compressed when permessage-deflate
+// was negotiated and split into frames when WriteFragmentSize is set.
+func (c *Conn) WriteMessage(messageType MessageType, data []byte) error {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", messageType)
+	}
+
+	rsv1 := false
+	if c.compress {
+		compressed, err := compressMessage(data)
+
+		if err != nil {
+			return err
+		}
+
+		data = compressed
+		rsv1 = true
+	}
+
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	opcode := byte(messageType)
+	for {
+		chunk := data
+		if c.writeFragmentSize > 0 && len(chunk) > c.writeFragmentSize {
+			chunk = chunk[:c.writeFragmentSize]
+		}
+		data = data[len(chunk):]
+
+		if err := c.writeFrame(opcode, rsv1, len(data) == 0, chunk); err != nil {
+			return err
+		}
+
+		if len(data) == 0 {
+			return nil
+		}
+
+		opcode = opContinuation
+		rsv1 = false
+	}
+}
+
+// Ping sends a ping frame. The reply is delivered to the pong handler.
+func (c *Conn) Ping(data []byte) error {
+	return c.writeControl(opPing, data)
+}
+
+// WriteClose starts the closing handshake. The peer's answering close frame
+// makes a pending or later ReadMessage return a *CloseError.
+func (c *Conn) WriteClose(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason...)
+
+	if len(payload) > maxControlPayload {
+		return errors.New("websocket: close reason too long")
+	}
+
+	return c.writeControl(opClose, payload)
+}
+
+// Close performs the whole closing handshake: it sends a close frame, waits
+// briefly for the peer's reply and then closes the connection. It must not
+// be called while another goroutine is in ReadMessage; use WriteClose then.
+func (c *Conn) Close(code int, reason string) error {
+	if err := c.WriteClose(code, reason); err != nil && !errors.Is(err, ErrClosed) {
+		c.conn.Close()
+		return err
+	}
+
+	c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+
+	for {
+		f, err := c.readFrame(0)
+		if err != nil || f.opcode == opClose {
+			break
+		}
+	}
+
+	return c.conn.Close()
+}
+
+// handleClose answers a close frame with our own, unless we started the
+// handshake, and closes the connection.
+func (c *Conn) handleClose(payload []byte) error {
+	closeErr := &CloseError{Code: CloseNoStatusReceived}
+
+	switch {
+	case len(payload) == 1:
+		return c.fail(CloseProtocolError, "close payload of one byte")
+	case len(payload) >= 2:
+		closeErr.Code = int(binary.BigEndian.Uint16(payload))
+		closeErr.Text = string(payload[2:])
+		if !validCloseCode(closeErr.Code) {
+			return c.fail(CloseProtocolError, "invalid close code")
+		}
+		if !utf8.ValidString(closeErr.Text) {
+			return c.fail(CloseInvalidPayload, "close reason is not valid UTF-8")
+		}
+	}
+
+	if closeErr.Code == CloseNoStatusReceived {
+		c.writeControl(opClose, nil)
+	} else {
+		c.writeControl(opClose, payload[:2])
+	}
+	c.conn.Close()
+
+	return closeErr
+}
+
+// fail closes the connection with code after a protocol violation.
+func (c *Conn) fail(code int, reason string) error {
+	c.WriteClose(code, reason)
+	c.conn.Close()
+	return &CloseError{Code: code, Text: reason}
+}
+
+func (c *Conn) failRead(err error) error {
+	var closeErr *CloseError
+
+	switch {
+	case errors.As(err, &closeErr):
+		return c.fail(closeErr.Code, closeErr.Text)
+	case errors.Is(err, ErrMessageTooLarge):
+		return c.fail(CloseMessageTooBig, err.Error())
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, net.ErrClosed):
+		c.conn.Close()
+		return &CloseError{Code: CloseAbnormalClosure, Text: err.Error()}
+	default:
+		return err
+	}
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	default:
+		return false
+	}
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	opcode  byte
+	payload []byte
+}
+
+// readFrame reads and unmasks one frame. buffered is how much of the
+// current message has already arrived, for enforcing MaxMessageSize.
+func (c *Conn) readFrame(buffered int64) (frame, error) {
+	var header [2]byte
+
+	if _, err := io.ReadFull(c.reader, header[:]); err != nil {
+		return frame{}, err
+	}
+
+	f := frame{
+		fin:    header[0]&0x80 != 0,
+		rsv1:   header[0]&0x40 != 0,
+		opcode: header[0] & 0x0f,
+	}
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7f)
+
+	if header[0]&0x30 != 0 {
+		return frame{}, &CloseError{Code: CloseProtocolError, Text: "reserved bits set"}
+	}
+
+	if masked != c.isServer {
+		return frame{}, &CloseError{Code: CloseProtocolError, Text: "wrong frame masking"}
+	}
+
+	switch length {
+	case 126:
+		var extended [2]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return frame{}, err
+		}
+		length = uint64(binary.BigEndian.Uint16(extended[:]))
+	case 127:
+		var extended [8]byte
+		if _, err := io.ReadFull(c.reader, extended[:]); err != nil {
+			return frame{}, err
+		}
+		length = binary.BigEndian.Uint64(extended[:])
+		if length>>63 != 0 {
+			return frame{}, &CloseError{Code: CloseProtocolError, Text: "invalid payload length"}
+		}
+	}
+
+	if f.opcode >= opClose {
+		if !f.fin || length > maxControlPayload {
+			return frame{}, &CloseError{Code: CloseProtocolError, Text: "invalid control frame"}
+		}
+		if f.rsv1 {
+			return frame{}, &CloseError{Code: CloseProtocolError, Text: "RSV1 set on a control frame"}
+		}
+	} else if length > uint64(max(c.maxMessageSize-buffered, 0)) {
+		return frame{}, ErrMessageTooLarge
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.reader, mask[:]); err != nil {
+			return frame{}, err
+		}
+	}
+
+	f.payload = make([]byte, length)
+
+	if _, err := io.ReadFull(c.reader, f.payload); err != nil {
+		return frame{}, err
+	}
+
+	if masked {
+		maskBytes(mask, f.payload)
+	}
+
+	return f, nil
+}
+
+func (c *Conn) writeControl(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+
+	if c.closeSent {
+		return ErrClosed
+	}
+
+	if opcode == opClose {
+		c.closeSent = true
+	}
+
+	return c.writeFrame(opcode, false, true, payload)
+}
+
+// writeFrame writes one frame, masking it when we are the client. Callers
+// hold writeMu.
+func (c *Conn) writeFrame(opcode byte, rsv1 bool, fin bool, payload []byte) error {
+	frame := make([]byte, 0, 14+len(payload))
+
+	first := opcode
+	if fin {
+		first |= 0x80
+	}
+	if rsv1 {
+		first |= 0x40
+	}
+	frame = append(frame, first)
+
+	maskBit := byte(0)
+	if !c.isServer {
+		maskBit = 0x80
+	}
+
+	switch {
+	case len(payload) <= 125:
+		frame = append(frame, maskBit|byte(len(payload)))
+	case len(payload) <= 0xffff:
+		frame = append(frame, maskBit|126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, maskBit|127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+
+	if c.isServer {
+		frame = append(frame, payload...)
+	} else {
+		var mask [4]byte
+		rand.Read(mask[:])
+		frame = append(frame, mask[:]...)
+		start := len(frame)
+		frame = append(frame, payload...)
+		maskBytes(mask, frame[start:])
+	}
+
+	_, err := c.conn.Write(frame)
+
+	return err
+}
+
+func maskBytes(mask [4]byte, data []byte) {
+	for i := range data {
+		data[i] ^= mask[i%4]
+	}
+}
@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+)
+
+// deflateTail is the empty stored block a sync flush ends with. RFC 7692
+// has senders strip it and receivers put it back.
+var deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+
+// compressMessage deflates a whole message without context takeover, so
+// every message starts from an empty window.
+func compressMessage(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer, err := flate.NewWriter(&buf, flate.DefaultCompression)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+
+	if err := writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	compressed := bytes.TrimSuffix(buf.Bytes(), deflateTail)
+	if len(compressed) == 0 {
+		return []byte{0x00}, nil
+	}
+
+	return compressed, nil
+}
+
+// decompressMessage inflates a message, failing with ErrMessageTooLarge
+// once the output passes limit so small payloads cannot expand without
+// bound. A limit of zero means no limit.
+func decompressMessage(data []byte, limit int64) ([]byte, error) {
+	reader := flate.NewReader(io.MultiReader(
+		bytes.NewReader(data),
+		bytes.NewReader(deflateTail),
+		// A final empty block so the reader sees a proper end of stream.
+		bytes.NewReader([]byte{0x01, 0x00, 0x00, 0xff, 0xff}),
+	))
+	defer reader.Close()
+
+	var source io.Reader = reader
+	if limit > 0 {
+		source = io.LimitReader(reader, limit+1)
+	}
+
+	decompressed, err := io.ReadAll(source)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if limit > 0 && int64(len(decompressed)) > limit {
+		return nil, ErrMessageTooLarge
+	}
+
+	return decompressed, nil
+}
@@ -0,0 +1,243 @@
+package websocket
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/headers"
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+)
+
+// acceptGUID is appended to Sec-WebSocket-Key before hashing, RFC 6455
+// section 1.3.
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+const deflateExtension = "permessage-deflate"
+
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// Upgrader turns HTTP requests into WebSocket connections.
+type Upgrader struct {
+	// Subprotocols lists the protocols the server supports in order of
+	// preference. The first one the client also offers is selected.
+	Subprotocols []string
+	// CheckOrigin decides whether a request's Origin is allowed. Nil
+	// accepts requests without an Origin or with one matching Host.
+	CheckOrigin func(req *request.Request) bool
+	// MaxMessageSize limits incoming messages after reassembly and
+	// decompression. Zero means DefaultMaxMessageSize.
+	MaxMessageSize int64
+	// WriteFragmentSize splits outgoing messages into frames of at most
+	// this many bytes. Zero sends every message as a single frame.
+	WriteFragmentSize int
+	// EnableCompression negotiates permessage-deflate when the client
+	// offers it.
+	EnableCompression bool
+	// HandshakeTimeout bounds writing the handshake response. Zero means no
+	// timeout.
+	HandshakeTimeout time.Duration
+}
+
+// Upgrade validates the opening handshake in req, takes over the
+// connection and answers with 101 Switching Protocols. When the handshake
+// is invalid an error response is written and ErrBadHandshake returned.
+func (u *Upgrader) Upgrade(w *response.Writer, req *request.Request) (*Conn, error) {
+	key, err := u.checkHandshake(req)
+
+	if err != nil {
+		statusCode := response.BadRequestStatus
+		h := response.GetDefaultHeaders(len(err.Error()))
+		switch {
+		case errors.Is(err, errBadVersion):
+			statusCode = response.UpgradeRequiredStatus
+			h.Set("sec-websocket-version", "13")
+		case errors.Is(err, errBadOrigin):
+			statusCode = response.ForbiddenStatus
+		}
+		w.WriteStatusLine(statusCode)
+		w.WriteHeaders(h)
+		w.WriteBody(err.Error())
+		return nil, fmt.Errorf("%w: %v", ErrBadHandshake, err)
+	}
+
+	netConn, rw, err := w.Hijack()
+
+	if err != nil {
+		return nil, err
+	}
+
+	h := headers.NewHeaders()
+	h.Set("upgrade", "websocket")
+	h.Set("connection", "Upgrade")
+	h.Set("sec-websocket-accept", acceptKey(key))
+
+	protocol := u.selectSubprotocol(req)
+	if protocol != "" {
+		h.Set("sec-websocket-protocol", protocol)
+	}
+
+	compress := u.EnableCompression && offersDeflate(req)
+	if compress {
+		h.Set("sec-websocket-extensions", deflateExtension+"; server_no_context_takeover; client_no_context_takeover")
+	}
+
+	if u.HandshakeTimeout > 0 {
+		netConn.SetWriteDeadline(time.Now().Add(u.HandshakeTimeout))
+	}
+
+	handshake := response.NewWriter(netConn)
+	handshake.WriteStatusLine(response.SwitchingProtocolsStatus)
+
+	handshake.WriteHeaders(h)
+
+	if err := handshake.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	netConn.SetWriteDeadline(time.Time{})
+
+	conn := newConn(netConn, rw.Reader, true)
+	if u.MaxMessageSize > 0 {
+		conn.maxMessageSize = u.MaxMessageSize
+	}
+	conn.writeFragmentSize = u.WriteFragmentSize
+	conn.compress = compress
+	conn.subprotocol = protocol
+
+	return conn, nil
+}
+
+var (
+	errBadVersion = errors.New("unsupported Sec-WebSocket-Version, expected 13")
+	errBadOrigin  = errors.New("origin not allowed")
+)
+
+// checkHandshake validates the client's opening handshake, RFC 6455
+// section 4.2.1, and returns its Sec-WebSocket-Key.
+func (u *Upgrader) checkHandshake(req *request.Request) (string, error) {
+	if req.RequestLine.Method != "GET" {
+		return "", errors.New("method must be GET")
+	}
+
+	if !headerHasToken(req.Headers, "connection", "upgrade") {
+		return "", errors.New("missing Connection: Upgrade")
+	}
+
+	if !headerHasToken(req.Headers, "upgrade", "websocket") {
+		return "", errors.New("missing Upgrade: websocket")
+	}
+
+	if version, _ := req.Headers.Get("sec-websocket-version"); strings.TrimSpace(version) != "13" {
+		return "", errBadVersion
+	}
+
+	key, _ := req.Headers.Get("sec-websocket-key")
+	key = strings.TrimSpace(key)
+
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		return "", errors.New("Sec-WebSocket-Key must be 16 base64 encoded bytes")
+	}
+
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+
+	if !checkOrigin(req) {
+		return "", errBadOrigin
+	}
+
+	return key, nil
+}
+
+func (u *Upgrader) selectSubprotocol(req *request.Request) string {
+	offered, ok := req.Headers.Get("sec-websocket-protocol")
+
+	if !ok {
+		return ""
+	}
+
+	for _, supported := range u.Subprotocols {
+		for _, protocol := range strings.Split(offered, ",") {
+			if strings.TrimSpace(protocol) == supported {
+				return supported
+			}
+		}
+	}
+
+	return ""
+}
+
+// sameOrigin accepts requests without an Origin, such as those from
+// non-browser clients, and those whose Origin host matches Host.
+func sameOrigin(req *request.Request) bool {
+	origin, ok := req.Headers.Get("origin")
+
+	if !ok {
+		return true
+	}
+
+	host, _ := req.Headers.Get("host")
+	_, originHost, found := strings.Cut(origin, "://")
+
+	return found && strings.EqualFold(originHost, host)
+}
+
+// offersDeflate reports whether the client offers permessage-deflate in a
+// form we can accept. compress/flate always uses a 32KiB window, so offers
+// that shrink the server's window are declined.
+func offersDeflate(req *request.Request) bool {
+	extensions, ok := req.Headers.Get("sec-websocket-extensions")
+
+	if !ok {
+		return false
+	}
+
+	for _, extension := range strings.Split(extensions, ",") {
+		params := strings.Split(extension, ";")
+		if strings.TrimSpace(params[0]) != deflateExtension {
+			continue
+		}
+
+		acceptable := true
+		for _, param := range params[1:] {
+			name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if name == "server_max_window_bits" && strings.Trim(value, `"`) != "15" {
+				acceptable = false
+			}
+		}
+
+		if acceptable {
+			return true
+		}
+	}
+
+	return false
+}
+
+func acceptKey(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func headerHasToken(h headers.Headers, name string, token string) bool {
+	value, ok := h.Get(name)
+
+	if !ok {
+		return false
+	}
+
+	for _, part := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(part), token) {
+			return true
+		}
+	}
+
+	return false
+}
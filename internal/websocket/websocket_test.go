@@ -0,0 +1,263 @@
+package websocket
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allscorpion/build-http-from-scratch/internal/request"
+	"github.com/allscorpion/build-http-from-scratch/internal/response"
+	"github.com/allscorpion/build-http-from-scratch/internal/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testKey = "dGhlIHNhbXBsZSBub25jZQ=="
+
+// startEcho serves an Upgrader that echoes every message back. Errors that
+// end a connection are sent on the returned channel.
+func startEcho(t *testing.T, upgrader *Upgrader) (string, chan error) {
+	t.Helper()
+	errs := make(chan error, 16)
+	s, err := server.Serve(0, func(w *response.Writer, req *request.Request) {
+		conn, err := upgrader.Upgrade(w, req)
+		if err != nil {
+			errs <- err
+			return
+		}
+		for {
+			messageType, message, err := conn.ReadMessage()
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := conn.WriteMessage(messageType, message); err != nil {
+				errs <- err
+				return
+			}
+		}
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() { s.Close() })
+	return s.Listener.Addr().String(), errs
+}
+
+// dial performs the opening handshake with extra request headers and
+// returns the raw response head along with a client side Conn.
+func dial(t *testing.T, addr string, extraHeaders string) (string, *Conn) {
+	t.Helper()
+	netConn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { netConn.Close() })
+	netConn.SetDeadline(time.Now().Add(2 * time.Second))
+
+	_, err = netConn.Write([]byte("GET /ws HTTP/1.1\r\nHost: " + addr + "\r\n" + extraHeaders + "\r\n"))
+	require.NoError(t, err)
+
+	reader := bufio.NewReader(netConn)
+	var head strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return head.String(), nil
+		}
+		head.WriteString(line)
+		if line == "\r\n" {
+			break
+		}
+	}
+
+	return head.String(), newConn(netConn, reader, false)
+}
+
+const upgradeHeaders = "Connection: Upgrade\r\nUpgrade: websocket\r\nSec-WebSocket-Version: 13\r\nSec-WebSocket-Key: " + testKey + "\r\n"
+
+func TestHandshake(t *testing.T) {
+	// Test: Accept key from RFC 6455 section 1.3
+	assert.Equal(t, "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=", acceptKey(testKey))
+
+	upgrader := &Upgrader{Subprotocols: []string{"chat", "superchat"}}
+	addr, _ := startEcho(t, upgrader)
+
+	// Test: Valid handshake switches protocols
+	head, conn := dial(t, addr, upgradeHeaders)
+	require.NotNil(t, conn)
+	assert.True(t, strings.HasPrefix(head, "HTTP/1.1 101 Switching Protocols\r\n"))
+	assert.Contains(t, head, "sec-websocket-accept: s3pPLMBiTxaQ9kYGzzhZRbK+xOo=\r\n")
+	assert.NotContains(t, head, "sec-websocket-protocol")
+
+	// Test: Picks the server's preferred subprotocol
+	head, _ = dial(t, addr, upgradeHeaders+"Sec-WebSocket-Protocol: superchat, chat\r\n")
+	assert.Contains(t, head, "sec-websocket-protocol: chat\r\n")
+
+	// Test: Unsupported version gets 426 with the supported version
+	head, _ = dial(t, addr, strings.Replace(upgradeHeaders, "Version: 13", "Version: 8", 1))
+	assert.Contains(t, head, "HTTP/1.1 426 Upgrade Required")
+	assert.Contains(t, head, "sec-websocket-version: 13")
+
+	// Test: Malformed key
+	head, _ = dial(t, addr, strings.Replace(upgradeHeaders, testKey, "c2hvcnQ=", 1))
+	assert.Contains(t, head, "HTTP/1.1 400 Bad Request")
+
+	// Test: Missing Upgrade header
+	head, _ = dial(t, addr, strings.Replace(upgradeHeaders, "Upgrade: websocket\r\n", "", 1))
+	assert.Contains(t, head, "HTTP/1.1 400 Bad Request")
+
+	// Test: Cross origin requests are refused by default
+	head, _ = dial(t, addr, upgradeHeaders+"Origin: http://evil.test\r\n")
+	assert.Contains(t, head, "HTTP/1.1 403 Forbidden")
+	head, _ = dial(t, addr, upgradeHeaders+"Origin: http://"+addr+"\r\n")
+	assert.Contains(t, head, "HTTP/1.1 101 Switching Protocols")
+}
+
+func TestConn(t *testing.T) {
+	addr, errs := startEcho(t, &Upgrader{MaxMessageSize: 1024, WriteFragmentSize: 4})
+
+	// Test: Echoes text and binary messages, fragmenting large ones
+	_, conn := dial(t, addr, upgradeHeaders)
+	require.NoError(t, conn.WriteMessage(TextMessage, []byte("hello world")))
+	f, err := conn.readFrame(0)
+	require.NoError(t, err)
+	assert.Equal(t, frame{fin: false, opcode: opText, payload: []byte("hell")}, f)
+	for !f.fin {
+		f, err = conn.readFrame(0)
+		require.NoError(t, err)
+	}
+	require.NoError(t, conn.WriteMessage(BinaryMessage, []byte{1, 2, 3}))
+	messageType, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, BinaryMessage, messageType)
+	assert.Equal(t, []byte{1, 2, 3}, message)
+
+	// Test: Reassembles fragmented messages with a ping in the middle
+	conn.writeMu.Lock()
+	require.NoError(t, conn.writeFrame(opText, false, false, []byte("frag")))
+	require.NoError(t, conn.writeFrame(opPing, false, true, []byte("p")))
+	require.NoError(t, conn.writeFrame(opContinuation, false, true, []byte("mented")))
+	conn.writeMu.Unlock()
+	pong, err := conn.readFrame(0)
+	require.NoError(t, err)
+	assert.Equal(t, frame{fin: true, opcode: opPong, payload: []byte("p")}, pong)
+	_, message, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "fragmented", string(message))
+
+	// Test: Pong handler receives replies to pings
+	pongs := make(chan string, 1)
+	conn.SetPongHandler(func(data []byte) error {
+		pongs <- string(data)
+		return nil
+	})
+	require.NoError(t, conn.Ping([]byte("are you there")))
+	require.NoError(t, conn.WriteMessage(TextMessage, []byte("after ping")))
+	_, message, err = conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Equal(t, "after ping", string(message))
+	assert.Equal(t, "are you there", <-pongs)
+
+	// Test: Close handshake
+	require.NoError(t, conn.WriteClose(CloseNormalClosure, "bye"))
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseNormalClosure, closeErr.Code)
+	require.ErrorAs(t, <-errs, &closeErr)
+	assert.Equal(t, CloseNormalClosure, closeErr.Code)
+	assert.Equal(t, "bye", closeErr.Text)
+
+	// Test: Unmasked client frames are a protocol error
+	_, conn = dial(t, addr, upgradeHeaders)
+	conn.isServer = true
+	conn.writeMu.Lock()
+	require.NoError(t, conn.writeFrame(opText, false, true, []byte("unmasked")))
+	conn.writeMu.Unlock()
+	conn.isServer = false
+	_, _, err = conn.ReadMessage()
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseProtocolError, closeErr.Code)
+	<-errs
+
+	// Test: Messages over the size limit
+	_, conn = dial(t, addr, upgradeHeaders)
+	require.NoError(t, conn.WriteMessage(BinaryMessage, make([]byte, 2048)))
+	_, _, err = conn.ReadMessage()
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+	<-errs
+
+	// Test: Invalid UTF-8 in a text message
+	_, conn = dial(t, addr, upgradeHeaders)
+	require.NoError(t, conn.WriteMessage(TextMessage, []byte{0xff, 0xfe}))
+	_, _, err = conn.ReadMessage()
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseInvalidPayload, closeErr.Code)
+	<-errs
+
+	// Test: Continuation without a message
+	_, conn = dial(t, addr, upgradeHeaders)
+	conn.writeMu.Lock()
+	require.NoError(t, conn.writeFrame(opContinuation, false, true, []byte("orphan")))
+	conn.writeMu.Unlock()
+	_, _, err = conn.ReadMessage()
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseProtocolError, closeErr.Code)
+	<-errs
+
+	// Test: Huge declared lengths are refused before allocating, even without a configured limit
+	unlimited, unlimitedErrs := startEcho(t, &Upgrader{})
+	_, conn = dial(t, unlimited, upgradeHeaders)
+	_, err = conn.conn.Write([]byte{0x82, 0xff, 0x40, 0, 0, 0, 0, 0, 0, 0, 1, 2, 3, 4})
+	require.NoError(t, err)
+	_, _, err = conn.ReadMessage()
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+	require.ErrorAs(t, <-unlimitedErrs, &closeErr)
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+}
+
+func TestCompression(t *testing.T) {
+	addr, errs := startEcho(t, &Upgrader{EnableCompression: true, MaxMessageSize: 1 << 16})
+
+	// Test: Negotiates permessage-deflate and round trips compressed messages
+	head, conn := dial(t, addr, upgradeHeaders+"Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	assert.Contains(t, head, "sec-websocket-extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover")
+	conn.compress = true
+
+	text := strings.Repeat("compress me ", 100)
+	require.NoError(t, conn.WriteMessage(TextMessage, []byte(text)))
+	f, err := conn.readFrame(0)
+	require.NoError(t, err)
+	assert.True(t, f.rsv1)
+	assert.Less(t, len(f.payload), len(text))
+	decompressed, err := decompressMessage(f.payload, 0)
+	require.NoError(t, err)
+	assert.Equal(t, text, string(decompressed))
+
+	require.NoError(t, conn.WriteMessage(TextMessage, nil))
+	_, message, err := conn.ReadMessage()
+	require.NoError(t, err)
+	assert.Empty(t, message)
+
+	// Test: Decompressed size counts against the limit
+	require.NoError(t, conn.WriteMessage(BinaryMessage, make([]byte, 1<<17)))
+	_, _, err = conn.ReadMessage()
+	var closeErr *CloseError
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseMessageTooBig, closeErr.Code)
+	<-errs
+
+	// Test: Not negotiated when the client shrinks the server window
+	head, _ = dial(t, addr, upgradeHeaders+"Sec-WebSocket-Extensions: permessage-deflate; server_max_window_bits=10\r\n")
+	assert.NotContains(t, head, "sec-websocket-extensions")
+
+	// Test: Compressed frames are rejected when not negotiated
+	_, conn = dial(t, addr, upgradeHeaders)
+	conn.writeMu.Lock()
+	require.NoError(t, conn.writeFrame(opText, true, true, []byte{0x00}))
+	conn.writeMu.Unlock()
+	_, _, err = conn.ReadMessage()
+	require.ErrorAs(t, err, &closeErr)
+	assert.Equal(t, CloseProtocolError, closeErr.Code)
+}